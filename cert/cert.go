@@ -0,0 +1,238 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package cert
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+/*
+A certificate binds a short-lived key to the Ed25519 identity key of a node,
+so the identity key can stay offline and a compromised key is replaced by
+issuing a new certificate instead of a new identity. Link certificates
+vouch for the Noise link key, signing certificates for the Ed25519 key
+descriptors are signed with and mix certificates for the Sphinx mix key of
+one epoch. The encoding is
+
+	version (1) | kind (1) | identity (32) | key (32) | epoch (8) |
+	valid from (8) | expires (8) | signature (64)
+
+with times in unix seconds. Only mix certificates have an epoch. The
+signature covers everything before it, prefixed with signContext.
+*/
+type Certificate struct {
+	Kind     Kind
+	Identity ed25519.PublicKey
+	Key      [32]byte
+	// Epoch is the epoch a mix key is used in.
+	Epoch     uint64
+	ValidFrom time.Time
+	Expires   time.Time
+
+	// raw is the signed encoding.
+	raw []byte
+}
+
+type Kind byte
+
+const (
+	KindLink    Kind = 0x1
+	KindMix     Kind = 0x2
+	KindSigning Kind = 0x3
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindLink:
+		return "link"
+	case KindMix:
+		return "mix"
+	case KindSigning:
+		return "signing"
+	}
+
+	return fmt.Sprintf("kind(%d)", byte(k))
+}
+
+const (
+	version     = 0x1
+	signContext = "mixnet certificate v1"
+
+	bodySize = 1 + 1 + ed25519.PublicKeySize + 32 + 8 + 8 + 8
+	Size     = bodySize + ed25519.SignatureSize
+)
+
+var (
+	ErrBadSignature = errors.New("certificate signature is invalid")
+	ErrExpired      = errors.New("certificate has expired")
+	ErrNotYetValid  = errors.New("certificate is not valid yet")
+)
+
+// Sign sets the identity of c to priv's public key, checks c and signs it.
+func Sign(priv ed25519.PrivateKey, c *Certificate) error {
+	c.Identity = priv.Public().(ed25519.PublicKey)
+
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	body := c.appendBody(make([]byte, 0, Size))
+	c.raw = append(body, ed25519.Sign(priv, append([]byte(signContext), body...))...)
+
+	return nil
+}
+
+// Bytes returns the signed encoding of a signed or parsed certificate.
+func (c *Certificate) Bytes() []byte {
+	return c.raw
+}
+
+/*
+Validate checks that c is well formed. It doesn't check the signature or
+the time, see Verify.
+*/
+func (c *Certificate) Validate() error {
+	if len(c.Identity) != ed25519.PublicKeySize {
+		return fmt.Errorf("bad certificate identity size %d", len(c.Identity))
+	}
+
+	switch c.Kind {
+	case KindLink, KindSigning:
+		if c.Epoch != 0 {
+			return fmt.Errorf("%s certificates have no epoch", c.Kind)
+		}
+	case KindMix:
+	default:
+		return fmt.Errorf("certificate has unknown %s", c.Kind)
+	}
+
+	if c.Key == [32]byte{} {
+		return fmt.Errorf("certificate has an empty key")
+	}
+
+	if !c.Expires.After(c.ValidFrom) {
+		return fmt.Errorf("certificate expires before it is valid")
+	}
+
+	return nil
+}
+
+// Valid reports whether c is valid at t.
+func (c *Certificate) Valid(t time.Time) error {
+	if t.Before(c.ValidFrom) {
+		return ErrNotYetValid
+	}
+
+	if !t.Before(c.Expires) {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+/*
+Verify parses b and checks that it is a certificate of kind for key, signed
+by identity and valid at now.
+*/
+func Verify(
+	b []byte,
+	kind Kind,
+	identity ed25519.PublicKey,
+	key []byte,
+	now time.Time,
+) (*Certificate, error) {
+	c, err := Parse(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Kind != kind {
+		return nil, fmt.Errorf("want a %s certificate, got %s", kind, c.Kind)
+	}
+
+	if !c.Identity.Equal(identity) {
+		return nil, fmt.Errorf("certificate belongs to another identity")
+	}
+
+	if string(c.Key[:]) != string(key) {
+		return nil, fmt.Errorf("certificate is for another key")
+	}
+
+	err = c.Valid(now)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Certificate) appendBody(out []byte) []byte {
+	out = append(out, version, byte(c.Kind))
+	out = append(out, c.Identity...)
+	out = append(out, c.Key[:]...)
+	out = binary.BigEndian.AppendUint64(out, c.Epoch)
+	out = binary.BigEndian.AppendUint64(out, uint64(c.ValidFrom.Unix()))
+	out = binary.BigEndian.AppendUint64(out, uint64(c.Expires.Unix()))
+
+	return out
+}
+
+// Parse decodes b and checks its signature.
+func Parse(b []byte) (*Certificate, error) {
+	// the certificate keeps pieces of b.
+	b = slices.Clone(b)
+
+	if len(b) != Size {
+		return nil, fmt.Errorf("bad certificate size %d", len(b))
+	}
+
+	if b[0] != version {
+		return nil, fmt.Errorf("unsupported certificate version %d", b[0])
+	}
+
+	c := Certificate{
+		Kind:     Kind(b[1]),
+		Identity: ed25519.PublicKey(b[2 : 2+ed25519.PublicKeySize]),
+		raw:      b,
+	}
+
+	i := 2 + ed25519.PublicKeySize
+	copy(c.Key[:], b[i:])
+	i += 32
+
+	c.Epoch = binary.BigEndian.Uint64(b[i:])
+	c.ValidFrom = time.Unix(int64(binary.BigEndian.Uint64(b[i+8:])), 0)
+	c.Expires = time.Unix(int64(binary.BigEndian.Uint64(b[i+16:])), 0)
+
+	err := c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if !ed25519.Verify(c.Identity, append([]byte(signContext), b[:bodySize]...), b[bodySize:]) {
+		return nil, ErrBadSignature
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,130 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package cert
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newCertificate(t *testing.T, kind Kind, epoch uint64) (*Certificate, ed25519.PrivateKey) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+
+	c := Certificate{
+		Kind:      kind,
+		Key:       [32]byte{1, 2, 3},
+		Epoch:     epoch,
+		ValidFrom: now,
+		Expires:   now.Add(time.Hour),
+	}
+
+	err = Sign(priv, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &c, priv
+}
+
+func TestEncoding(t *testing.T) {
+	c, priv := newCertificate(t, KindMix, 42)
+
+	if len(c.Bytes()) != Size {
+		t.Fatalf("certificate should be %d bytes. %d", Size, len(c.Bytes()))
+	}
+
+	got, err := Parse(c.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Kind != KindMix || got.Epoch != 42 || got.Key != c.Key ||
+		!got.Identity.Equal(priv.Public()) ||
+		!got.ValidFrom.Equal(c.ValidFrom) || !got.Expires.Equal(c.Expires) {
+		t.Fatalf("parsed certificate differs. %+v", got)
+	}
+
+	for i := range Size {
+		b := append([]byte(nil), c.Bytes()...)
+		b[i] ^= 1
+
+		if _, err := Parse(b); err == nil {
+			t.Fatalf("flipping byte %d should fail", i)
+		}
+	}
+
+	if _, err := Parse(c.Bytes()[:Size-1]); err == nil {
+		t.Fatal("short certificate should fail")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	now := time.Now()
+
+	for name, c := range map[string]Certificate{
+		"kind":          {Kind: 9, Key: [32]byte{1}, ValidFrom: now, Expires: now.Add(time.Hour)},
+		"link epoch":    {Kind: KindLink, Key: [32]byte{1}, Epoch: 1, ValidFrom: now, Expires: now.Add(time.Hour)},
+		"signing epoch": {Kind: KindSigning, Key: [32]byte{1}, Epoch: 1, ValidFrom: now, Expires: now.Add(time.Hour)},
+		"empty key":     {Kind: KindLink, ValidFrom: now, Expires: now.Add(time.Hour)},
+		"expires":       {Kind: KindLink, Key: [32]byte{1}, ValidFrom: now, Expires: now},
+	} {
+		if err := Sign(priv, &c); err == nil {
+			t.Fatalf("certificate with bad %s should not be signed", name)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	c, priv := newCertificate(t, KindLink, 0)
+	identity := priv.Public().(ed25519.PublicKey)
+	now := c.ValidFrom.Add(time.Minute)
+
+	if _, err := Verify(c.Bytes(), KindLink, identity, c.Key[:], now); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Verify(c.Bytes(), KindMix, identity, c.Key[:], now); err == nil {
+		t.Fatal("a link certificate is not a mix certificate")
+	}
+
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := Verify(c.Bytes(), KindLink, other, c.Key[:], now); err == nil {
+		t.Fatal("certificate should not verify for another identity")
+	}
+
+	if _, err := Verify(c.Bytes(), KindLink, identity, make([]byte, 32), now); err == nil {
+		t.Fatal("certificate should not verify for another key")
+	}
+
+	if _, err := Verify(c.Bytes(), KindLink, identity, c.Key[:], c.ValidFrom.Add(-time.Second)); !errors.Is(err, ErrNotYetValid) {
+		t.Fatalf("certificate should not be valid early. %v", err)
+	}
+
+	if _, err := Verify(c.Bytes(), KindLink, identity, c.Key[:], c.Expires); !errors.Is(err, ErrExpired) {
+		t.Fatalf("certificate should expire. %v", err)
+	}
+}
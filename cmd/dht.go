@@ -0,0 +1,170 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/LibSEA/mixnet/dht"
+	"github.com/LibSEA/mixnet/dhtctl"
+	"github.com/LibSEA/mixnet/keys"
+	"github.com/spf13/cobra"
+)
+
+var dhtOpts = dhtctl.Options{
+	Difficulty: dht.DefaultDifficulty,
+	Timeout:    time.Minute,
+}
+
+var dhtServeOpts = dhtctl.ServeOptions{
+	Host:  "localhost",
+	Port:  8070,
+	DB:    "dht.db",
+	Admin: "dht.sock",
+	Keys:  defaultKeys,
+}
+
+var dhtLookupOpts = dhtctl.LookupOptions{Disjoint: 1}
+
+var dhtPutOpts dhtctl.PutOptions
+
+var dhtTableOpts = dhtctl.TableOptions{Admin: dhtServeOpts.Admin}
+
+var dhtCrawlOpts = dhtctl.CrawlOptions{
+	Max:          10000,
+	Parallel:     16,
+	QueryTimeout: 5 * time.Second,
+}
+
+// dhtCmd represents the dht command
+var dhtCmd = &cobra.Command{
+	Use:   "dht",
+	Short: "Run and inspect DHT nodes",
+	Long: `Run a DHT node, or look into the DHT. Contacts are written as
+ip:port/hex-key, which is what serve logs when it starts.
+
+Every command but serve and table joins the DHT through --seed for as long
+as it runs. Pass --json for output other programs can read.`,
+}
+
+var dhtServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run a DHT node",
+	Run: func(cmd *cobra.Command, args []string) {
+		dhtServeOpts.Options = dhtOpts
+		os.Exit(dhtctl.Serve(dhtServeOpts))
+	},
+}
+
+var dhtLookupCmd = &cobra.Command{
+	Use:   "lookup [hex-key]",
+	Short: "Look up the record under a key",
+	Long: `Look up the record under a key. The key is either given in hex or
+made from --public-key and --salt. Exits with 2 when there is no record.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dhtLookupOpts.Options = dhtOpts
+		if len(args) == 1 {
+			dhtLookupOpts.Key = args[0]
+		}
+		os.Exit(dhtctl.Lookup(dhtLookupOpts))
+	},
+}
+
+var dhtPutCmd = &cobra.Command{
+	Use:   "put value",
+	Short: "Sign a record with a local key and store it",
+	Long: `Sign value with the ed25519 key in --key and store it in the DHT.
+The key file is PKCS #8 PEM, as made by openssl genpkey -algorithm ed25519.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dhtPutOpts.Options = dhtOpts
+		dhtPutOpts.Value = args[0]
+		os.Exit(dhtctl.Put(dhtPutOpts))
+	},
+}
+
+var dhtTableCmd = &cobra.Command{
+	Use:   "table",
+	Short: "Show the routing table of a running serve",
+	Long: `Show the routing table of the serve listening on --admin, with how
+full each bucket is and when each contact was last seen. With --db it shows
+the table a stopped serve saved in its database instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dhtTableOpts.Options = dhtOpts
+		os.Exit(dhtctl.Table(dhtTableOpts))
+	},
+}
+
+var dhtCrawlCmd = &cobra.Command{
+	Use:   "crawl",
+	Short: "Visit every reachable node and report on the network",
+	Run: func(cmd *cobra.Command, args []string) {
+		dhtCrawlOpts.Options = dhtOpts
+		os.Exit(dhtctl.Crawl(dhtCrawlOpts))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dhtCmd)
+	dhtCmd.AddCommand(dhtServeCmd, dhtLookupCmd, dhtPutCmd, dhtTableCmd, dhtCrawlCmd)
+
+	dhtCmd.PersistentFlags().BoolVar(&dhtOpts.JSON, "json", dhtOpts.JSON, "print results as json")
+	dhtCmd.PersistentFlags().StringArrayVar(&dhtOpts.Seeds, "seed", nil, "contact to join the dht through")
+	dhtCmd.PersistentFlags().IntVar(
+		&dhtOpts.Difficulty,
+		"difficulty",
+		dhtOpts.Difficulty,
+		"node id puzzle difficulty of the network",
+	)
+	dhtCmd.PersistentFlags().DurationVar(&dhtOpts.Timeout, "timeout", dhtOpts.Timeout, "how long a command may take")
+
+	dhtServeCmd.Flags().StringVar(&dhtServeOpts.Host, "host", dhtServeOpts.Host, "host to listen on")
+	dhtServeCmd.Flags().Uint16Var(&dhtServeOpts.Port, "port", dhtServeOpts.Port, "port to listen on")
+	dhtServeCmd.Flags().StringVar(&dhtServeOpts.DB, "db", dhtServeOpts.DB, "database directory")
+	dhtServeCmd.Flags().StringVar(&dhtServeOpts.Admin, "admin", dhtServeOpts.Admin, "admin socket, empty to turn it off")
+	keyFlags(dhtServeCmd.Flags(), &dhtServeOpts.Keys)
+
+	dhtLookupCmd.Flags().StringVar(&dhtLookupOpts.PublicKey, "public-key", "", "hex ed25519 key the record is signed with")
+	dhtLookupCmd.Flags().StringVar(&dhtLookupOpts.Salt, "salt", "", "salt of the record")
+	dhtLookupCmd.Flags().IntVar(&dhtLookupOpts.Disjoint, "disjoint", dhtLookupOpts.Disjoint, "number of disjoint lookup paths")
+
+	dhtPutCmd.Flags().StringVar(&dhtPutOpts.KeyFile, "key", "", "PEM ed25519 private key to sign with")
+	dhtPutCmd.Flags().IntVar(
+		&dhtPutOpts.PassphraseFD,
+		"passphrase-fd",
+		-1,
+		"file descriptor to read the passphrase of an encrypted key from. defaults to $"+keys.PassphraseEnv,
+	)
+	dhtPutCmd.Flags().StringVar(&dhtPutOpts.Salt, "salt", "", "salt of the record")
+	dhtPutCmd.Flags().Uint64Var(&dhtPutOpts.Seq, "seq", 0, "sequence number. defaults to the unix time")
+	_ = dhtPutCmd.MarkFlagRequired("key")
+
+	dhtTableCmd.Flags().StringVar(&dhtTableOpts.Admin, "admin", dhtTableOpts.Admin, "admin socket of the running serve")
+	dhtTableCmd.Flags().StringVar(&dhtTableOpts.DB, "db", dhtTableOpts.DB, "database directory of a stopped serve")
+
+	dhtCrawlCmd.Flags().IntVar(&dhtCrawlOpts.Max, "max", dhtCrawlOpts.Max, "stop after this many nodes")
+	dhtCrawlCmd.Flags().IntVar(&dhtCrawlOpts.Parallel, "parallel", dhtCrawlOpts.Parallel, "nodes to ask at once")
+	dhtCrawlCmd.Flags().DurationVar(
+		&dhtCrawlOpts.QueryTimeout,
+		"query-timeout",
+		dhtCrawlOpts.QueryTimeout,
+		"how long to wait for each node",
+	)
+}
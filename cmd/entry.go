@@ -24,6 +24,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var entryKeys = defaultKeys
+
 // entryCmd represents the entry command
 var entryCmd = &cobra.Command{
 	Use:   "entry",
@@ -40,6 +42,7 @@ to quickly create a Cobra application.`,
 		os.Exit(entry.Run(entry.Options{
     		Port: port,
     		Host: host,
+			Keys: entryKeys,
 		}))
 	},
 }
@@ -53,4 +56,5 @@ func init() {
     	"host to listen on",
 	)
 	entryCmd.PersistentFlags().Uint16("port", 8080, "port to connect to")
+	keyFlags(entryCmd.PersistentFlags(), &entryKeys)
 }
@@ -0,0 +1,228 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/LibSEA/mixnet/epoch"
+	"github.com/LibSEA/mixnet/keys"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// defaultKeys is where every role loads its keys from by default.
+var defaultKeys = keys.Source{Dir: "keys", PassphraseFD: -1}
+
+// keyFlags adds the flags that say where the keys in src are loaded from.
+func keyFlags(flags *pflag.FlagSet, src *keys.Source) {
+	flags.StringVar(&src.Dir, "keys", src.Dir, "key directory")
+	flags.IntVar(
+		&src.PassphraseFD,
+		"passphrase-fd",
+		src.PassphraseFD,
+		"file descriptor to read the key passphrase from. defaults to $"+keys.PassphraseEnv,
+	)
+}
+
+const newPassphraseEnv = "MIXNET_NEW_PASSPHRASE"
+
+var (
+	keyPasswdKeys = defaultKeys
+	keyPasswdFD   = -1
+
+	keyRotateKeys     = defaultKeys
+	keyRotateIdentity string
+	keyRotateLifetime = keys.LinkLifetime
+
+	keyMixKeys     = defaultKeys
+	keyMixIdentity string
+	keyMixEpochs   = 72
+)
+
+// keyCmd represents the key command
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Manage the keys of a node",
+}
+
+// keyPasswdCmd represents the key passwd command
+var keyPasswdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "Change the passphrase of the keys of a node",
+	Long: `Encrypt the keys in --keys with a new passphrase. The current passphrase
+is read from --passphrase-fd or $MIXNET_PASSPHRASE and the new one from
+--new-passphrase-fd or $MIXNET_NEW_PASSPHRASE. Passphrases read from a file
+descriptor end at a newline, so both can be given on the same one, the
+current first. An empty new passphrase stores the keys in plaintext.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		old, err := keys.ReadPassphrase(keyPasswdKeys.PassphraseFD, keys.PassphraseEnv)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		next, err := keys.ReadPassphrase(keyPasswdFD, newPassphraseEnv)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		err = keys.Passwd(keyPasswdKeys.Dir, old, next)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if len(next) == 0 {
+			fmt.Println("keys are stored in plaintext")
+		}
+	},
+}
+
+// keyRotateCmd represents the key rotate command
+var keyRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the link and signing keys of a node",
+	Long: `Replace the link and signing keys in --keys with new ones, certified by
+the identity key for --lifetime. The identity doesn't change, so peers that
+know the node by its identity keep trusting it. Rotate before the
+certificates expire, or at once when a key may have leaked.
+
+The identity key is read from --identity, by default identity.key in --keys.
+The new keys are encrypted with the passphrase of the identity key.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		passphrase, err := keys.ReadPassphrase(keyRotateKeys.PassphraseFD, keys.PassphraseEnv)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		path := keyRotateIdentity
+		if path == "" {
+			path = filepath.Join(keyRotateKeys.Dir, keys.IdentityFile)
+		}
+
+		identity, err := keys.ReadIdentity(path, passphrase)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		// another identity would make the node a new one.
+		err = keys.CheckIdentity(keyRotateKeys.Dir, identity)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		k, err := keys.Rotate(keyRotateKeys.Dir, identity, passphrase, keyRotateLifetime)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("link_key %s\n", hex.EncodeToString(k.Link.Public))
+		fmt.Printf("link_key_expires %s\n", k.LinkCert.Expires.Format(time.RFC3339))
+		fmt.Printf("signing_key %s\n", hex.EncodeToString(k.SigningCert.Key[:]))
+	},
+}
+
+// keyMixCmd represents the key mix command
+var keyMixCmd = &cobra.Command{
+	Use:   "mix",
+	Short: "Make the mix keys of the epochs ahead",
+	Long: `Make and certify the mix keys of the current epoch and the --epochs after
+it, for a mix whose identity key is kept offline. The mix publishes and
+uses them as their epochs come, and stops publishing when it runs out, so
+run this again before then.
+
+The identity key is read from --identity, by default identity.key in --keys.
+The mix keys are encrypted with the passphrase of the keys in --keys.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		passphrase, err := keys.ReadPassphrase(keyMixKeys.PassphraseFD, keys.PassphraseEnv)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		k, err := keys.Load(keyMixKeys.Dir, passphrase)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if keyMixIdentity != "" {
+			k.Identity, err = keys.ReadIdentity(keyMixIdentity, passphrase)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+
+		if k.Identity != nil && !k.Public.Equal(k.Identity.Public()) {
+			fmt.Fprintln(os.Stderr, "the identity key doesn't match the keys")
+			os.Exit(1)
+		}
+
+		now := time.Now()
+		first := epoch.Default.At(now)
+		last := first + uint64(keyMixEpochs)
+
+		err = k.MakeMix(epoch.Default, first, last, now)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("mix_keys %d-%d\n", first, last)
+		fmt.Printf("mix_keys_until %s\n", epoch.Default.Start(last+1).Format(time.RFC3339))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keyCmd)
+	keyCmd.AddCommand(keyPasswdCmd)
+	keyCmd.AddCommand(keyRotateCmd)
+	keyCmd.AddCommand(keyMixCmd)
+
+	keyFlags(keyPasswdCmd.Flags(), &keyPasswdKeys)
+	keyPasswdCmd.Flags().IntVar(
+		&keyPasswdFD,
+		"new-passphrase-fd",
+		keyPasswdFD,
+		"file descriptor to read the new passphrase from. defaults to $"+newPassphraseEnv,
+	)
+
+	keyFlags(keyRotateCmd.Flags(), &keyRotateKeys)
+	keyRotateCmd.Flags().StringVar(&keyRotateIdentity, "identity", "", "identity key file. defaults to the one in --keys")
+	keyRotateCmd.Flags().DurationVar(
+		&keyRotateLifetime,
+		"lifetime",
+		keyRotateLifetime,
+		"how long the link and signing certificates are valid",
+	)
+
+	keyFlags(keyMixCmd.Flags(), &keyMixKeys)
+	keyMixCmd.Flags().StringVar(&keyMixIdentity, "identity", "", "identity key file. defaults to the one in --keys")
+	keyMixCmd.Flags().IntVar(&keyMixEpochs, "epochs", keyMixEpochs, "epochs after the current one to make mix keys for")
+}
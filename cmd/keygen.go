@@ -0,0 +1,74 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/LibSEA/mixnet/keys"
+	"github.com/spf13/cobra"
+)
+
+var keygenKeys = defaultKeys
+
+// keygenCmd represents the keygen command
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Make the long-term keys of a node",
+	Long: `Make a key directory holding the Ed25519 identity key, the X25519 Noise
+link key and the Ed25519 signing key of a node, with certificates of the
+link and signing keys signed by the identity key. Every role loads its keys
+from --keys, so a node keeps its identity across restarts. Existing keys
+are never overwritten.
+
+The certificates expire, renew them with mixnet key rotate. Mixes sign
+their descriptors with the signing key and run without identity.key, so it
+can be kept offline, once their mix keys are made with mixnet key mix.
+Authorities need it to sign documents.
+
+The keys are encrypted when a passphrase is given with --passphrase-fd or
+$MIXNET_PASSPHRASE, daemons then need it on every start.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		passphrase, err := keys.ReadPassphrase(keygenKeys.PassphraseFD, keys.PassphraseEnv)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		k, err := keys.Generate(keygenKeys.Dir, passphrase)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("identity %s\n", hex.EncodeToString(k.Identity.Public().(ed25519.PublicKey)))
+		fmt.Printf("link_key %s\n", hex.EncodeToString(k.Link.Public))
+		fmt.Printf("link_key_expires %s\n", k.LinkCert.Expires.Format(time.RFC3339))
+		fmt.Printf("signing_key %s\n", hex.EncodeToString(k.SigningCert.Key[:]))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keygenCmd)
+
+	keyFlags(keygenCmd.PersistentFlags(), &keygenKeys)
+}
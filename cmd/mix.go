@@ -0,0 +1,140 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/LibSEA/mixnet/mix"
+	"github.com/LibSEA/mixnet/mixer"
+	"github.com/spf13/cobra"
+)
+
+var mixOpts = mix.Options{
+	Host:           "localhost",
+	Port:           8081,
+	MaxDelay:       time.Minute,
+	Strategy:       mixer.StrategyStopAndGo,
+	Tick:           10 * time.Millisecond,
+	DB:             "mix.db",
+	Keys:           defaultKeys,
+	ReplayCapacity: 1 << 20,
+	Ahead:          2,
+	Grace:          5 * time.Minute,
+	Mixer: mixer.Config{
+		Threshold: 100,
+		Interval:  10 * time.Second,
+		Pool:      10,
+		Prob:      0.5,
+		Delay:     time.Second,
+	},
+}
+
+// mixCmd represents the mix command
+var mixCmd = &cobra.Command{
+	Use:   "mix",
+	Short: "Run a mix node",
+	Long: `Run a mix node that accepts sphinx packets over Noise sessions,
+mixes them and forwards them to the next hop.
+
+The default stop-and-go strategy holds each packet for an exponentially
+distributed delay. The other strategies send packets in batches.
+
+Mix keys change every epoch. Keys are made --ahead epochs early and, with
+--pki, uploaded to the directory authorities in a signed descriptor, which
+needs the identity key. Packets for the previous epoch's key are accepted
+for --grace into an epoch, then the key and its replay tags are deleted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(mix.Run(mixOpts))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mixCmd)
+
+	mixCmd.PersistentFlags().StringVar(&mixOpts.Host, "host", mixOpts.Host, "host to listen on")
+	mixCmd.PersistentFlags().Uint16Var(&mixOpts.Port, "port", mixOpts.Port, "port to listen on")
+	mixCmd.PersistentFlags().DurationVar(
+		&mixOpts.MaxDelay,
+		"max-delay",
+		mixOpts.MaxDelay,
+		"longest delay a packet can ask for",
+	)
+	mixCmd.PersistentFlags().StringVar(
+		&mixOpts.Strategy,
+		"strategy",
+		mixOpts.Strategy,
+		"mixing strategy, one of "+strings.Join(mixer.Strategies, ", "),
+	)
+	mixCmd.PersistentFlags().StringVar(&mixOpts.DB, "db", mixOpts.DB, "database directory")
+	keyFlags(mixCmd.PersistentFlags(), &mixOpts.Keys)
+	mixCmd.PersistentFlags().IntVar(
+		&mixOpts.ReplayCapacity,
+		"replay-capacity",
+		mixOpts.ReplayCapacity,
+		"about how many packets arrive per epoch",
+	)
+	mixCmd.PersistentFlags().IntVar(&mixOpts.Ahead, "ahead", mixOpts.Ahead, "epochs to make mix keys ahead")
+	mixCmd.PersistentFlags().DurationVar(
+		&mixOpts.Grace,
+		"grace",
+		mixOpts.Grace,
+		"how long into an epoch the previous mix key still works",
+	)
+	mixCmd.PersistentFlags().StringArrayVar(
+		&mixOpts.PKI,
+		"pki",
+		nil,
+		"directory authority host:port to upload the descriptor to, can be repeated",
+	)
+	mixCmd.PersistentFlags().StringVar(&mixOpts.Addr, "addr", "", "ip:port to publish. defaults to the listen address")
+	mixCmd.PersistentFlags().Uint8Var(&mixOpts.Layer, "layer", 0, "mix layer to publish")
+	mixCmd.PersistentFlags().DurationVar(&mixOpts.Tick, "tick", mixOpts.Tick, "how often to check for due packets")
+	mixCmd.PersistentFlags().DurationVar(
+		&mixOpts.Mixer.Delay,
+		"delay",
+		mixOpts.Mixer.Delay,
+		"stop-and-go: mean delay for packets without a sender chosen delay",
+	)
+	mixCmd.PersistentFlags().IntVar(
+		&mixOpts.Mixer.Threshold,
+		"threshold",
+		mixOpts.Mixer.Threshold,
+		"threshold: batch size",
+	)
+	mixCmd.PersistentFlags().DurationVar(
+		&mixOpts.Mixer.Interval,
+		"interval",
+		mixOpts.Mixer.Interval,
+		"timed, timed-pool, binomial: time between batches",
+	)
+	mixCmd.PersistentFlags().IntVar(
+		&mixOpts.Mixer.Pool,
+		"pool",
+		mixOpts.Mixer.Pool,
+		"timed-pool: packets kept back each batch",
+	)
+	mixCmd.PersistentFlags().Float64Var(
+		&mixOpts.Mixer.Prob,
+		"prob",
+		mixOpts.Mixer.Prob,
+		"binomial: chance of sending each packet",
+	)
+}
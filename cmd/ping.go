@@ -19,41 +19,43 @@ package cmd
 
 import (
 	"os"
+	"time"
 
 	"github.com/LibSEA/mixnet/ping"
 	"github.com/spf13/cobra"
 )
 
-var pingOpts = struct {
-    host string
-    port uint16
-} {
-    host: "localhost",
-    port: 8080,
+var pingOpts = ping.Options{
+	Listen:  "127.0.0.1:8090",
+	Delay:   0,
+	Timeout: 30 * time.Second,
+	Keys:    defaultKeys,
 }
 
-
 // pingCmd represents the ping command
 var pingCmd = &cobra.Command{
 	Use:   "ping",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+	Short: "Send a ping through the mixnet and wait for the echo",
+	Long: `Send a ping along --route. The last mix on the route echoes it back
+through a single use reply block, so it never learns where the ping came
+from. Hops are written as ip:port/hex-mix-key.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		os.Exit(ping.Run(ping.Options{
-    		Host: pingOpts.host,
-    		Port: pingOpts.port,
-		}))
+		os.Exit(ping.Run(pingOpts))
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(pingCmd)
 
-	pingCmd.PersistentFlags().StringVar(&pingOpts.host, "host", pingOpts.host, "host to connect to")
-	pingCmd.PersistentFlags().Uint16Var(&pingOpts.port, "port", pingOpts.port, "port to connect to")
+	pingCmd.PersistentFlags().StringArrayVar(&pingOpts.Route, "route", nil, "hop on the path to the echoing mix, in order")
+	pingCmd.PersistentFlags().StringArrayVar(
+		&pingOpts.ReplyRoute,
+		"reply-route",
+		nil,
+		"hop on the path back, in order. defaults to --route reversed",
+	)
+	pingCmd.PersistentFlags().StringVar(&pingOpts.Listen, "listen", pingOpts.Listen, "ip:port the echo is delivered to")
+	pingCmd.PersistentFlags().DurationVar(&pingOpts.Delay, "delay", pingOpts.Delay, "mean delay per hop. 0 lets mixes pick")
+	keyFlags(pingCmd.PersistentFlags(), &pingOpts.Keys)
+	pingCmd.PersistentFlags().DurationVar(&pingOpts.Timeout, "timeout", pingOpts.Timeout, "how long to wait for the echo")
 }
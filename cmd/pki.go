@@ -0,0 +1,74 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/LibSEA/mixnet/pki"
+	"github.com/spf13/cobra"
+)
+
+var pkiOpts = pki.Options{
+	Host:   "localhost",
+	Port:   8090,
+	DB:     "pki.db",
+	Keys:   defaultKeys,
+	Layers: 3,
+}
+
+// pkiCmd represents the pki command
+var pkiCmd = &cobra.Command{
+	Use:   "pki",
+	Short: "Run a directory authority",
+	Long: `Run a directory authority. Nodes upload their signed descriptors to
+every authority and clients download a document listing the network for
+every epoch. The authorities vote on each document and it is only valid once
+more than half of them, or --threshold, signed it.
+
+Every authority lists all others with --peer host:port/identity/link-key.
+Make the keys with mixnet keygen first, the identity and link key are
+logged on start. Signatures are only taken from sessions made with the
+link key, so update --peer when an authority rotates it. The first document
+is the one of the epoch after the authorities start.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(pki.Run(pkiOpts))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pkiCmd)
+
+	pkiCmd.PersistentFlags().StringVar(&pkiOpts.Host, "host", pkiOpts.Host, "host to listen on")
+	pkiCmd.PersistentFlags().Uint16Var(&pkiOpts.Port, "port", pkiOpts.Port, "port to listen on")
+	pkiCmd.PersistentFlags().StringVar(&pkiOpts.DB, "db", pkiOpts.DB, "database directory")
+	keyFlags(pkiCmd.PersistentFlags(), &pkiOpts.Keys)
+	pkiCmd.PersistentFlags().IntVar(&pkiOpts.Layers, "layers", pkiOpts.Layers, "number of mix layers")
+	pkiCmd.PersistentFlags().StringArrayVar(
+		&pkiOpts.Peers,
+		"peer",
+		nil,
+		"another authority as host:port/identity/link-key, can be repeated",
+	)
+	pkiCmd.PersistentFlags().IntVar(
+		&pkiOpts.Threshold,
+		"threshold",
+		0,
+		"authorities that must sign a document, more than half of them by default",
+	)
+}
@@ -0,0 +1,543 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package descriptor
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"time"
+	"unicode/utf8"
+
+	"github.com/LibSEA/mixnet/cert"
+)
+
+/*
+A descriptor is how a node describes itself to the pki, the DHT and
+clients: its Ed25519 identity key, the Noise link key it accepts sessions
+with, its Sphinx mix keys for the epochs ahead, where to reach it, what it
+does and what it runs. The identity key vouches for the other keys with
+certificates, see the cert package, and the descriptor is signed by the
+signing key it certified, so the identity key itself can stay offline. The
+encoding is
+
+	version (1) | fields | signature (64)
+
+with every field
+
+	type (1) | length (2) | value
+
+in ascending type order, each at most once. Types with the critical bit
+(0x80) set change what the descriptor means, so a parser that doesn't know
+one has to reject the descriptor. Unknown fields without it are skipped,
+which lets new optional fields be added without breaking old nodes. The
+signature covers everything before it, unknown fields included, prefixed
+with signContext.
+
+Every descriptor has one encoding: addresses are 4 or 16 byte IPs,
+IPv4-mapped IPv6 addresses aren't allowed, mix keys are written as their
+certificates in epoch order and times are unix seconds.
+*/
+type Descriptor struct {
+	Identity ed25519.PublicKey
+	// SigningCert certifies the key the descriptor is signed with.
+	SigningCert *cert.Certificate
+	LinkKey     [32]byte
+	LinkCert    *cert.Certificate
+	MixKeys     []MixKey
+	Addrs       []netip.AddrPort
+	Role        Role
+	// Layer is the mix layer, only mixes have one.
+	Layer uint8
+	// Version is the software version the node runs.
+	Version string
+	// Bandwidth is in bytes per second.
+	Bandwidth uint32
+	Published time.Time
+	// Expires is when the descriptor stops being valid.
+	Expires time.Time
+
+	// raw is the signed encoding.
+	raw []byte
+}
+
+// MixKey is the Sphinx public key a node uses during Epoch.
+type MixKey struct {
+	Epoch uint64
+	Key   [32]byte
+	Cert  *cert.Certificate
+}
+
+type Role byte
+
+const (
+	RoleEntry Role = iota + 1
+	RoleMix
+	RoleProvider
+)
+
+var roles = map[Role]string{
+	RoleEntry:    "entry",
+	RoleMix:      "mix",
+	RoleProvider: "provider",
+}
+
+func (r Role) String() string {
+	if s, ok := roles[r]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("role(%d)", byte(r))
+}
+
+func ParseRole(s string) (Role, error) {
+	for r, name := range roles {
+		if name == s {
+			return r, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown role %q", s)
+}
+
+const (
+	version     = 0x2
+	signContext = "mixnet descriptor v2"
+
+	critical = 0x80
+
+	MaxAddrs      = 4
+	MaxMixKeys    = 8
+	MaxVersionLen = 64
+	// MaxSkew is how far in the future a descriptor can be published.
+	MaxSkew = 10 * time.Minute
+)
+
+type fieldType byte
+
+const (
+	fieldVersion   fieldType = 0x01
+	fieldBandwidth fieldType = 0x02
+
+	fieldIdentity  fieldType = critical | 0x01
+	fieldLinkKey   fieldType = critical | 0x02
+	fieldPublished fieldType = critical | 0x03
+	fieldExpires   fieldType = critical | 0x04
+	fieldRole      fieldType = critical | 0x05
+	fieldLayer     fieldType = critical | 0x06
+	fieldAddrs     fieldType = critical | 0x07
+	fieldMixKeys   fieldType = critical | 0x08
+	fieldLinkCert  fieldType = critical | 0x09
+	fieldSignCert  fieldType = critical | 0x0a
+)
+
+var (
+	ErrBadSignature    = errors.New("descriptor signature is invalid")
+	ErrUnknownCritical = errors.New("descriptor has an unknown critical field")
+	ErrExpired         = errors.New("descriptor has expired")
+	ErrNotYetValid     = errors.New("descriptor is published in the future")
+
+	errShort = errors.New("descriptor too short")
+)
+
+/*
+Sign signs d with priv, the key d.SigningCert is for. It sets the identity
+of d to the one of the certificate and checks d.
+*/
+func Sign(priv ed25519.PrivateKey, d *Descriptor) error {
+	if d.SigningCert == nil {
+		return fmt.Errorf("descriptor has no signing certificate")
+	}
+
+	if string(d.SigningCert.Key[:]) != string(priv.Public().(ed25519.PublicKey)) {
+		return fmt.Errorf("signing certificate is for another key")
+	}
+
+	d.Identity = d.SigningCert.Identity
+
+	err := d.Validate()
+	if err != nil {
+		return err
+	}
+
+	body := d.appendBody([]byte{version})
+	sig := ed25519.Sign(priv, append([]byte(signContext), body...))
+
+	d.raw = append(body, sig...)
+
+	return nil
+}
+
+// Bytes returns the signed encoding of a signed or parsed descriptor.
+func (d *Descriptor) Bytes() []byte {
+	return d.raw
+}
+
+// MixKey returns the mix key of epoch.
+func (d *Descriptor) MixKey(epoch uint64) ([32]byte, bool) {
+	for _, k := range d.MixKeys {
+		if k.Epoch == epoch {
+			return k.Key, true
+		}
+	}
+
+	return [32]byte{}, false
+}
+
+// Expired reports whether d is no longer valid at t.
+func (d *Descriptor) Expired(t time.Time) bool {
+	return !t.Before(d.Expires)
+}
+
+/*
+Validate checks that d is well formed: it has every critical field, a role
+it knows, addresses and mix keys within bounds and in canonical form, and
+expires after it was published. It doesn't check the signature or the time,
+see Verify.
+*/
+func (d *Descriptor) Validate() error {
+	if len(d.Identity) != ed25519.PublicKeySize {
+		return fmt.Errorf("bad descriptor identity size %d", len(d.Identity))
+	}
+
+	err := d.validCert(d.SigningCert, cert.KindSigning, nil)
+	if err != nil {
+		return err
+	}
+
+	err = d.validCert(d.LinkCert, cert.KindLink, d.LinkKey[:])
+	if err != nil {
+		return err
+	}
+
+	if _, ok := roles[d.Role]; !ok {
+		return fmt.Errorf("descriptor has unknown %s", d.Role)
+	}
+
+	if d.Role != RoleMix && d.Layer != 0 {
+		return fmt.Errorf("only mixes have a layer, not %s", d.Role)
+	}
+
+	if !d.Expires.After(d.Published) {
+		return fmt.Errorf("descriptor expires before it is published")
+	}
+
+	if len(d.Version) > MaxVersionLen || !utf8.ValidString(d.Version) {
+		return fmt.Errorf("bad descriptor version")
+	}
+
+	if len(d.Addrs) == 0 || len(d.Addrs) > MaxAddrs {
+		return fmt.Errorf("descriptor has %d addresses", len(d.Addrs))
+	}
+
+	for _, a := range d.Addrs {
+		if !a.IsValid() || a.Port() == 0 || a.Addr().IsUnspecified() ||
+			a.Addr().Is4In6() || a.Addr().Zone() != "" {
+			return fmt.Errorf("bad descriptor address %s", a)
+		}
+	}
+
+	if len(d.MixKeys) == 0 || len(d.MixKeys) > MaxMixKeys {
+		return fmt.Errorf("descriptor has %d mix keys", len(d.MixKeys))
+	}
+
+	for i, k := range d.MixKeys {
+		if i > 0 && k.Epoch <= d.MixKeys[i-1].Epoch {
+			return fmt.Errorf("descriptor mix keys are not in epoch order")
+		}
+
+		if k.Key == [32]byte{} {
+			return fmt.Errorf("descriptor has an empty mix key")
+		}
+
+		err := d.validCert(k.Cert, cert.KindMix, k.Key[:])
+		if err != nil {
+			return err
+		}
+
+		if k.Cert.Epoch != k.Epoch {
+			return fmt.Errorf("descriptor mix key of epoch %d is certified for %d", k.Epoch, k.Cert.Epoch)
+		}
+	}
+
+	return nil
+}
+
+// validCert checks that c is a certificate of kind for key by d's identity.
+func (d *Descriptor) validCert(c *cert.Certificate, kind cert.Kind, key []byte) error {
+	if c == nil {
+		return fmt.Errorf("descriptor has no %s certificate", kind)
+	}
+
+	if c.Kind != kind || !c.Identity.Equal(d.Identity) {
+		return fmt.Errorf("descriptor %s certificate is not a %s certificate of its identity", kind, kind)
+	}
+
+	if key != nil && string(c.Key[:]) != string(key) {
+		return fmt.Errorf("descriptor %s certificate is for another key", kind)
+	}
+
+	return nil
+}
+
+/*
+ValidAt checks that the certificates of d are valid at t. A descriptor
+shouldn't be used past the certificates it carries.
+*/
+func (d *Descriptor) ValidAt(t time.Time) error {
+	certs := []*cert.Certificate{d.SigningCert, d.LinkCert}
+	for _, k := range d.MixKeys {
+		certs = append(certs, k.Cert)
+	}
+
+	for _, c := range certs {
+		err := c.Valid(t)
+		if err != nil {
+			return fmt.Errorf("descriptor %s certificate. %w", c.Kind, err)
+		}
+	}
+
+	return nil
+}
+
+/*
+Verify parses b, checks its signature, validates it and checks that it and
+its certificates are valid at now.
+*/
+func Verify(b []byte, now time.Time) (*Descriptor, error) {
+	d, err := Parse(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Published.Sub(now) > MaxSkew {
+		return nil, ErrNotYetValid
+	}
+
+	if d.Expired(now) {
+		return nil, ErrExpired
+	}
+
+	err = d.ValidAt(now)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *Descriptor) appendBody(out []byte) []byte {
+	out = appendField(out, fieldVersion, []byte(d.Version))
+	out = appendField(out, fieldBandwidth, binary.BigEndian.AppendUint32(nil, d.Bandwidth))
+	out = appendField(out, fieldIdentity, d.Identity)
+	out = appendField(out, fieldLinkKey, d.LinkKey[:])
+	out = appendField(out, fieldPublished, binary.BigEndian.AppendUint64(nil, uint64(d.Published.Unix())))
+	out = appendField(out, fieldExpires, binary.BigEndian.AppendUint64(nil, uint64(d.Expires.Unix())))
+	out = appendField(out, fieldRole, []byte{byte(d.Role)})
+	out = appendField(out, fieldLayer, []byte{d.Layer})
+
+	var addrs []byte
+	for _, a := range d.Addrs {
+		ip := a.Addr().AsSlice()
+		addrs = append(addrs, byte(len(ip)))
+		addrs = append(addrs, ip...)
+		addrs = binary.BigEndian.AppendUint16(addrs, a.Port())
+	}
+	out = appendField(out, fieldAddrs, addrs)
+
+	var keys []byte
+	for _, k := range d.MixKeys {
+		keys = append(keys, k.Cert.Bytes()...)
+	}
+	out = appendField(out, fieldMixKeys, keys)
+
+	out = appendField(out, fieldLinkCert, d.LinkCert.Bytes())
+
+	return appendField(out, fieldSignCert, d.SigningCert.Bytes())
+}
+
+func appendField(out []byte, t fieldType, v []byte) []byte {
+	out = append(out, byte(t))
+	out = binary.BigEndian.AppendUint16(out, uint16(len(v)))
+
+	return append(out, v...)
+}
+
+// Parse decodes b, checks its signature and validates it.
+func Parse(b []byte) (*Descriptor, error) {
+	// the descriptor keeps pieces of b.
+	b = slices.Clone(b)
+
+	if len(b) < 1+ed25519.SignatureSize {
+		return nil, errShort
+	}
+
+	if b[0] != version {
+		return nil, fmt.Errorf("unsupported descriptor version %d", b[0])
+	}
+
+	var d Descriptor
+
+	body := b[:len(b)-ed25519.SignatureSize]
+	sig := b[len(body):]
+
+	seen := map[fieldType]bool{}
+	last := -1
+
+	for rest := body[1:]; len(rest) > 0; {
+		if len(rest) < 3 {
+			return nil, errShort
+		}
+
+		t := fieldType(rest[0])
+		n := int(binary.BigEndian.Uint16(rest[1:]))
+		rest = rest[3:]
+
+		if len(rest) < n {
+			return nil, errShort
+		}
+
+		v := rest[:n]
+		rest = rest[n:]
+
+		if int(t) <= last {
+			return nil, fmt.Errorf("descriptor fields are not in order")
+		}
+		last = int(t)
+		seen[t] = true
+
+		err := d.decodeField(t, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, t := range []fieldType{
+		fieldIdentity, fieldLinkKey, fieldPublished, fieldExpires,
+		fieldRole, fieldLayer, fieldAddrs, fieldMixKeys,
+		fieldLinkCert, fieldSignCert,
+	} {
+		if !seen[t] {
+			return nil, fmt.Errorf("descriptor is missing field 0x%x", byte(t))
+		}
+	}
+
+	// the certificates are checked before their signing key is trusted.
+	err := d.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if !ed25519.Verify(d.SigningCert.Key[:], append([]byte(signContext), body...), sig) {
+		return nil, ErrBadSignature
+	}
+
+	d.raw = b
+
+	return &d, nil
+}
+
+func (d *Descriptor) decodeField(t fieldType, v []byte) error {
+	size := map[fieldType]int{
+		fieldBandwidth: 4,
+		fieldIdentity:  ed25519.PublicKeySize,
+		fieldLinkKey:   len(d.LinkKey),
+		fieldPublished: 8,
+		fieldExpires:   8,
+		fieldRole:      1,
+		fieldLayer:     1,
+	}
+
+	if n, ok := size[t]; ok && len(v) != n {
+		return fmt.Errorf("descriptor field 0x%x should be %d bytes", byte(t), n)
+	}
+
+	switch t {
+	case fieldVersion:
+		d.Version = string(v)
+	case fieldBandwidth:
+		d.Bandwidth = binary.BigEndian.Uint32(v)
+	case fieldIdentity:
+		d.Identity = ed25519.PublicKey(v)
+	case fieldLinkKey:
+		copy(d.LinkKey[:], v)
+	case fieldPublished:
+		d.Published = time.Unix(int64(binary.BigEndian.Uint64(v)), 0)
+	case fieldExpires:
+		d.Expires = time.Unix(int64(binary.BigEndian.Uint64(v)), 0)
+	case fieldRole:
+		d.Role = Role(v[0])
+	case fieldLayer:
+		d.Layer = v[0]
+	case fieldAddrs:
+		return d.decodeAddrs(v)
+	case fieldMixKeys:
+		if len(v)%cert.Size != 0 {
+			return fmt.Errorf("bad descriptor mix keys")
+		}
+
+		for b := range slices.Chunk(v, cert.Size) {
+			c, err := cert.Parse(b)
+			if err != nil {
+				return fmt.Errorf("bad descriptor mix key. %w", err)
+			}
+
+			d.MixKeys = append(d.MixKeys, MixKey{Epoch: c.Epoch, Key: c.Key, Cert: c})
+		}
+	case fieldLinkCert:
+		c, err := cert.Parse(v)
+		if err != nil {
+			return fmt.Errorf("bad descriptor link certificate. %w", err)
+		}
+
+		d.LinkCert = c
+	case fieldSignCert:
+		c, err := cert.Parse(v)
+		if err != nil {
+			return fmt.Errorf("bad descriptor signing certificate. %w", err)
+		}
+
+		d.SigningCert = c
+	default:
+		if t&critical != 0 {
+			return fmt.Errorf("%w 0x%x", ErrUnknownCritical, byte(t))
+		}
+	}
+
+	return nil
+}
+
+func (d *Descriptor) decodeAddrs(v []byte) error {
+	for len(v) > 0 {
+		n := int(v[0])
+		if n != 4 && n != 16 || len(v) < 1+n+2 {
+			return fmt.Errorf("bad descriptor address")
+		}
+
+		ip, _ := netip.AddrFromSlice(v[1 : 1+n])
+		port := binary.BigEndian.Uint16(v[1+n:])
+
+		d.Addrs = append(d.Addrs, netip.AddrPortFrom(ip, port))
+		v = v[1+n+2:]
+	}
+
+	return nil
+}
@@ -0,0 +1,253 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package descriptor
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/cert"
+)
+
+var now = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newCert(t *testing.T, identity ed25519.PrivateKey, kind cert.Kind, key []byte, epoch uint64) *cert.Certificate {
+	t.Helper()
+
+	c := cert.Certificate{
+		Kind:      kind,
+		Epoch:     epoch,
+		ValidFrom: now.Add(-time.Hour),
+		Expires:   now.Add(2 * time.Hour),
+	}
+	copy(c.Key[:], key)
+
+	err := cert.Sign(identity, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &c
+}
+
+// newDescriptor returns a signed descriptor and its identity and signing keys.
+func newDescriptor(t *testing.T) (ed25519.PrivateKey, ed25519.PrivateKey, *Descriptor) {
+	_, identity, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := Descriptor{
+		Addrs: []netip.AddrPort{
+			netip.MustParseAddrPort("10.0.0.1:8081"),
+			netip.MustParseAddrPort("[2001:db8::1]:8081"),
+		},
+		Role:      RoleMix,
+		Layer:     2,
+		Version:   "v0.1.0",
+		Bandwidth: 1 << 20,
+		Published: now,
+		Expires:   now.Add(time.Hour),
+		MixKeys:   []MixKey{{Epoch: 7}, {Epoch: 8}},
+	}
+
+	_, _ = rand.Read(d.LinkKey[:])
+	_, _ = rand.Read(d.MixKeys[0].Key[:])
+	_, _ = rand.Read(d.MixKeys[1].Key[:])
+
+	d.SigningCert = newCert(t, identity, cert.KindSigning, priv.Public().(ed25519.PublicKey), 0)
+	d.LinkCert = newCert(t, identity, cert.KindLink, d.LinkKey[:], 0)
+
+	for i, k := range d.MixKeys {
+		d.MixKeys[i].Cert = newCert(t, identity, cert.KindMix, k.Key[:], k.Epoch)
+	}
+
+	err = Sign(priv, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return identity, priv, &d
+}
+
+// withField adds field t with v to d, in order or at the end, and signs it.
+func withField(priv ed25519.PrivateKey, d *Descriptor, t fieldType, v []byte, inOrder bool) []byte {
+	var fields [][]byte
+
+	for rest := d.appendBody(nil); len(rest) > 0; {
+		n := 3 + int(binary.BigEndian.Uint16(rest[1:]))
+		fields = append(fields, rest[:n])
+		rest = rest[n:]
+	}
+
+	extra := appendField(nil, t, v)
+
+	i := len(fields)
+	if inOrder {
+		if j := slices.IndexFunc(fields, func(f []byte) bool { return f[0] > byte(t) }); j >= 0 {
+			i = j
+		}
+	}
+	fields = slices.Insert(fields, i, extra)
+
+	body := append([]byte{version}, bytes.Join(fields, nil)...)
+
+	return append(body, ed25519.Sign(priv, append([]byte(signContext), body...))...)
+}
+
+func TestEncoding(t *testing.T) {
+	identity, _, d := newDescriptor(t)
+
+	got, err := Verify(d.Bytes(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.Identity.Equal(identity.Public()) || got.LinkKey != d.LinkKey ||
+		got.Role != RoleMix || got.Layer != 2 || got.Version != "v0.1.0" ||
+		got.Bandwidth != 1<<20 || !got.Published.Equal(now) || !got.Expires.Equal(d.Expires) ||
+		len(got.Addrs) != 2 || got.Addrs[1] != d.Addrs[1] ||
+		len(got.MixKeys) != 2 || got.MixKeys[1].Key != d.MixKeys[1].Key ||
+		got.MixKeys[1].Epoch != 8 || got.MixKeys[1].Cert.Epoch != 8 ||
+		!bytes.Equal(got.LinkCert.Bytes(), d.LinkCert.Bytes()) ||
+		!bytes.Equal(got.SigningCert.Bytes(), d.SigningCert.Bytes()) {
+		t.Fatalf("descriptor should survive encoding. %+v", got)
+	}
+
+	if k, ok := got.MixKey(8); !ok || k != d.MixKeys[1].Key {
+		t.Fatal("should find the mix key of an epoch")
+	}
+
+	b := bytes.Clone(d.Bytes())
+	b[bytes.Index(b, []byte("v0.1.0"))] ^= 1
+
+	if _, err := Parse(b); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("changed descriptor should fail the signature check. %v", err)
+	}
+
+	// only the key of the signing certificate signs descriptors.
+	b = withField(identity, d, 0x7f, nil, true)
+	if _, err := Parse(b); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("descriptor signed with another key should be rejected. %v", err)
+	}
+}
+
+func TestFields(t *testing.T) {
+	_, priv, d := newDescriptor(t)
+
+	got, err := Parse(withField(priv, d, 0x7f, []byte("later"), true))
+	if err != nil {
+		t.Fatalf("unknown optional fields should be skipped. %v", err)
+	}
+
+	if got.Role != RoleMix {
+		t.Fatal("known fields should still be read")
+	}
+
+	if _, err := Parse(withField(priv, d, 0xff, nil, true)); !errors.Is(err, ErrUnknownCritical) {
+		t.Fatalf("unknown critical fields should be rejected. %v", err)
+	}
+
+	if _, err := Parse(withField(priv, d, 0x7f, nil, false)); err == nil {
+		t.Fatal("fields out of order should be rejected")
+	}
+
+	// a body without the mix keys field.
+	body := []byte{version}
+	body = appendField(body, fieldIdentity, priv.Public().(ed25519.PublicKey))
+	body = append(body, ed25519.Sign(priv, append([]byte(signContext), body...))...)
+
+	if _, err := Parse(body); err == nil {
+		t.Fatal("missing critical fields should be rejected")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	other, _, _ := newDescriptor(t)
+
+	for name, change := range map[string]func(d *Descriptor){
+		"role":          func(d *Descriptor) { d.Role = 9 },
+		"entry layer":   func(d *Descriptor) { d.Role = RoleEntry },
+		"expiry":        func(d *Descriptor) { d.Expires = d.Published },
+		"no address":    func(d *Descriptor) { d.Addrs = nil },
+		"mapped":        func(d *Descriptor) { d.Addrs[0] = netip.MustParseAddrPort("[::ffff:10.0.0.1]:1") },
+		"no port":       func(d *Descriptor) { d.Addrs[0] = netip.MustParseAddrPort("10.0.0.1:0") },
+		"no keys":       func(d *Descriptor) { d.MixKeys = nil },
+		"key order":     func(d *Descriptor) { d.MixKeys[0], d.MixKeys[1] = d.MixKeys[1], d.MixKeys[0] },
+		"empty mix key": func(d *Descriptor) { d.MixKeys[0].Key = [32]byte{} },
+		"link cert":     func(d *Descriptor) { d.LinkKey[0] ^= 1 },
+		"no link cert":  func(d *Descriptor) { d.LinkCert = nil },
+		"mix cert":      func(d *Descriptor) { d.MixKeys[0].Key[0] ^= 1 },
+		"mix cert epoch": func(d *Descriptor) {
+			d.MixKeys[0].Epoch, d.MixKeys[1].Epoch = 6, 7
+		},
+		"mix cert kind": func(d *Descriptor) { d.MixKeys[0].Cert = d.LinkCert },
+		"cert identity": func(d *Descriptor) {
+			d.LinkCert = newCert(t, other, cert.KindLink, d.LinkKey[:], 0)
+		},
+	} {
+		_, priv, d := newDescriptor(t)
+		change(d)
+
+		if err := Sign(priv, d); err == nil {
+			t.Fatalf("descriptor with bad %s should not be signed", name)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	identity, priv, d := newDescriptor(t)
+
+	if _, err := Verify(d.Bytes(), now.Add(-time.Hour)); !errors.Is(err, ErrNotYetValid) {
+		t.Fatalf("descriptor from the future should be rejected. %v", err)
+	}
+
+	if _, err := Verify(d.Bytes(), now.Add(-MaxSkew)); err != nil {
+		t.Fatal("small clock skew should be allowed")
+	}
+
+	if _, err := Verify(d.Bytes(), d.Expires); !errors.Is(err, ErrExpired) {
+		t.Fatalf("expired descriptor should be rejected. %v", err)
+	}
+
+	d.LinkCert = newCert(t, identity, cert.KindLink, d.LinkKey[:], 0)
+	d.LinkCert.Expires = now.Add(30 * time.Minute)
+	if err := cert.Sign(identity, d.LinkCert); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Sign(priv, d); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Verify(d.Bytes(), now.Add(30*time.Minute)); !errors.Is(err, cert.ErrExpired) {
+		t.Fatalf("descriptor with an expired certificate should be rejected. %v", err)
+	}
+}
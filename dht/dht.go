@@ -1,64 +1,541 @@
 package dht
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
 	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/session"
+	"github.com/LibSEA/mixnet/store"
+	"github.com/flynn/noise"
 )
 
 type DHT struct {
-	Self *Contact
+	Self Contact
+
+	RoutingTable *RoutingTable
+
+	storage   store.Storage
+	transport Transport
+	logger    *slog.Logger
+	clock     clock.Clock
+	cs        noise.CipherSuite
+	kp        noise.DHKey
 
-	RoutingTable [][]*Contact
+	queryTimeout time.Duration
+	difficulty   int
+	seeds        []Contact
+
+	storeMu sync.Mutex
+	// stored indexes the keys in storage, which can't be iterated. It is
+	// saved too, see saveStored.
+	stored map[NodeID]struct{}
+
+	publishMu sync.Mutex
+	published map[NodeID]*Record
 }
 
 const (
-// alpha = 3   // Concurrency
-// b     = 256 // Bits
-// k     = 20
-
-// expire    = 86400 * time.Second
-// refresh   = 3600 * time.Second
-// replicate = 3600 * time.Second
-// republish = 86400 * time.Second
+	alpha = 3   // Concurrency
+	b     = 256 // Bits
+	k     = 20
+
+	// BucketSize is k, how many contacts a bucket holds.
+	BucketSize = k
+
+	refresh   = 3600 * time.Second
+	replicate = 3600 * time.Second
+	republish = 86400 * time.Second
+	// expire outlives republish so a value republished on time never lapses.
+	expire = republish + 3600*time.Second
 )
 
+/*
+Contact is how to reach a node. A node can have an address in each family,
+either can be the zero AddrPort.
+*/
 type Contact struct {
-	Id   NodeID
-	Port uint16
-	IP   net.IPAddr
+	Id NodeID
+	V4 netip.AddrPort
+	V6 netip.AddrPort
+	// PublicKey is the static Noise key of the node. Id is derived from it
+	// and Nonce, see NewNodeID.
+	PublicKey [32]byte
+	Nonce     uint64
 }
 
-// The idea is that the DHT has as its key a public key and it's payload is
-// signed by the private key. And we will reject any attempt to write to that
-// key that isn't signed and verified.
-//
-// My hope is this will have the side effect of making it hard to launch some
-// of the known attacks against kademlia.
 type Options struct {
-	IP         net.IPAddr
-	Port       uint16
+	// V4 and V6 are the addresses the node is reachable on, at least one
+	// is needed for other nodes to reach it.
+	V4 netip.AddrPort
+	V6 netip.AddrPort
+	// PrivateKey and PublicKey are the static Noise key of the node, which
+	// its ID is derived from. A new key is made when they are empty.
 	PrivateKey []byte
 	PublicKey  []byte
+	// Difficulty is the ID puzzle difficulty in bits, it must be the same
+	// on every node. Defaults to DefaultDifficulty.
+	Difficulty int
+
+	Storage store.Storage
+	Logger  *slog.Logger
+	Clock   clock.Clock
+	// Transport defaults to a SessionTransport using the node's key.
+	Transport Transport
+	// QueryTimeout limits each request of a lookup. Defaults to 5s.
+	QueryTimeout time.Duration
+	// Limits caps the contacts taken from one network.
+	Limits Limits
+	// Seeds are used by Join when no saved contact answers.
+	Seeds []Contact
 }
 
 type NodeID [32]byte
 
-func New() {
+func (n NodeID) Xor(o NodeID) NodeID {
+	var out NodeID
+	for i := range n {
+		out[i] = n[i] ^ o[i]
+	}
+
+	return out
+}
+
+/*
+observed replaces the address of c in a's family with a, keeping the port c
+claimed. The port of the other family is used if c claimed none for it.
+*/
+func (c *Contact) observed(a netip.Addr) {
+	if !a.IsValid() {
+		return
+	}
+
+	own, other := &c.V6, c.V4
+	if a.Is4() {
+		own, other = &c.V4, c.V6
+	}
+
+	port := own.Port()
+	if !own.IsValid() {
+		port = other.Port()
+	}
+
+	// a client that doesn't listen claims no port, and has no address.
+	if port == 0 {
+		*own = netip.AddrPort{}
+		return
+	}
+
+	*own = netip.AddrPortFrom(a, port)
+}
+
+// merge fills the addresses c lacks from old.
+func (c *Contact) merge(old *Contact) {
+	if !c.V4.IsValid() {
+		c.V4 = old.V4
+	}
+
+	if !c.V6.IsValid() {
+		c.V6 = old.V6
+	}
+}
+
+// Addr is the address to dial c on, IPv4 if c has one.
+func (c *Contact) Addr() netip.AddrPort {
+	if c.V4.IsValid() {
+		return c.V4
+	}
+
+	return c.V6
+}
+
+// Cmp compares n and o as big endian numbers.
+func (n NodeID) Cmp(o NodeID) int {
+	return bytes.Compare(n[:], o[:])
+}
+
+func New(opts Options) (*DHT, error) {
+	d := DHT{
+		Self: Contact{
+			V4: opts.V4,
+			V6: opts.V6,
+		},
+		storage:   opts.Storage,
+		transport: opts.Transport,
+		logger:    opts.Logger,
+		clock:     opts.Clock,
+		cs: noise.NewCipherSuite(
+			noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b,
+		),
+		kp: noise.DHKey{Private: opts.PrivateKey, Public: opts.PublicKey},
+
+		queryTimeout: opts.QueryTimeout,
+		difficulty:   opts.Difficulty,
+		seeds:        opts.Seeds,
+
+		stored:    make(map[NodeID]struct{}),
+		published: make(map[NodeID]*Record),
+	}
+
+	if d.queryTimeout == 0 {
+		d.queryTimeout = 5 * time.Second
+	}
+
+	if d.Self.V4.IsValid() && !d.Self.V4.Addr().Is4() ||
+		d.Self.V6.IsValid() && !d.Self.V6.Addr().Is6() {
+		return nil, fmt.Errorf("address in the wrong family")
+	}
+
+	if d.difficulty == 0 {
+		d.difficulty = DefaultDifficulty
+	}
+
+	if len(d.kp.Public) == 0 {
+		kp, err := d.cs.GenerateKeypair(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate node key. %w", err)
+		}
+		d.kp = kp
+	}
+
+	if len(d.kp.Public) != len(d.Self.PublicKey) {
+		return nil, fmt.Errorf("bad public key length %d", len(d.kp.Public))
+	}
+
+	copy(d.Self.PublicKey[:], d.kp.Public)
+	d.Self.Nonce, d.Self.Id = Solve(d.Self.PublicKey, d.difficulty)
 
+	if d.logger == nil {
+		d.logger = slog.New(slog.DiscardHandler)
+	}
+
+	if d.clock == nil {
+		d.clock = clock.Real{}
+	}
+
+	if d.transport == nil {
+		d.transport = NewSessionTransport(
+			func() Contact { return d.Self },
+			d.cs,
+			d.kp,
+			d.logger,
+		)
+	}
+
+	d.RoutingTable = NewRoutingTable(d.Self.Id, &d, d.clock, d.logger, opts.Limits)
+
+	err := d.loadStored()
+	if err != nil {
+		d.logger.Warn("ignoring stored record index", "error", err)
+	}
+
+	return &d, nil
 }
 
-func (d *DHT) Ping() {
+// Ping asks c whether it is alive.
+func (d *DHT) Ping(ctx context.Context, c Contact) error {
+	err := d.transport.Ping(ctx, c)
+	if err != nil {
+		return err
+	}
 
+	// not Seen, the routing table pings contacts from inside Seen.
+	return nil
 }
 
-func (d *DHT) Store() {
+/*
+Store verifies r and saves it on the k closest nodes to its key. It returns
+an error only if no node stored it.
+*/
+func (d *DHT) Store(ctx context.Context, r *Record) error {
+	err := r.Verify()
+	if err != nil {
+		return err
+	}
+
+	key := r.Key()
+	value := r.Bytes()
+
+	contacts, err := d.FindNode(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		stored int
+		errs   []error
+	)
+
+	for _, c := range contacts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := d.transport.Store(ctx, c, key, value)
 
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			stored++
+		}()
+	}
+
+	wg.Wait()
+
+	if stored == 0 {
+		return fmt.Errorf("no node stored the record. %w", errors.Join(errs...))
+	}
+
+	return nil
 }
 
-func (d *DHT) FindNode(nodeId NodeID) []Contact {
+/*
+Bootstrap joins the network through seeds by looking up our own ID, which
+fills the routing table with the nodes around us.
+*/
+func (d *DHT) Bootstrap(ctx context.Context, seeds []Contact) error {
+	for _, c := range seeds {
+		if !c.Valid(d.difficulty) {
+			return fmt.Errorf("seed %s has an invalid id", c.addr())
+		}
+		d.RoutingTable.Seen(ctx, c)
+	}
+
+	_, err := d.FindNode(ctx, d.Self.Id)
+	if err != nil {
+		return fmt.Errorf("bootstrap failed. %w", err)
+	}
+
 	return nil
 }
 
-func (d *DHT) FindValue() ([]byte, []NodeID) {
-	return nil, nil
+// FindNode returns the k closest contacts to target it can find.
+func (d *DHT) FindNode(
+	ctx context.Context,
+	target NodeID,
+	opts ...LookupOption,
+) ([]Contact, error) {
+	_, contacts, err := d.lookup(ctx, target, false, opts)
+	return contacts, err
+}
+
+/*
+FindValue returns the record stored under key, or if no node has it, the k
+closest contacts to key.
+*/
+func (d *DHT) FindValue(
+	ctx context.Context,
+	key NodeID,
+	opts ...LookupOption,
+) (*Record, []Contact, error) {
+	v, err := d.storage.Get(valueKey(key))
+	if err == nil {
+		r, err := ParseRecord(v)
+		return r, nil, err
+	}
+
+	v, contacts, err := d.lookup(ctx, key, true, opts)
+	if err != nil || v == nil {
+		return nil, contacts, err
+	}
+
+	// lookup already verified it.
+	r, err := ParseRecord(v)
+
+	return r, nil, err
+}
+
+// result updates the routing table after a request to c.
+func (d *DHT) result(ctx context.Context, c Contact, err error) {
+	if err == nil {
+		go d.RoutingTable.Seen(context.WithoutCancel(ctx), c)
+		return
+	}
+
+	if ctx.Err() == nil {
+		d.logger.Debug("dht request failed", "addr", c.addr(), "error", err)
+		d.RoutingTable.Remove(c.Id)
+	}
+}
+
+/*
+Serve answers requests from other nodes on ln until ln is closed or fails to
+accept connections too many times.
+*/
+func (d *DHT) Serve(ln net.Listener) error {
+	cf := 0
+
+	for {
+		if cf > 10 {
+			return fmt.Errorf("failed calling accept to many times")
+		}
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			d.logger.Error("error calling accept", "error", err)
+			cf++
+			continue
+		}
+		cf = 0
+		go d.serve(conn)
+	}
+}
+
+func (d *DHT) serve(conn net.Conn) {
+	s := session.New(conn, d.cs, d.kp)
+	defer func() { _ = s.Close() }()
+
+	var buf = make([]byte, math.MaxInt16)
+	var out = make([]byte, math.MaxInt16)
+
+	err := s.ServerHandshake(buf)
+	if err != nil {
+		d.logger.Warn("ServerHandshake failed.", "error", err)
+		return
+	}
+
+	var remote netip.Addr
+	if a, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		remote = a.AddrPort().Addr().Unmap()
+	}
+
+	for {
+		msg, err := s.ReadMessage(buf)
+		if err != nil {
+			d.logger.Debug("ReadMessage failed", "error", err)
+			return
+		}
+
+		req, err := decodeMessage(msg)
+		if err != nil {
+			d.logger.Warn("bad dht request", "error", err)
+			return
+		}
+
+		// trust the address the request came from, not what it claims.
+		req.from.observed(remote)
+
+		if !bytes.Equal(req.from.PublicKey[:], s.PeerStatic()) ||
+			!req.from.Valid(d.difficulty) {
+			d.logger.Warn("dht request with invalid sender", "addr", conn.RemoteAddr())
+			return
+		}
+
+		if req.from.Id != d.Self.Id && req.from.Addr().IsValid() {
+			go d.RoutingTable.Seen(context.Background(), req.from)
+		}
+
+		err = s.WriteMessage(out, d.handle(req).encode())
+		if err != nil {
+			d.logger.Debug("WriteMessage failed", "error", err)
+			return
+		}
+	}
+}
+
+func (d *DHT) handle(req *message) *message {
+	resp := message{id: req.id, from: d.Self}
+
+	switch req.typ {
+	case msgPing:
+		resp.typ = msgPong
+	case msgStore:
+		err := d.storeRecord(req.key, req.value)
+		if err != nil {
+			d.logger.Debug("rejected record", "key", req.key, "error", err)
+			resp.typ = msgError
+			resp.err = err.Error()
+			break
+		}
+		resp.typ = msgStored
+	case msgFindNode:
+		resp.typ = msgNodes
+		resp.contacts = d.RoutingTable.ClosestContacts(req.key, k)
+	case msgFindValue:
+		v, err := d.storage.Get(valueKey(req.key))
+		if err == nil {
+			resp.typ = msgValue
+			resp.value = v
+			break
+		}
+		if !errors.Is(err, store.ErrKeyMissing) {
+			d.logger.Error("failed to get value", "error", err)
+		}
+		resp.typ = msgNodes
+		resp.contacts = d.RoutingTable.ClosestContacts(req.key, k)
+	case msgFindRecords:
+		resp.typ = msgRecords
+		resp.values = d.records(req.key, int(req.bits), req.salt)
+	default:
+		resp.typ = msgError
+		resp.err = "unexpected request"
+	}
+
+	return &resp
+}
+
+/*
+storeRecord saves b under key if it is a valid record for key and not older
+than the one we have. Storing the record we already have again just
+refreshes its expiry.
+*/
+func (d *DHT) storeRecord(key NodeID, b []byte) error {
+	r, err := verifyRecord(key, b)
+	if err != nil {
+		return err
+	}
+
+	d.storeMu.Lock()
+	defer d.storeMu.Unlock()
+
+	old, err := d.storage.Get(valueKey(key))
+	switch {
+	case err == nil:
+		o, err := ParseRecord(old)
+		if err == nil && (o.Seq > r.Seq || o.Seq == r.Seq && !bytes.Equal(old, b)) {
+			return ErrStaleRecord
+		}
+	case !errors.Is(err, store.ErrKeyMissing):
+		return fmt.Errorf("failed to get stored record. %w", err)
+	}
+
+	err = d.storage.Put(valueKey(key), b, expire)
+	if err != nil {
+		d.logger.Error("failed to store record", "error", err)
+		return fmt.Errorf("store failed")
+	}
+
+	if _, ok := d.stored[key]; ok {
+		return nil
+	}
+
+	d.stored[key] = struct{}{}
+
+	err = d.saveStored()
+	if err != nil {
+		d.logger.Error("failed to save stored record index", "error", err)
+	}
+
+	return nil
+}
 
+func valueKey(key NodeID) []byte {
+	return append([]byte("dht/value/"), key[:]...)
 }
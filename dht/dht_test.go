@@ -0,0 +1,185 @@
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/store"
+	"github.com/flynn/noise"
+)
+
+func TestMessageEncoding(t *testing.T) {
+	from := Contact{
+		PublicKey: [32]byte{1},
+		Nonce:     5,
+		V4:        netip.MustParseAddrPort("127.0.0.1:8080"),
+		V6:        netip.MustParseAddrPort("[2001:db8::1]:8080"),
+	}
+	from.Id = NewNodeID(from.PublicKey, from.Nonce)
+	other := Contact{PublicKey: [32]byte{2}, V6: netip.MustParseAddrPort("[::1]:9")}
+	other.Id = NewNodeID(other.PublicKey, other.Nonce)
+
+	msgs := []message{
+		{typ: msgPing, id: 1, from: from},
+		{typ: msgStore, id: 2, from: from, key: NodeID{3}, value: []byte("v")},
+		{typ: msgFindNode, id: 3, from: from, key: NodeID{4}},
+		{typ: msgNodes, id: 4, from: from, contacts: []Contact{from, other}},
+		{typ: msgValue, id: 5, from: from, value: []byte("value")},
+		{typ: msgError, id: 6, from: from, err: "nope"},
+		{typ: msgFindRecords, id: 7, from: from, key: NodeID{5}, bits: 12, salt: []byte("s")},
+		{typ: msgRecords, id: 8, from: from, values: [][]byte{[]byte("a"), []byte("bc")}},
+	}
+
+	for _, m := range msgs {
+		got, err := decodeMessage(m.encode())
+		if err != nil {
+			t.Fatalf("type %d: %s", m.typ, err)
+		}
+
+		if !reflect.DeepEqual(*got, m) {
+			t.Fatalf("round trip changed message.\n%+v\n%+v", m, *got)
+		}
+	}
+
+	b := msgs[1].encode()
+	if _, err := decodeMessage(b[:len(b)-1]); err == nil {
+		t.Fatal("truncated message should fail")
+	}
+
+	if _, err := decodeMessage(append(b, 0)); err == nil {
+		t.Fatal("trailing bytes should fail")
+	}
+}
+
+func startNode(t *testing.T) *DHT {
+	t.Helper()
+
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	kp, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	addr := ln.Addr().(*net.TCPAddr)
+
+	d, err := New(Options{
+		V4:         addr.AddrPort(),
+		PrivateKey: kp.Private,
+		PublicKey:  kp.Public,
+		Storage:    store.NewMemory(clock.Real{}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = d.Serve(ln) }()
+
+	return d
+}
+
+func TestRPC(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a, b, c := startNode(t), startNode(t), startNode(t)
+
+	// b knows c, a only knows b.
+	b.RoutingTable.Seen(ctx, c.Self)
+	a.RoutingTable.Seen(ctx, b.Self)
+
+	if err := a.Ping(ctx, b.Self); err != nil {
+		t.Fatal(err)
+	}
+
+	contacts, err := a.FindNode(ctx, c.Self.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(contacts) == 0 || contacts[0].Id != c.Self.Id {
+		t.Fatalf("a should learn about c from b. got %v", contacts)
+	}
+
+	// b learns about a when a sends it requests.
+	deadline := time.Now().Add(time.Second)
+	for b.RoutingTable.Len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if b.RoutingTable.Len() != 2 {
+		t.Fatal("b should add a to its routing table")
+	}
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	r, _ := NewRecord(priv, nil, 1, []byte("hello"))
+
+	err = a.Store(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, err := c.FindValue(ctx, r.Key())
+	if err != nil || got == nil || string(got.Value) != "hello" {
+		t.Fatalf("c should find stored record. %v %v", got, err)
+	}
+
+	_, contacts, err = a.FindValue(ctx, NodeID{10})
+	if err != nil || len(contacts) == 0 {
+		t.Fatalf("missing value should return contacts. %v %v", contacts, err)
+	}
+
+	// a contact with c's address but b's key doesn't pass as b.
+	impostor := b.Self
+	impostor.V4 = c.Self.V4
+	if err := a.Ping(ctx, impostor); err == nil {
+		t.Fatal("ping should fail when the node has a different key")
+	}
+}
+
+func TestContactAddresses(t *testing.T) {
+	c := Contact{V6: netip.MustParseAddrPort("[2001:db8::1]:7000")}
+
+	// a request over IPv4 adds the observed address with the claimed port.
+	c.observed(netip.MustParseAddr("192.0.2.1"))
+	if c.V4 != netip.MustParseAddrPort("192.0.2.1:7000") {
+		t.Fatalf("observed ipv4 address should be added. got %s", c.V4)
+	}
+
+	c.observed(netip.MustParseAddr("2001:db8::2"))
+	if c.V6 != netip.MustParseAddrPort("[2001:db8::2]:7000") {
+		t.Fatalf("observed ipv6 address should replace the claimed one. got %s", c.V6)
+	}
+
+	// the routing table keeps the family a later sighting lacks.
+	sut, _ := newTestTable()
+	id := idInBucket(10, 0)
+
+	sut.Seen(context.Background(), Contact{Id: id, V4: c.V4, V6: c.V6})
+	sut.Seen(context.Background(), Contact{Id: id, V4: c.V4})
+
+	got := sut.ClosestContacts(id, 1)[0]
+	if got.V4 != c.V4 || got.V6 != c.V6 {
+		t.Fatalf("table should keep both addresses. got %s %s", got.V4, got.V6)
+	}
+
+	b := appendContact(nil, &got)
+	b[40] = 0x4
+	d := decoder{b: b}
+	d.contact()
+	if d.err == nil {
+		t.Fatal("unknown contact flags should fail")
+	}
+}
@@ -0,0 +1,103 @@
+package dht
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"strings"
+)
+
+// DefaultDifficulty is the puzzle difficulty used when Options leaves it zero.
+const DefaultDifficulty = 16
+
+/*
+Node IDs are derived from the node's static Noise key, as in S/Kademlia, so
+nodes can't choose where in the keyspace they land. The ID is
+
+	SHA-256(public key | nonce)
+
+and it is only valid if SHA-256(ID) starts with difficulty zero bits. Every
+valid ID costs about 2^difficulty hashes, and one within n bits of a chosen
+key about 2^(n+difficulty), which makes surrounding a key expensive. The key
+itself is checked against the Noise handshake, so an ID can't be borrowed.
+*/
+func NewNodeID(pub [32]byte, nonce uint64) NodeID {
+	var b [40]byte
+	copy(b[:], pub[:])
+	binary.BigEndian.PutUint64(b[32:], nonce)
+
+	return sha256.Sum256(b[:])
+}
+
+// Solve finds the first nonce that gives pub a valid ID.
+func Solve(pub [32]byte, difficulty int) (uint64, NodeID) {
+	for nonce := uint64(0); ; nonce++ {
+		id := NewNodeID(pub, nonce)
+		if puzzleBits(id) >= difficulty {
+			return nonce, id
+		}
+	}
+}
+
+// Valid reports whether c's ID comes from its key and solves the puzzle.
+func (c *Contact) Valid(difficulty int) bool {
+	return c.Id == NewNodeID(c.PublicKey, c.Nonce) &&
+		puzzleBits(c.Id) >= difficulty
+}
+
+// puzzleBits is the number of leading zero bits of SHA-256(id).
+func puzzleBits(id NodeID) int {
+	h := sha256.Sum256(id[:])
+
+	n := 0
+	for _, x := range h {
+		n += bits.LeadingZeros8(x)
+		if x != 0 {
+			break
+		}
+	}
+
+	return n
+}
+
+/*
+ParseContact reads a contact written as ip:port/hex-key, as String writes
+it. The nonce is found again by solving the puzzle, which gives the nonce
+the node found itself.
+*/
+func ParseContact(s string, difficulty int) (Contact, error) {
+	var c Contact
+
+	addr, key, ok := strings.Cut(s, "/")
+	if !ok {
+		return c, fmt.Errorf("contact %q should be ip:port/key", s)
+	}
+
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return c, fmt.Errorf("bad contact address. %w", err)
+	}
+
+	if ap.Addr().Unmap().Is4() {
+		c.V4 = netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+	} else {
+		c.V6 = ap
+	}
+
+	k, err := hex.DecodeString(key)
+	if err != nil || len(k) != len(c.PublicKey) {
+		return c, fmt.Errorf("contact key should be %d hex bytes", len(c.PublicKey))
+	}
+
+	copy(c.PublicKey[:], k)
+	c.Nonce, c.Id = Solve(c.PublicKey, difficulty)
+
+	return c, nil
+}
+
+func (c Contact) String() string {
+	return fmt.Sprintf("%s/%x", c.Addr(), c.PublicKey)
+}
@@ -0,0 +1,30 @@
+package dht
+
+import "testing"
+
+func TestPuzzle(t *testing.T) {
+	pub := [32]byte{1, 2, 3}
+
+	nonce, id := Solve(pub, 8)
+	c := Contact{Id: id, PublicKey: pub, Nonce: nonce}
+
+	if !c.Valid(8) {
+		t.Fatal("solved contact should be valid")
+	}
+
+	if c.Valid(puzzleBits(id) + 1) {
+		t.Fatal("contact should not pass a harder puzzle")
+	}
+
+	bad := c
+	bad.Id[0] ^= 1
+	if bad.Valid(0) {
+		t.Fatal("id not derived from the key should be invalid")
+	}
+
+	bad = c
+	bad.PublicKey[0] ^= 1
+	if bad.Valid(0) {
+		t.Fatal("id of another key should be invalid")
+	}
+}
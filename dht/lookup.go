@@ -0,0 +1,314 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+type queryState int
+
+const (
+	unqueried queryState = iota
+	inflight
+	queried
+	failed
+)
+
+type candidate struct {
+	Contact
+	state queryState
+}
+
+// LookupOption changes how FindNode and FindValue search the network.
+type LookupOption func(*lookupConfig)
+
+type lookupConfig struct {
+	paths int
+}
+
+/*
+Disjoint makes the lookup take n paths that never ask the same node, as in
+S/Kademlia. A contact is only in the result if most paths found it among the
+closest, so an attacker on one path can't steer the answer on its own. Values
+are signed, so a value lookup takes the newest valid record any path found.
+*/
+func Disjoint(n int) LookupOption {
+	return func(c *lookupConfig) {
+		c.paths = max(n, 1)
+	}
+}
+
+var errNoAgreement = errors.New("disjoint lookup paths did not agree")
+
+// shortlist is the set of contacts a lookup path knows, closest first.
+type shortlist struct {
+	target  NodeID
+	entries []*candidate
+	seen    map[NodeID]bool
+}
+
+func newShortlist(target NodeID) *shortlist {
+	return &shortlist{target: target, seen: make(map[NodeID]bool)}
+}
+
+func (s *shortlist) add(cs ...Contact) {
+	for _, c := range cs {
+		if s.seen[c.Id] {
+			continue
+		}
+
+		s.seen[c.Id] = true
+
+		e := &candidate{Contact: c}
+		i, _ := slices.BinarySearchFunc(s.entries, e, func(a, b *candidate) int {
+			return s.target.Xor(a.Id).Cmp(s.target.Xor(b.Id))
+		})
+		s.entries = slices.Insert(s.entries, i, e)
+	}
+}
+
+/*
+next returns the closest contact still to be asked, if it is one of the k
+closest that haven't failed. Contacts another path asked are skipped
+entirely, owner maps every asked contact to the path that asked it.
+*/
+func (s *shortlist) next(path int, owner map[NodeID]int) *candidate {
+	n := 0
+	for _, e := range s.entries {
+		if o, ok := owner[e.Id]; ok && o != path {
+			continue
+		}
+		if e.state == failed {
+			continue
+		}
+		if n == k {
+			return nil
+		}
+		n++
+		if e.state == unqueried {
+			return e
+		}
+	}
+
+	return nil
+}
+
+type path struct {
+	list    *shortlist
+	running int
+	value   []byte
+	seq     uint64
+}
+
+type reply struct {
+	path     int
+	c        *candidate
+	value    []byte
+	contacts []Contact
+	err      error
+}
+
+/*
+lookup is the iterative Kademlia lookup. It keeps alpha requests in flight to
+the closest contacts it hasn't asked yet, adding the contacts they return to
+its shortlist, until the k closest contacts it knows have all answered or
+failed. A single path FindValue lookup stops as soon as a node returns the
+value.
+
+With more than one path the contacts from the routing table are dealt out
+between them, and each path runs on its own, see Disjoint.
+*/
+func (d *DHT) lookup(
+	ctx context.Context,
+	target NodeID,
+	value bool,
+	opts []LookupOption,
+) ([]byte, []Contact, error) {
+	cfg := lookupConfig{paths: 1}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	d.RoutingTable.Touch(target)
+
+	initial := d.RoutingTable.ClosestContacts(target, k)
+	if len(initial) == 0 {
+		return nil, nil, fmt.Errorf("routing table is empty")
+	}
+
+	paths := make([]*path, cfg.paths)
+	for i := range paths {
+		paths[i] = &path{list: newShortlist(target)}
+		paths[i].list.seen[d.Self.Id] = true
+	}
+
+	for i, c := range initial {
+		paths[i%len(paths)].list.add(c)
+	}
+
+	owner := make(map[NodeID]int)
+	status := make(map[NodeID]queryState)
+	replies := make(chan reply)
+	running := 0
+
+	for {
+		for i, p := range paths {
+			for p.value == nil && p.running < alpha {
+				c := p.list.next(i, owner)
+				if c == nil {
+					break
+				}
+
+				owner[c.Id] = i
+				c.state = inflight
+				p.running++
+				running++
+				go d.query(ctx, i, c, target, value, replies)
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+
+		var r reply
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case r = <-replies:
+		}
+
+		p := paths[r.path]
+		p.running--
+		running--
+
+		if r.err != nil {
+			r.c.state = failed
+			status[r.c.Id] = failed
+			continue
+		}
+
+		if r.value != nil {
+			rec, err := verifyRecord(target, r.value)
+			if err != nil {
+				d.logger.Warn("node returned a bad record", "addr", r.c.addr(), "error", err)
+				r.c.state = failed
+				status[r.c.Id] = failed
+				continue
+			}
+
+			if len(paths) == 1 {
+				return r.value, nil, nil
+			}
+
+			if p.value == nil || rec.Seq > p.seq {
+				p.value, p.seq = r.value, rec.Seq
+			}
+		}
+
+		r.c.state = queried
+		status[r.c.Id] = queried
+
+		for _, c := range r.contacts {
+			if c.Valid(d.difficulty) {
+				p.list.add(c)
+			}
+		}
+	}
+
+	var (
+		v   []byte
+		seq uint64
+	)
+
+	for _, p := range paths {
+		if p.value != nil && (v == nil || p.seq > seq) {
+			v, seq = p.value, p.seq
+		}
+	}
+
+	if v != nil {
+		return v, nil, nil
+	}
+
+	contacts := agree(paths, status)
+	if len(contacts) == 0 && len(paths) > 1 {
+		return nil, nil, errNoAgreement
+	}
+
+	return nil, contacts, nil
+}
+
+/*
+agree returns up to k contacts that answered and are among the k closest
+answered contacts of most paths, closest first.
+*/
+func agree(paths []*path, status map[NodeID]queryState) []Contact {
+	votes := make(map[NodeID]int)
+
+	var all []Contact
+
+	for _, p := range paths {
+		n := 0
+		for _, e := range p.list.entries {
+			if n == k {
+				break
+			}
+			if status[e.Id] != queried {
+				continue
+			}
+			n++
+
+			if votes[e.Id] == 0 {
+				all = append(all, e.Contact)
+			}
+			votes[e.Id]++
+		}
+	}
+
+	quorum := len(paths)/2 + 1
+
+	var out []Contact
+	for _, c := range all {
+		if votes[c.Id] >= quorum {
+			out = append(out, c)
+		}
+	}
+
+	sortByDistance(out, paths[0].list.target)
+
+	return out[:min(k, len(out))]
+}
+
+func (d *DHT) query(
+	ctx context.Context,
+	path int,
+	c *candidate,
+	target NodeID,
+	value bool,
+	out chan<- reply,
+) {
+	qctx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+	defer cancel()
+
+	r := reply{path: path, c: c}
+
+	if value {
+		r.value, r.contacts, r.err = d.transport.FindValue(qctx, c.Contact, target)
+	} else {
+		r.contacts, r.err = d.transport.FindNode(qctx, c.Contact, target)
+	}
+
+	d.result(ctx, c.Contact, r.err)
+
+	select {
+	case out <- r:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,407 @@
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"errors"
+	"math/rand/v2"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/store"
+)
+
+// simNet is an in-process network of nodes that call each other's handlers.
+type simNet struct {
+	nodes map[NodeID]*DHT
+
+	// dead nodes fail every request, slow ones never answer.
+	mu   sync.RWMutex
+	dead map[NodeID]bool
+	slow map[NodeID]bool
+}
+
+func (sn *simNet) state(id NodeID) (bool, bool) {
+	sn.mu.RLock()
+	defer sn.mu.RUnlock()
+
+	return sn.dead[id], sn.slow[id]
+}
+
+func (sn *simNet) set(id NodeID, dead, slow bool) {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+
+	sn.dead[id] = dead
+	sn.slow[id] = slow
+}
+
+type simTransport struct {
+	net  *simNet
+	self *DHT
+}
+
+var errUnreachable = errors.New("unreachable")
+
+func (t *simTransport) call(ctx context.Context, to Contact, req *message) (*message, error) {
+	n, ok := t.net.nodes[to.Id]
+	dead, slow := t.net.state(to.Id)
+	if !ok || dead {
+		return nil, errUnreachable
+	}
+
+	if slow {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	req.from = t.self.Self
+
+	// unlike Serve, learn about the sender before answering so tests don't
+	// depend on goroutine timing.
+	n.RoutingTable.Seen(ctx, req.from)
+
+	resp := n.handle(req)
+	if resp.typ == msgError {
+		return nil, errors.New(resp.err)
+	}
+
+	return resp, nil
+}
+
+func (t *simTransport) Ping(ctx context.Context, to Contact) error {
+	_, err := t.call(ctx, to, &message{typ: msgPing})
+	return err
+}
+
+func (t *simTransport) Store(ctx context.Context, to Contact, key NodeID, value []byte) error {
+	_, err := t.call(ctx, to, &message{typ: msgStore, key: key, value: value})
+	return err
+}
+
+func (t *simTransport) FindNode(ctx context.Context, to Contact, target NodeID) ([]Contact, error) {
+	resp, err := t.call(ctx, to, &message{typ: msgFindNode, key: target})
+	if err != nil {
+		return nil, err
+	}
+	return resp.contacts, nil
+}
+
+func (t *simTransport) FindValue(
+	ctx context.Context,
+	to Contact,
+	key NodeID,
+) ([]byte, []Contact, error) {
+	resp, err := t.call(ctx, to, &message{typ: msgFindValue, key: key})
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.value, resp.contacts, nil
+}
+
+func (t *simTransport) FindRecords(
+	ctx context.Context,
+	to Contact,
+	target NodeID,
+	bits int,
+	salt []byte,
+) ([][]byte, error) {
+	req := message{typ: msgFindRecords, key: target, bits: byte(bits), salt: salt}
+
+	resp, err := t.call(ctx, to, &req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.values, nil
+}
+
+func randomID(rng *rand.Rand) NodeID {
+	var id NodeID
+	for i := range id {
+		id[i] = byte(rng.Uint32())
+	}
+	return id
+}
+
+// newSimNet starts n nodes, each bootstrapped through the first one.
+func newSimNet(t *testing.T, n int, rng *rand.Rand) (*simNet, []*DHT) {
+	t.Helper()
+
+	sn := &simNet{
+		nodes: make(map[NodeID]*DHT),
+		dead:  make(map[NodeID]bool),
+		slow:  make(map[NodeID]bool),
+	}
+
+	var nodes []*DHT
+
+	for range n {
+		tr := &simTransport{net: sn}
+
+		pub := randomID(rng)
+
+		d, err := New(Options{
+			V4:           netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), uint16(len(nodes))),
+			PublicKey:    pub[:],
+			Difficulty:   2,
+			Storage:      store.NewMemory(clock.Real{}),
+			Transport:    tr,
+			QueryTimeout: 50 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tr.self = d
+		sn.nodes[d.Self.Id] = d
+		nodes = append(nodes, d)
+	}
+
+	ctx := context.Background()
+	for _, d := range nodes[1:] {
+		err := d.Bootstrap(ctx, []Contact{nodes[0].Self})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// early nodes joined a small network, look around again.
+	for _, d := range nodes {
+		_, err := d.FindNode(ctx, d.Self.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return sn, nodes
+}
+
+// expected is the k closest live nodes to target, other than from.
+func (sn *simNet) expected(target NodeID, from NodeID) []Contact {
+	var all []Contact
+	for id, d := range sn.nodes {
+		dead, slow := sn.state(id)
+		if id != from && !dead && !slow {
+			all = append(all, d.Self)
+		}
+	}
+
+	sortByDistance(all, target)
+
+	return all[:k]
+}
+
+func overlap(a, b []Contact) int {
+	ids := make(map[NodeID]bool)
+	for _, c := range a {
+		ids[c.Id] = true
+	}
+
+	n := 0
+	for _, c := range b {
+		if ids[c.Id] {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestLookupConverges(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	sn, nodes := newSimNet(t, 300, rng)
+
+	ctx := context.Background()
+
+	for range 20 {
+		from := nodes[rng.IntN(len(nodes))]
+		target := randomID(rng)
+
+		got, err := from.FindNode(ctx, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := sn.expected(target, from.Self.Id)
+
+		if got[0].Id != want[0].Id {
+			t.Fatal("lookup should find the closest node")
+		}
+
+		if n := overlap(got, want); n < k-2 {
+			t.Fatalf("lookup found only %d of the %d closest nodes", n, k)
+		}
+	}
+}
+
+func TestLookupFailures(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	sn, nodes := newSimNet(t, 200, rng)
+
+	// after the network formed a tenth of it goes dead or silent.
+	for i, d := range nodes[1:] {
+		switch i % 10 {
+		case 0:
+			sn.set(d.Self.Id, true, false)
+		case 1:
+			sn.set(d.Self.Id, false, true)
+		}
+	}
+
+	ctx := context.Background()
+	from := nodes[0]
+
+	for range 5 {
+		target := randomID(rng)
+
+		start := time.Now()
+		got, err := from.FindNode(ctx, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("slow nodes should time out per query")
+		}
+
+		for _, c := range got {
+			if dead, slow := sn.state(c.Id); dead || slow {
+				t.Fatal("lookup should only return nodes that answered")
+			}
+		}
+
+		// the others still return the failed nodes they haven't evicted,
+		// which pushes some of the live ones out of their answers.
+		want := sn.expected(target, from.Self.Id)
+		if got[0].Id != want[0].Id {
+			t.Fatal("lookup should find the closest live node")
+		}
+
+		if n := overlap(got, want); n < k*3/4 {
+			t.Fatalf("lookup found only %d of the %d closest nodes", n, k)
+		}
+	}
+}
+
+func TestLookupValue(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	sn, nodes := newSimNet(t, 100, rng)
+
+	ctx := context.Background()
+
+	_, priv, _ := ed25519.GenerateKey(crand.Reader)
+	r, _ := NewRecord(priv, []byte("salt"), 1, []byte("hello"))
+
+	err := nodes[10].Store(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, err := nodes[50].FindValue(ctx, r.Key())
+	if err != nil || got == nil || string(got.Value) != "hello" {
+		t.Fatalf("should find stored record. %v %v", got, err)
+	}
+
+	// a node serving a forged record is skipped.
+	forged := *r
+	forged.Value = []byte("evil")
+	for _, c := range nodes[10].RoutingTable.ClosestContacts(r.Key(), 1) {
+		_ = sn.nodes[c.Id].storage.Put(valueKey(r.Key()), forged.Bytes(), 0)
+	}
+
+	got, _, err = nodes[60].FindValue(ctx, r.Key())
+	if err != nil || got == nil || string(got.Value) != "hello" {
+		t.Fatalf("should skip forged record. %v %v", got, err)
+	}
+}
+
+func TestLookupCancel(t *testing.T) {
+	rng := rand.New(rand.NewPCG(7, 8))
+	sn, nodes := newSimNet(t, 50, rng)
+
+	for _, d := range nodes[1:] {
+		sn.set(d.Self.Id, false, true)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err := nodes[0].FindNode(ctx, randomID(rng))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled lookup should return context error. got %v", err)
+	}
+}
+
+func TestDisjointLookup(t *testing.T) {
+	rng := rand.New(rand.NewPCG(13, 14))
+	sn, nodes := newSimNet(t, 300, rng)
+
+	ctx := context.Background()
+
+	for range 10 {
+		from := nodes[rng.IntN(len(nodes))]
+		target := randomID(rng)
+
+		got, err := from.FindNode(ctx, target, Disjoint(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := sn.expected(target, from.Self.Id)
+		if n := overlap(got, want); n < k*3/4 {
+			t.Fatalf("disjoint lookup found only %d of the %d closest nodes", n, k)
+		}
+	}
+
+	// one path hitting an old copy doesn't hide the newer one.
+	_, priv, _ := ed25519.GenerateKey(crand.Reader)
+	old, _ := NewRecord(priv, nil, 1, []byte("old"))
+	r, _ := NewRecord(priv, nil, 2, []byte("new"))
+
+	err := nodes[10].Store(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range sn.expected(r.Key(), NodeID{})[:3] {
+		_ = sn.nodes[c.Id].storage.Put(valueKey(r.Key()), old.Bytes(), 0)
+	}
+
+	got, _, err := nodes[50].FindValue(ctx, r.Key(), Disjoint(3))
+	if err != nil || got == nil || got.Seq != 2 {
+		t.Fatalf("disjoint lookup should find the newest record. %v %v", got, err)
+	}
+}
+
+func TestAgree(t *testing.T) {
+	target := NodeID{}
+	status := make(map[NodeID]queryState)
+
+	contact := func(i byte) Contact {
+		c := Contact{Id: NodeID{i}}
+		status[c.Id] = queried
+		return c
+	}
+
+	honest := []Contact{contact(1), contact(2), contact(3)}
+	evil := []Contact{contact(4), contact(5)}
+
+	var paths []*path
+	for _, cs := range [][]Contact{honest, honest[:2], evil} {
+		p := &path{list: newShortlist(target)}
+		p.list.add(cs...)
+		paths = append(paths, p)
+	}
+
+	got := agree(paths, status)
+	if len(got) != 2 || got[0].Id != honest[0].Id || got[1].Id != honest[1].Id {
+		t.Fatalf("only contacts most paths found should be kept, got %v", got)
+	}
+}
@@ -0,0 +1,224 @@
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/LibSEA/mixnet/store"
+)
+
+// checkInterval is how often the refresh loop looks for stale buckets.
+const checkInterval = refresh / 12
+
+/*
+Maintain runs the maintenance loops until ctx is done:
+
+  - buckets that had no lookup for refresh are refreshed by looking up a
+    random ID in them.
+  - every replicate, the records we hold are pushed to the nodes now closest
+    to their key that don't have them yet.
+  - every republish, the records given to Publish are stored again.
+  - every saveInterval, and once more when ctx is done, the routing table is
+    saved for Join.
+
+Records expire through the storage TTL, expire after they were last stored.
+*/
+func (d *DHT) Maintain(ctx context.Context) {
+	loops := []struct {
+		every time.Duration
+		run   func(context.Context)
+	}{
+		{checkInterval, d.refresh},
+		{replicate, d.replicate},
+		{republish, d.republish},
+		{saveInterval, d.save},
+	}
+
+	var wg sync.WaitGroup
+
+	for _, l := range loops {
+		wg.Go(func() {
+			t := time.NewTicker(l.every)
+			defer t.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					l.run(ctx)
+				}
+			}
+		})
+	}
+
+	wg.Wait()
+
+	d.save(ctx)
+}
+
+func (d *DHT) save(context.Context) {
+	err := d.Save()
+	if err != nil {
+		d.logger.Error("failed to save routing table", "error", err)
+	}
+}
+
+/*
+Publish stores r like Store and keeps storing it again every republish until
+Unpublish is called for its key.
+*/
+func (d *DHT) Publish(ctx context.Context, r *Record) error {
+	err := d.Store(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	d.publishMu.Lock()
+	defer d.publishMu.Unlock()
+
+	d.published[r.Key()] = r
+
+	return nil
+}
+
+// Unpublish stops republishing the record under key.
+func (d *DHT) Unpublish(key NodeID) {
+	d.publishMu.Lock()
+	defer d.publishMu.Unlock()
+
+	delete(d.published, key)
+}
+
+func (d *DHT) refresh(ctx context.Context) {
+	for _, i := range d.RoutingTable.Stale(refresh) {
+		target, err := randomIDInBucket(d.Self.Id, i)
+		if err != nil {
+			d.logger.Error("failed to pick refresh target", "error", err)
+			return
+		}
+
+		_, err = d.FindNode(ctx, target)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			d.logger.Debug("bucket refresh failed", "bucket", i, "error", err)
+		}
+	}
+}
+
+/*
+replicate pushes every record we hold to the k closest nodes to its key.
+Nodes that already have it, or a newer one, are skipped so replicas don't
+keep each other alive past expire.
+*/
+func (d *DHT) replicate(ctx context.Context) {
+	d.storeMu.Lock()
+	keys := make([]NodeID, 0, len(d.stored))
+	for key := range d.stored {
+		keys = append(keys, key)
+	}
+	d.storeMu.Unlock()
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return
+		}
+
+		v, err := d.storage.Get(valueKey(key))
+		if errors.Is(err, store.ErrKeyMissing) {
+			d.storeMu.Lock()
+			delete(d.stored, key)
+			d.storeMu.Unlock()
+			continue
+		}
+
+		if err != nil {
+			d.logger.Error("failed to get stored record", "error", err)
+			continue
+		}
+
+		r, err := ParseRecord(v)
+		if err != nil {
+			continue
+		}
+
+		contacts, err := d.FindNode(ctx, key)
+		if err != nil {
+			d.logger.Debug("replicate lookup failed", "key", key, "error", err)
+			continue
+		}
+
+		for _, c := range contacts {
+			d.replicateTo(ctx, c, r, v)
+		}
+	}
+}
+
+func (d *DHT) replicateTo(ctx context.Context, c Contact, r *Record, v []byte) {
+	key := r.Key()
+
+	have, _, err := d.transport.FindValue(ctx, c, key)
+	if err != nil {
+		return
+	}
+
+	if have != nil {
+		o, err := verifyRecord(key, have)
+		if err == nil && o.Seq >= r.Seq {
+			return
+		}
+	}
+
+	err = d.transport.Store(ctx, c, key, v)
+	if err != nil {
+		d.logger.Debug("replicate failed", "addr", c.addr(), "error", err)
+	}
+}
+
+func (d *DHT) republish(ctx context.Context) {
+	d.publishMu.Lock()
+	records := make([]*Record, 0, len(d.published))
+	for _, r := range d.published {
+		records = append(records, r)
+	}
+	d.publishMu.Unlock()
+
+	for _, r := range records {
+		err := d.Store(ctx, r)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			d.logger.Warn("republish failed", "key", r.Key(), "error", err)
+		}
+	}
+}
+
+// randomIDInBucket returns a random ID that falls in bucket i of self.
+func randomIDInBucket(self NodeID, i int) (NodeID, error) {
+	var id NodeID
+
+	_, err := rand.Read(id[:])
+	if err != nil {
+		return id, err
+	}
+
+	// keep the first i bits of self, flip bit i.
+	for j := range i / 8 {
+		id[j] = self[j]
+	}
+
+	byt, bit := i/8, uint(i%8)
+	keep := byte(0xff) << (8 - bit)
+	flip := byte(0x80) >> bit
+	id[byt] = self[byt]&keep | ^self[byt]&flip | id[byt]&^(keep|flip)
+
+	return id, nil
+}
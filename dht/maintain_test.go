@@ -0,0 +1,157 @@
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"log/slog"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/store"
+)
+
+func TestRandomIDInBucket(t *testing.T) {
+	rng := rand.New(rand.NewPCG(7, 8))
+	self := randomID(rng)
+
+	for i := range b {
+		id, err := randomIDInBucket(self, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := bucketIndex(self, id); got != i {
+			t.Fatalf("id should be in bucket %d, got %d", i, got)
+		}
+	}
+}
+
+func TestStale(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	self := NodeID{}
+	rt := NewRoutingTable(self, &fakePinger{}, clk, slog.New(slog.DiscardHandler), Limits{})
+
+	ctx := context.Background()
+	rt.Seen(ctx, Contact{Id: idInBucket(0, 1)})
+	rt.Seen(ctx, Contact{Id: idInBucket(3, 1)})
+
+	if got := rt.Stale(refresh); len(got) != 0 {
+		t.Fatalf("no bucket should be stale yet, got %v", got)
+	}
+
+	clk.Advance(refresh)
+	rt.Touch(idInBucket(1, 2))
+
+	got := rt.Stale(refresh)
+	if len(got) != 3 || got[0] != 0 || got[1] != 2 || got[2] != 3 {
+		t.Fatalf("buckets 0, 2 and 3 should be stale, got %v", got)
+	}
+}
+
+// wipe lets every copy of the record under key expire.
+func wipe(sn *simNet, key NodeID) {
+	for _, d := range sn.nodes {
+		_ = d.storage.Update(valueKey(key), time.Nanosecond)
+	}
+	time.Sleep(time.Millisecond)
+}
+
+func holders(sn *simNet, key NodeID) int {
+	n := 0
+	for _, d := range sn.nodes {
+		if _, err := d.storage.Get(valueKey(key)); err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func TestReplicate(t *testing.T) {
+	rng := rand.New(rand.NewPCG(9, 10))
+	sn, nodes := newSimNet(t, 100, rng)
+
+	ctx := context.Background()
+
+	_, priv, _ := ed25519.GenerateKey(crand.Reader)
+	r, _ := NewRecord(priv, []byte("salt"), 1, []byte("hello"))
+
+	err := nodes[10].Store(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keep a single copy.
+	var holder *DHT
+	for _, d := range sn.nodes {
+		if _, err := d.storage.Get(valueKey(r.Key())); err == nil {
+			holder = d
+			break
+		}
+	}
+
+	wipe(sn, r.Key())
+	_ = holder.storage.Put(valueKey(r.Key()), r.Bytes(), expire)
+
+	holder.replicate(ctx)
+
+	if n := holders(sn, r.Key()); n < k {
+		t.Fatalf("record should be back on k nodes, got %d", n)
+	}
+}
+
+func TestRepublish(t *testing.T) {
+	rng := rand.New(rand.NewPCG(11, 12))
+	sn, nodes := newSimNet(t, 100, rng)
+
+	ctx := context.Background()
+
+	_, priv, _ := ed25519.GenerateKey(crand.Reader)
+	r, _ := NewRecord(priv, []byte("salt"), 1, []byte("hello"))
+
+	err := nodes[10].Publish(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wipe(sn, r.Key())
+	nodes[10].republish(ctx)
+
+	got, _, err := nodes[50].FindValue(ctx, r.Key())
+	if err != nil || got == nil {
+		t.Fatalf("should find republished record. %v", err)
+	}
+
+	nodes[10].Unpublish(r.Key())
+	wipe(sn, r.Key())
+	nodes[10].republish(ctx)
+
+	if n := holders(sn, r.Key()); n != 0 {
+		t.Fatalf("unpublished record should not be stored, got %d", n)
+	}
+}
+
+func TestMaintainStops(t *testing.T) {
+	d, err := New(Options{Storage: store.NewMemory(clock.Real{})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		d.Maintain(ctx)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("maintain should stop when ctx is done")
+	}
+}
@@ -0,0 +1,253 @@
+package dht
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/LibSEA/mixnet/store"
+)
+
+// saveInterval is how often Maintain snapshots the routing table.
+const saveInterval = 10 * time.Minute
+
+// tableVersion is the version of the snapshot format.
+const tableVersion = 0x2
+
+var (
+	tableKey  = []byte("dht/table")
+	storedKey = []byte("dht/stored")
+)
+
+/*
+A snapshot of the routing table is
+
+	version (1) | own id (32) | count (2) | entries
+
+with every entry a wire contact followed by its last seen time in unix
+nanoseconds (8). Buckets are written in order, each oldest contact first, so
+restoring them in the same order keeps the least recently seen order.
+*/
+func encodeTable(self NodeID, es []entry) []byte {
+	out := []byte{tableVersion}
+	out = append(out, self[:]...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(es)))
+
+	for i := range es {
+		out = appendContact(out, &es[i].Contact)
+		out = binary.BigEndian.AppendUint64(out, uint64(es[i].LastSeen.UnixNano()))
+	}
+
+	return out
+}
+
+func decodeTable(b []byte) (NodeID, []entry, error) {
+	d := decoder{b: b}
+
+	if v := d.byte(); d.err == nil && v != tableVersion {
+		return NodeID{}, nil, fmt.Errorf("unsupported routing table version %d", v)
+	}
+
+	self := d.id()
+
+	n := int(d.uint16())
+	es := make([]entry, 0, n)
+
+	for range n {
+		c := d.contact()
+		seen := time.Unix(0, int64(d.uint64()))
+		es = append(es, entry{Contact: c, LastSeen: seen})
+	}
+
+	if d.err != nil {
+		return NodeID{}, nil, fmt.Errorf("failed to decode routing table. %w", d.err)
+	}
+
+	if len(d.b) != 0 {
+		return NodeID{}, nil, fmt.Errorf("trailing bytes after routing table")
+	}
+
+	return self, es, nil
+}
+
+// TableEntry is a contact of a routing table, running or saved.
+type TableEntry struct {
+	Contact
+	LastSeen time.Time
+	// Bucket is the index of the bucket the contact was in.
+	Bucket int
+}
+
+/*
+LoadTable reads the routing table Save wrote to s, in bucket order, and the
+ID of the node that saved it.
+*/
+func LoadTable(s store.Storage) (NodeID, []TableEntry, error) {
+	b, err := s.Get(tableKey)
+	if err != nil {
+		return NodeID{}, nil, fmt.Errorf("failed to load routing table. %w", err)
+	}
+
+	self, es, err := decodeTable(b)
+	if err != nil {
+		return NodeID{}, nil, err
+	}
+
+	return self, tableEntries(self, es), nil
+}
+
+// Entries is what LoadTable would read from a snapshot taken now.
+func (t *RoutingTable) Entries() []TableEntry {
+	return tableEntries(t.self, t.entries())
+}
+
+func tableEntries(self NodeID, es []entry) []TableEntry {
+	out := make([]TableEntry, len(es))
+	for i, e := range es {
+		out[i] = TableEntry{
+			Contact:  e.Contact,
+			LastSeen: e.LastSeen,
+			Bucket:   bucketIndex(self, e.Id),
+		}
+	}
+
+	return out
+}
+
+// Save writes a snapshot of the routing table to storage.
+func (d *DHT) Save() error {
+	err := d.storage.Put(tableKey, encodeTable(d.Self.Id, d.RoutingTable.entries()), 0)
+	if err != nil {
+		return fmt.Errorf("failed to save routing table. %w", err)
+	}
+
+	return nil
+}
+
+/*
+The index of stored records is saved as their keys one after another. It
+is written when a key is added, so records stored before a restart are
+still replicated and sampled after it. Keys of records that expired since
+are dropped by replicate. d.storeMu must be held.
+*/
+func (d *DHT) saveStored() error {
+	out := make([]byte, 0, len(d.stored)*len(NodeID{}))
+	for key := range d.stored {
+		out = append(out, key[:]...)
+	}
+
+	err := d.storage.Put(storedKey, out, 0)
+	if err != nil {
+		return fmt.Errorf("failed to save stored record index. %w", err)
+	}
+
+	return nil
+}
+
+func (d *DHT) loadStored() error {
+	b, err := d.storage.Get(storedKey)
+	if errors.Is(err, store.ErrKeyMissing) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to load stored record index. %w", err)
+	}
+
+	if len(b)%len(NodeID{}) != 0 {
+		return fmt.Errorf("bad stored record index")
+	}
+
+	for key := range slices.Chunk(b, len(NodeID{})) {
+		d.stored[NodeID(key)] = struct{}{}
+	}
+
+	return nil
+}
+
+/*
+Join brings the node back into the network. It pings the contacts of the
+saved routing table and restores the ones that answer, falling back to the
+seeds when none do, then looks up its own ID.
+*/
+func (d *DHT) Join(ctx context.Context) error {
+	saved, err := d.load()
+	if err != nil {
+		d.logger.Warn("ignoring saved routing table", "error", err)
+	}
+
+	d.RoutingTable.restore(d.alive(ctx, saved))
+
+	if d.RoutingTable.Len() == 0 {
+		if len(d.seeds) == 0 {
+			return fmt.Errorf("no saved contact answered and there are no seeds")
+		}
+
+		return d.Bootstrap(ctx, d.seeds)
+	}
+
+	d.logger.Info("restored routing table", "contacts", d.RoutingTable.Len())
+
+	_, err = d.FindNode(ctx, d.Self.Id)
+	if err != nil {
+		return fmt.Errorf("failed to rejoin. %w", err)
+	}
+
+	return nil
+}
+
+func (d *DHT) load() ([]entry, error) {
+	b, err := d.storage.Get(tableKey)
+	if errors.Is(err, store.ErrKeyMissing) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load routing table. %w", err)
+	}
+
+	_, es, err := decodeTable(b)
+	if err != nil {
+		return nil, err
+	}
+
+	valid := es[:0]
+	for _, e := range es {
+		if e.Valid(d.difficulty) && e.Id != d.Self.Id {
+			valid = append(valid, e)
+		}
+	}
+
+	return valid, nil
+}
+
+// alive pings es all at once and returns the ones that answered, in order.
+func (d *DHT) alive(ctx context.Context, es []entry) []entry {
+	ok := make([]bool, len(es))
+
+	var wg sync.WaitGroup
+
+	for i := range es {
+		wg.Go(func() {
+			qctx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+			defer cancel()
+
+			ok[i] = d.transport.Ping(qctx, es[i].Contact) == nil
+		})
+	}
+
+	wg.Wait()
+
+	var out []entry
+	for i := range es {
+		if ok[i] {
+			out = append(out, es[i])
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,116 @@
+package dht
+
+import (
+	"context"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/store"
+)
+
+// restart replaces d in sn with a new node on the same key and storage.
+func restart(t *testing.T, sn *simNet, d *DHT, seeds []Contact) *DHT {
+	t.Helper()
+
+	tr := &simTransport{net: sn}
+
+	n, err := New(Options{
+		V4:           d.Self.V4,
+		PublicKey:    d.Self.PublicKey[:],
+		Difficulty:   d.difficulty,
+		Storage:      d.storage,
+		Transport:    tr,
+		QueryTimeout: d.queryTimeout,
+		Seeds:        seeds,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr.self = n
+	sn.nodes[n.Self.Id] = n
+
+	return n
+}
+
+func TestTableEncoding(t *testing.T) {
+	rng := rand.New(rand.NewPCG(15, 16))
+	_, nodes := newSimNet(t, 30, rng)
+
+	es := nodes[0].RoutingTable.entries()
+
+	self, got, err := decodeTable(encodeTable(nodes[0].Self.Id, es))
+	if err != nil || self != nodes[0].Self.Id {
+		t.Fatalf("table should decode with its owner. %v", err)
+	}
+
+	if len(got) != len(es) {
+		t.Fatalf("expected %d entries. got %d", len(es), len(got))
+	}
+
+	for i := range es {
+		if got[i].Contact != es[i].Contact || !got[i].LastSeen.Equal(es[i].LastSeen) {
+			t.Fatalf("entry %d changed in round trip", i)
+		}
+	}
+
+	b := encodeTable(self, es)
+	if _, _, err := decodeTable(b[:len(b)-1]); err == nil {
+		t.Fatal("truncated table should fail")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	rng := rand.New(rand.NewPCG(17, 18))
+	sn, nodes := newSimNet(t, 100, rng)
+
+	ctx := context.Background()
+	d := nodes[5]
+
+	err := d.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := d.RoutingTable.entries()
+	sn.set(saved[0].Id, true, false)
+
+	n := restart(t, sn, d, nil)
+
+	err = n.Join(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[NodeID]bool{}
+	for _, e := range n.RoutingTable.entries() {
+		got[e.Id] = true
+	}
+
+	if got[saved[0].Id] {
+		t.Fatal("dead saved contact should not be restored")
+	}
+
+	for _, e := range saved[1:] {
+		if !got[e.Id] {
+			t.Fatal("live saved contacts should be restored")
+		}
+	}
+
+	// with nothing saved the seeds are used.
+	fresh := &DHT{storage: store.NewMemory(clock.Real{})}
+	fresh.Self = nodes[6].Self
+	fresh.difficulty = nodes[6].difficulty
+	fresh.queryTimeout = nodes[6].queryTimeout
+
+	n = restart(t, sn, fresh, nil)
+	if err := n.Join(ctx); err == nil {
+		t.Fatal("join without saved contacts or seeds should fail")
+	}
+
+	n = restart(t, sn, fresh, []Contact{nodes[0].Self})
+	if err := n.Join(ctx); err != nil || n.RoutingTable.Len() == 0 {
+		t.Fatalf("join should fall back to seeds. %v", err)
+	}
+}
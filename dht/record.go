@@ -0,0 +1,181 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/*
+Record is the only kind of value the DHT stores. It lives under the hash of
+the public key that signed it and an optional salt, so only the holder of the
+private key can write there, and one key can own several records by using
+different salts. The sequence number only goes up: a node never replaces a
+record with one that has a lower sequence number, so old versions can't be
+replayed over new ones.
+
+The encoding is
+
+	public key (32) | salt length (1) | salt | seq (8) | value length (2) |
+	value | signature (64)
+
+and the signature covers everything before it, prefixed with recordContext.
+
+Nodes reject any write that isn't signed and verified, which should make it
+hard to launch some of the known attacks against kademlia.
+*/
+type Record struct {
+	PublicKey ed25519.PublicKey
+	Salt      []byte
+	Seq       uint64
+	Value     []byte
+	Signature []byte
+}
+
+const (
+	MaxSaltSize = 64
+
+	recordOverhead = ed25519.PublicKeySize + 1 + 8 + 2 + ed25519.SignatureSize
+	recordContext  = "mixnet dht record v1"
+)
+
+var (
+	ErrBadSignature = errors.New("record signature is invalid")
+	ErrStaleRecord  = errors.New("record is older than the stored one")
+)
+
+// RecordKey is the DHT key of the records of pub with salt.
+func RecordKey(pub ed25519.PublicKey, salt []byte) NodeID {
+	h := sha256.New()
+	_, _ = h.Write(pub)
+	_, _ = h.Write(salt)
+
+	var out NodeID
+	copy(out[:], h.Sum(nil))
+
+	return out
+}
+
+// NewRecord makes a record of value signed by priv.
+func NewRecord(
+	priv ed25519.PrivateKey,
+	salt []byte,
+	seq uint64,
+	value []byte,
+) (*Record, error) {
+	r := Record{
+		PublicKey: priv.Public().(ed25519.PublicKey),
+		Salt:      salt,
+		Seq:       seq,
+		Value:     value,
+	}
+
+	err := r.check()
+	if err != nil {
+		return nil, err
+	}
+
+	r.Signature = ed25519.Sign(priv, r.signed())
+
+	return &r, nil
+}
+
+func (r *Record) Key() NodeID {
+	return RecordKey(r.PublicKey, r.Salt)
+}
+
+func (r *Record) Verify() error {
+	err := r.check()
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(r.PublicKey, r.signed(), r.Signature) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
+
+func (r *Record) check() error {
+	if len(r.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("bad record public key size %d", len(r.PublicKey))
+	}
+
+	if len(r.Salt) > MaxSaltSize {
+		return fmt.Errorf("record salt too large %d", len(r.Salt))
+	}
+
+	if recordOverhead+len(r.Salt)+len(r.Value) > MaxValueSize {
+		return fmt.Errorf("record value too large %d", len(r.Value))
+	}
+
+	return nil
+}
+
+func (r *Record) signed() []byte {
+	out := make([]byte, 0, len(recordContext)+recordOverhead+len(r.Salt)+len(r.Value))
+	out = append(out, recordContext...)
+
+	return r.appendBody(out)
+}
+
+// appendBody appends the encoding without the signature.
+func (r *Record) appendBody(out []byte) []byte {
+	out = append(out, r.PublicKey...)
+	out = append(out, byte(len(r.Salt)))
+	out = append(out, r.Salt...)
+	out = binary.BigEndian.AppendUint64(out, r.Seq)
+
+	return appendBytes(out, r.Value)
+}
+
+func (r *Record) Bytes() []byte {
+	out := make([]byte, 0, recordOverhead+len(r.Salt)+len(r.Value))
+	out = r.appendBody(out)
+
+	return append(out, r.Signature...)
+}
+
+// ParseRecord decodes a record. It doesn't verify it.
+func ParseRecord(b []byte) (*Record, error) {
+	var r Record
+	var d = decoder{b: b}
+
+	r.PublicKey = ed25519.PublicKey(d.next(ed25519.PublicKeySize))
+	r.Salt = d.next(int(d.byte()))
+	r.Seq = d.uint64()
+	r.Value = d.lenBytes()
+	r.Signature = d.next(ed25519.SignatureSize)
+
+	if d.err != nil {
+		return nil, fmt.Errorf("bad record. %w", d.err)
+	}
+
+	if len(d.b) != 0 {
+		return nil, fmt.Errorf("%d trailing bytes in record", len(d.b))
+	}
+
+	return &r, nil
+}
+
+// verifyRecord parses b and checks it is a valid record for key.
+func verifyRecord(key NodeID, b []byte) (*Record, error) {
+	r, err := ParseRecord(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Key() != key {
+		return nil, fmt.Errorf("record doesn't belong under key")
+	}
+
+	err = r.Verify()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
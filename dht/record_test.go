@@ -0,0 +1,128 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/store"
+)
+
+func TestRecord(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+
+	r, err := NewRecord(priv, []byte("a"), 7, []byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := verifyRecord(RecordKey(pub, []byte("a")), r.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Seq != 7 || string(got.Value) != "value" || string(got.Salt) != "a" {
+		t.Fatalf("round trip changed record. %+v", got)
+	}
+
+	if RecordKey(pub, []byte("a")) == RecordKey(pub, []byte("b")) {
+		t.Fatal("salt should change the key")
+	}
+
+	_, err = verifyRecord(RecordKey(pub, nil), r.Bytes())
+	if err == nil {
+		t.Fatal("record under the wrong key should fail")
+	}
+
+	bad := *r
+	bad.Seq++
+	if !errors.Is(bad.Verify(), ErrBadSignature) {
+		t.Fatal("changing the seq should break the signature")
+	}
+
+	_, err = NewRecord(priv, make([]byte, MaxSaltSize+1), 0, nil)
+	if err == nil {
+		t.Fatal("oversized salt should fail")
+	}
+
+	_, err = NewRecord(priv, nil, 0, make([]byte, MaxValueSize))
+	if err == nil {
+		t.Fatal("oversized value should fail")
+	}
+}
+
+func TestStoreRecord(t *testing.T) {
+	d, err := New(Options{Storage: store.NewMemory(clock.Real{})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	v2, _ := NewRecord(priv, nil, 2, []byte("two"))
+	v1, _ := NewRecord(priv, nil, 1, []byte("one"))
+	v2b, _ := NewRecord(priv, nil, 2, []byte("other two"))
+	v3, _ := NewRecord(priv, nil, 3, []byte("three"))
+	key := v2.Key()
+
+	if err := d.storeRecord(key, v2.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.storeRecord(key, v2.Bytes()); err != nil {
+		t.Fatalf("storing the same record again should refresh it. %v", err)
+	}
+
+	if !errors.Is(d.storeRecord(key, v1.Bytes()), ErrStaleRecord) {
+		t.Fatal("older record should be rejected")
+	}
+
+	if !errors.Is(d.storeRecord(key, v2b.Bytes()), ErrStaleRecord) {
+		t.Fatal("different record with the same seq should be rejected")
+	}
+
+	unsigned := *v3
+	unsigned.Signature = make([]byte, ed25519.SignatureSize)
+	if d.storeRecord(key, unsigned.Bytes()) == nil {
+		t.Fatal("unsigned record should be rejected")
+	}
+
+	if err := d.storeRecord(key, v3.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, err := d.FindValue(t.Context(), key)
+	if err != nil || got.Seq != 3 {
+		t.Fatalf("newest record should be stored. %v %v", got, err)
+	}
+}
+
+func TestStoredAfterRestart(t *testing.T) {
+	s := store.NewMemory(clock.Real{})
+
+	d, err := New(Options{Storage: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	r, _ := NewRecord(priv, []byte("salt"), 1, []byte("kept"))
+
+	if err := d.storeRecord(r.Key(), r.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := New(Options{Storage: s})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := restarted.stored[r.Key()]; !ok {
+		t.Fatal("records stored before a restart should be indexed after it")
+	}
+
+	if got := restarted.records(r.Key(), 0, []byte("salt")); len(got) != 1 {
+		t.Fatalf("records stored before a restart should be sampled. got %d", len(got))
+	}
+}
@@ -0,0 +1,154 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// samplePaths is how many disjoint paths Sample looks up its target with.
+const samplePaths = 3
+
+/*
+Sample returns the records with salt stored near target. It finds the k
+closest nodes to target over disjoint paths and asks each for its records
+whose key shares a prefix with target, long enough that these nodes are the
+ones responsible for the keys under it.
+
+Records are replicated on k nodes, so one a node made up or kept for itself
+is only on that node. A record is only returned if at least quorum nodes
+have it, and then in the newest version any of them had. The result is
+sorted by distance to target.
+*/
+func (d *DHT) Sample(
+	ctx context.Context,
+	target NodeID,
+	salt []byte,
+	quorum int,
+) ([]*Record, error) {
+	contacts, err := d.FindNode(ctx, target, Disjoint(samplePaths))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(contacts) == 0 {
+		return nil, fmt.Errorf("no nodes near target")
+	}
+
+	// with fewer than k nodes in the network all of them hold every key.
+	bits := 0
+	if len(contacts) == k {
+		bits = max(bucketIndex(target, contacts[len(contacts)-1].Id), 0)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		votes  = make(map[NodeID]int)
+		newest = make(map[NodeID]*Record)
+	)
+
+	for _, c := range contacts {
+		wg.Go(func() {
+			qctx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+			defer cancel()
+
+			values, err := d.transport.FindRecords(qctx, c, target, bits, salt)
+			if err != nil {
+				d.logger.Debug("find records failed", "addr", c.addr(), "error", err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			seen := make(map[NodeID]bool)
+			for _, v := range values {
+				r, err := ParseRecord(v)
+				if err != nil || r.Verify() != nil || !bytes.Equal(r.Salt, salt) {
+					d.logger.Debug("node returned a bad record", "addr", c.addr())
+					continue
+				}
+
+				key := r.Key()
+				if seen[key] || !hasPrefix(key, target, bits) {
+					continue
+				}
+				seen[key] = true
+
+				votes[key]++
+				if o, ok := newest[key]; !ok || r.Seq > o.Seq {
+					newest[key] = r
+				}
+			}
+		})
+	}
+
+	wg.Wait()
+
+	var out []*Record
+	for key, r := range newest {
+		if votes[key] >= quorum {
+			out = append(out, r)
+		}
+	}
+
+	slices.SortFunc(out, func(a, b *Record) int {
+		return target.Xor(a.Key()).Cmp(target.Xor(b.Key()))
+	})
+
+	return out, nil
+}
+
+/*
+records returns our records with salt whose key shares the first bits bits
+with target, closest first, as many as fit in one response.
+*/
+func (d *DHT) records(target NodeID, bits int, salt []byte) [][]byte {
+	d.storeMu.Lock()
+	var keys []NodeID
+	for key := range d.stored {
+		if hasPrefix(key, target, bits) {
+			keys = append(keys, key)
+		}
+	}
+	d.storeMu.Unlock()
+
+	slices.SortFunc(keys, func(a, b NodeID) int {
+		return target.Xor(a).Cmp(target.Xor(b))
+	})
+
+	var (
+		out  [][]byte
+		size int
+	)
+
+	for _, key := range keys {
+		v, err := d.storage.Get(valueKey(key))
+		if err != nil {
+			continue
+		}
+
+		r, err := ParseRecord(v)
+		if err != nil || !bytes.Equal(r.Salt, salt) {
+			continue
+		}
+
+		if size+len(v) > maxRecordsSize || len(out) == 255 {
+			break
+		}
+
+		out = append(out, v)
+		size += len(v)
+	}
+
+	return out
+}
+
+// hasPrefix reports whether a and b share their first bits bits.
+func hasPrefix(a, b NodeID, bits int) bool {
+	i := bucketIndex(a, b)
+	return i < 0 || i >= bits
+}
@@ -0,0 +1,75 @@
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestSample(t *testing.T) {
+	rng := rand.New(rand.NewPCG(19, 20))
+	sn, nodes := newSimNet(t, 100, rng)
+
+	ctx := context.Background()
+	salt := []byte("sample")
+
+	published := map[NodeID]bool{}
+	for i := range 30 {
+		_, priv, _ := ed25519.GenerateKey(crand.Reader)
+		r, _ := NewRecord(priv, salt, 1, []byte("node"))
+
+		err := nodes[i].Store(ctx, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		published[r.Key()] = true
+	}
+
+	// records with another salt are not sampled.
+	_, priv, _ := ed25519.GenerateKey(crand.Reader)
+	other, _ := NewRecord(priv, []byte("other"), 1, nil)
+	_ = nodes[0].Store(ctx, other)
+
+	found := map[NodeID]bool{}
+	for range 30 {
+		got, err := nodes[50].Sample(ctx, randomID(rng), salt, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, r := range got {
+			if !published[r.Key()] {
+				t.Fatal("sample should only return published records")
+			}
+			found[r.Key()] = true
+		}
+	}
+
+	if len(found) < len(published)/2 {
+		t.Fatalf("random samples should find most records, found %d", len(found))
+	}
+
+	// a record only one node has needs a quorum of one.
+	_, priv, _ = ed25519.GenerateKey(crand.Reader)
+	hoarded, _ := NewRecord(priv, salt, 1, []byte("sybil"))
+	liar := sn.nodes[sn.expected(hoarded.Key(), NodeID{})[0].Id]
+
+	err := liar.storeRecord(hoarded.Key(), hoarded.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := nodes[60].Sample(ctx, hoarded.Key(), salt, 2)
+	for _, r := range got {
+		if r.Key() == hoarded.Key() {
+			t.Fatal("record on a single node should not reach the quorum")
+		}
+	}
+
+	got, _ = nodes[60].Sample(ctx, hoarded.Key(), salt, 1)
+	if len(got) == 0 || got[0].Key() != hoarded.Key() {
+		t.Fatal("record on a single node should be found with a quorum of one")
+	}
+}
@@ -0,0 +1,478 @@
+package dht
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"math/bits"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/maplist"
+)
+
+// Pinger checks whether a contact is still alive.
+type Pinger interface {
+	Ping(ctx context.Context, c Contact) error
+}
+
+/*
+RoutingTable keeps up to k contacts for every bit of the ID. Bucket i holds
+contacts whose ID shares exactly the first i bits with ours, so each bucket
+covers half the distance of the one before it.
+
+Inside a bucket contacts are in least recently seen order, oldest at the
+front. Long lived nodes tend to stay up, so when a bucket is full a new
+contact only gets in if the oldest one doesn't answer a ping. Until then it
+waits in the bucket's replacement cache.
+*/
+type RoutingTable struct {
+	mu      sync.Mutex
+	self    NodeID
+	buckets [b]bucket
+	pinger  Pinger
+	clock   clock.Clock
+	logger  *slog.Logger
+
+	limits Limits
+	// prefixes counts the contacts in the table by network.
+	prefixes map[netip.Prefix]int
+
+	bucketRejects atomic.Uint64
+	tableRejects  atomic.Uint64
+}
+
+/*
+Limits caps how many contacts from one network, an IPv4 /24 or IPv6 /48,
+the routing table takes. A contact with both families counts in both.
+Addresses in one such network are cheap to get, so without a cap a single
+operator could fill our buckets with Sybils. A zero limit uses the default
+and a negative one turns the limit off.
+
+Loopback addresses and contacts without one are never limited, so local test
+networks still work.
+*/
+type Limits struct {
+	Bucket int
+	Table  int
+}
+
+const (
+	DefaultBucketLimit = 2
+	DefaultTableLimit  = 10
+)
+
+// Stats counts contacts the routing table turned away.
+type Stats struct {
+	BucketRejects uint64
+	TableRejects  uint64
+}
+
+type bucket struct {
+	contacts     *maplist.MapList[NodeID, *entry]
+	replacements *maplist.MapList[NodeID, *entry]
+	// pinging is set while the oldest contact is being pinged.
+	pinging bool
+	// lastLookup is when a lookup last went through this bucket.
+	lastLookup time.Time
+}
+
+type entry struct {
+	Contact
+	LastSeen time.Time
+}
+
+func (e *entry) GetKey() NodeID {
+	return e.Id
+}
+
+func NewRoutingTable(
+	self NodeID,
+	p Pinger,
+	c clock.Clock,
+	logger *slog.Logger,
+	limits Limits,
+) *RoutingTable {
+	t := RoutingTable{
+		self:     self,
+		pinger:   p,
+		clock:    c,
+		logger:   logger,
+		limits:   limits,
+		prefixes: make(map[netip.Prefix]int),
+	}
+
+	if t.limits.Bucket == 0 {
+		t.limits.Bucket = DefaultBucketLimit
+	}
+
+	if t.limits.Table == 0 {
+		t.limits.Table = DefaultTableLimit
+	}
+
+	now := c.Now()
+	for i := range t.buckets {
+		t.buckets[i] = bucket{
+			contacts:     maplist.New[NodeID, *entry](),
+			replacements: maplist.New[NodeID, *entry](),
+			lastLookup:   now,
+		}
+	}
+
+	return &t
+}
+
+/*
+Seen records that we heard from c. If c's bucket is full this pings the
+oldest contact in it and blocks until the ping is done.
+*/
+func (t *RoutingTable) Seen(ctx context.Context, c Contact) {
+	t.mu.Lock()
+
+	i := bucketIndex(t.self, c.Id)
+	if i < 0 {
+		t.mu.Unlock()
+		return
+	}
+
+	bk := &t.buckets[i]
+	e := &entry{Contact: c, LastSeen: t.clock.Now()}
+
+	if el, ok := bk.contacts.Get(c.Id); ok {
+		old := bk.contacts.Val(el)
+		c.merge(&old.Contact)
+
+		// a new address may put the contact in a network it wasn't counted
+		// in, that network has to be under the limits like for a new contact.
+		var added []netip.Prefix
+		for _, p := range ipPrefixes(&c) {
+			if !slices.Contains(ipPrefixes(&old.Contact), p) {
+				added = append(added, p)
+			}
+		}
+
+		if !t.admitPrefixes(bk, &c, added) {
+			t.mu.Unlock()
+			return
+		}
+
+		t.count(&old.Contact, -1)
+		t.count(&c, 1)
+
+		old.LastSeen = e.LastSeen
+		old.Contact = c
+		bk.contacts.MoveToBack(el)
+		t.mu.Unlock()
+		return
+	}
+
+	if !t.admit(bk, &c) {
+		t.mu.Unlock()
+		return
+	}
+
+	if bk.contacts.Len() < k {
+		t.push(bk, e)
+		t.mu.Unlock()
+		return
+	}
+
+	if el, ok := bk.replacements.Get(c.Id); ok {
+		bk.replacements.Remove(el)
+	}
+
+	bk.replacements.PushBack(e)
+	if bk.replacements.Len() > k {
+		bk.replacements.Remove(bk.replacements.Front())
+	}
+
+	if bk.pinging {
+		t.mu.Unlock()
+		return
+	}
+
+	bk.pinging = true
+	oldest := *bk.contacts.Val(bk.contacts.Front())
+	t.mu.Unlock()
+
+	err := t.pinger.Ping(ctx, oldest.Contact)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bk.pinging = false
+
+	el, ok := bk.contacts.Get(oldest.Id)
+	if !ok {
+		return
+	}
+
+	if err == nil {
+		bk.contacts.Val(el).LastSeen = t.clock.Now()
+		bk.contacts.MoveToBack(el)
+		return
+	}
+
+	t.logger.Debug("evicting unresponsive contact", "id", oldest.Id, "error", err)
+	t.remove(bk, el)
+}
+
+// Remove drops the contact with id, for example after it failed a request.
+func (t *RoutingTable) Remove(id NodeID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := bucketIndex(t.self, id)
+	if i < 0 {
+		return
+	}
+
+	bk := &t.buckets[i]
+	if el, ok := bk.contacts.Get(id); ok {
+		t.remove(bk, el)
+	}
+}
+
+/*
+remove drops el from bk and fills its place with the newest replacement the
+limits still allow.
+*/
+func (t *RoutingTable) remove(bk *bucket, el *list.Element) {
+	e := bk.contacts.Remove(el)
+	t.count(&e.Contact, -1)
+
+	for r := bk.replacements.Back(); r != nil; r = r.Prev() {
+		if t.fits(bk, &bk.replacements.Val(r).Contact) {
+			t.push(bk, bk.replacements.Remove(r))
+			return
+		}
+	}
+}
+
+func (t *RoutingTable) push(bk *bucket, e *entry) {
+	bk.contacts.PushBack(e)
+	t.count(&e.Contact, 1)
+}
+
+// admit checks c against the limits, logging and counting rejections.
+func (t *RoutingTable) admit(bk *bucket, c *Contact) bool {
+	return t.admitPrefixes(bk, c, ipPrefixes(c))
+}
+
+// admitPrefixes is admit for only the prefixes ps of c.
+func (t *RoutingTable) admitPrefixes(bk *bucket, c *Contact, ps []netip.Prefix) bool {
+	for _, p := range ps {
+		if t.limits.Bucket > 0 && t.inBucket(bk, p) >= t.limits.Bucket {
+			t.bucketRejects.Add(1)
+			t.logger.Info("rejecting contact, bucket prefix limit", "addr", c.addr(), "prefix", p)
+			return false
+		}
+
+		if t.limits.Table > 0 && t.prefixes[p] >= t.limits.Table {
+			t.tableRejects.Add(1)
+			t.logger.Info("rejecting contact, table prefix limit", "addr", c.addr(), "prefix", p)
+			return false
+		}
+	}
+
+	return true
+}
+
+// fits is admit without the side effects.
+func (t *RoutingTable) fits(bk *bucket, c *Contact) bool {
+	for _, p := range ipPrefixes(c) {
+		if t.limits.Bucket > 0 && t.inBucket(bk, p) >= t.limits.Bucket ||
+			t.limits.Table > 0 && t.prefixes[p] >= t.limits.Table {
+			return false
+		}
+	}
+
+	return true
+}
+
+// inBucket counts the contacts of bk in p.
+func (t *RoutingTable) inBucket(bk *bucket, p netip.Prefix) int {
+	n := 0
+	for el := bk.contacts.Front(); el != nil; el = el.Next() {
+		if slices.Contains(ipPrefixes(&bk.contacts.Val(el).Contact), p) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (t *RoutingTable) count(c *Contact, n int) {
+	for _, p := range ipPrefixes(c) {
+		t.prefixes[p] += n
+		if t.prefixes[p] <= 0 {
+			delete(t.prefixes, p)
+		}
+	}
+}
+
+func (t *RoutingTable) Stats() Stats {
+	return Stats{
+		BucketRejects: t.bucketRejects.Load(),
+		TableRejects:  t.tableRejects.Load(),
+	}
+}
+
+// ipPrefixes returns the /24 and /48 of c's addresses that are limited.
+func ipPrefixes(c *Contact) []netip.Prefix {
+	var out []netip.Prefix
+
+	for _, ap := range []netip.AddrPort{c.V4, c.V6} {
+		a := ap.Addr().Unmap()
+		if !a.IsValid() || a.IsLoopback() || a.IsUnspecified() {
+			continue
+		}
+
+		bits := 48
+		if a.Is4() {
+			bits = 24
+		}
+
+		p, err := a.Prefix(bits)
+		if err == nil {
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
+
+// ClosestContacts returns up to n contacts ordered by distance to target.
+func (t *RoutingTable) ClosestContacts(target NodeID, n int) []Contact {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var out []Contact
+
+	for i := range t.buckets {
+		bk := &t.buckets[i]
+		for el := bk.contacts.Front(); el != nil; el = el.Next() {
+			out = append(out, bk.contacts.Val(el).Contact)
+		}
+	}
+
+	sortByDistance(out, target)
+
+	return out[:min(n, len(out))]
+}
+
+// Touch marks the bucket of target as just looked up.
+func (t *RoutingTable) Touch(target NodeID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := bucketIndex(t.self, target)
+	if i < 0 {
+		return
+	}
+
+	t.buckets[i].lastLookup = t.clock.Now()
+}
+
+/*
+Stale returns the buckets that had no lookup for age. Buckets past the
+deepest one holding contacts are left out, they cover IDs so close to ours
+that no other node is likely to be in them.
+*/
+func (t *RoutingTable) Stale(age time.Duration) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	deepest := -1
+	for i := range t.buckets {
+		if t.buckets[i].contacts.Len() > 0 {
+			deepest = i
+		}
+	}
+
+	now := t.clock.Now()
+
+	var out []int
+	for i := range deepest + 1 {
+		if now.Sub(t.buckets[i].lastLookup) >= age {
+			out = append(out, i)
+		}
+	}
+
+	return out
+}
+
+// entries copies the contacts of every bucket, oldest first.
+func (t *RoutingTable) entries() []entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var out []entry
+
+	for i := range t.buckets {
+		bk := &t.buckets[i]
+		for el := bk.contacts.Front(); el != nil; el = el.Next() {
+			out = append(out, *bk.contacts.Val(el))
+		}
+	}
+
+	return out
+}
+
+/*
+restore adds es to the end of their buckets as they are, without pinging,
+where they fit.
+*/
+func (t *RoutingTable) restore(es []entry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, e := range es {
+		i := bucketIndex(t.self, e.Id)
+		if i < 0 {
+			continue
+		}
+
+		bk := &t.buckets[i]
+		if _, ok := bk.contacts.Get(e.Id); ok || bk.contacts.Len() >= k {
+			continue
+		}
+
+		if t.admit(bk, &e.Contact) {
+			t.push(bk, &e)
+		}
+	}
+}
+
+func (t *RoutingTable) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for i := range t.buckets {
+		n += t.buckets[i].contacts.Len()
+	}
+
+	return n
+}
+
+// bucketIndex is the length of the common prefix of a and b, -1 if a == b.
+func bucketIndex(a, b NodeID) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+
+	return -1
+}
+
+func sortByDistance(cs []Contact, target NodeID) {
+	slices.SortFunc(cs, func(a, b Contact) int {
+		return target.Xor(a.Id).Cmp(target.Xor(b.Id))
+	})
+}
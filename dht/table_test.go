@@ -0,0 +1,209 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+)
+
+type fakePinger struct {
+	dead  map[NodeID]bool
+	pings []NodeID
+}
+
+func (p *fakePinger) Ping(_ context.Context, c Contact) error {
+	p.pings = append(p.pings, c.Id)
+	if p.dead[c.Id] {
+		return errors.New("timeout")
+	}
+	return nil
+}
+
+// idInBucket returns an ID that lands in bucket i of a table for the zero ID.
+func idInBucket(i int, n byte) NodeID {
+	var id NodeID
+	id[i/8] = 0x80 >> (i % 8)
+	id[31] |= n
+	return id
+}
+
+func newTestTable() (*RoutingTable, *fakePinger) {
+	p := &fakePinger{dead: make(map[NodeID]bool)}
+	clk := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	return NewRoutingTable(NodeID{}, p, clk, slog.New(slog.DiscardHandler), Limits{}), p
+}
+
+func TestBucketIndex(t *testing.T) {
+	var a NodeID
+
+	if bucketIndex(a, a) != -1 {
+		t.Fatal("own id has no bucket")
+	}
+
+	for i := range b {
+		if got := bucketIndex(a, idInBucket(i, 0)); got != i {
+			t.Fatalf("expected bucket %d. got %d", i, got)
+		}
+	}
+}
+
+func TestRoutingTableFullBucket(t *testing.T) {
+	sut, p := newTestTable()
+	ctx := context.Background()
+
+	// bucket 250 has room for 64 ids so it can fill up.
+	for i := range k {
+		sut.Seen(ctx, Contact{Id: idInBucket(250, byte(i))})
+	}
+
+	if sut.Len() != k || len(p.pings) != 0 {
+		t.Fatalf("should fill bucket without pings. len %d", sut.Len())
+	}
+
+	// oldest answers so the newcomer waits.
+	sut.Seen(ctx, Contact{Id: idInBucket(250, byte(k))})
+
+	if len(p.pings) != 1 || p.pings[0] != idInBucket(250, 0) {
+		t.Fatalf("should ping least recently seen. pinged %v", p.pings)
+	}
+
+	closest := sut.ClosestContacts(idInBucket(250, byte(k)), 100)
+	for _, c := range closest {
+		if c.Id == idInBucket(250, byte(k)) {
+			t.Fatal("newcomer should not get in while oldest answers")
+		}
+	}
+
+	// the ping moved contact 0 to the back so 1 is the oldest now.
+	p.dead[idInBucket(250, 1)] = true
+	sut.Seen(ctx, Contact{Id: idInBucket(250, byte(k+1))})
+
+	if p.pings[1] != idInBucket(250, 1) {
+		t.Fatalf("should ping next oldest. pinged %v", p.pings)
+	}
+
+	ids := map[NodeID]bool{}
+	for _, c := range sut.ClosestContacts(NodeID{}, 100) {
+		ids[c.Id] = true
+	}
+
+	if ids[idInBucket(250, 1)] || !ids[idInBucket(250, byte(k+1))] {
+		t.Fatal("dead contact should be replaced by most recent replacement")
+	}
+
+	// removing a contact promotes the other waiting replacement.
+	sut.Remove(idInBucket(250, 2))
+
+	ids = map[NodeID]bool{}
+	for _, c := range sut.ClosestContacts(NodeID{}, 100) {
+		ids[c.Id] = true
+	}
+
+	if ids[idInBucket(250, 2)] || !ids[idInBucket(250, byte(k))] || sut.Len() != k {
+		t.Fatal("remove should promote from the replacement cache")
+	}
+}
+
+func TestClosestContacts(t *testing.T) {
+	sut, _ := newTestTable()
+
+	for i := range b {
+		sut.Seen(context.Background(), Contact{Id: idInBucket(i, 0)})
+	}
+
+	target := idInBucket(100, 0)
+	got := sut.ClosestContacts(target, 3)
+
+	if len(got) != 3 || got[0].Id != target {
+		t.Fatalf("closest to an id in the table is itself. got %v", got)
+	}
+
+	for i := 1; i < len(got); i++ {
+		if target.Xor(got[i-1].Id).Cmp(target.Xor(got[i].Id)) > 0 {
+			t.Fatal("contacts should be sorted by distance")
+		}
+	}
+
+	if len(sut.ClosestContacts(target, 1000)) != b {
+		t.Fatal("should return all contacts when asking for more")
+	}
+}
+
+func TestPrefixLimits(t *testing.T) {
+	sut, _ := newTestTable()
+	ctx := context.Background()
+
+	at := func(id NodeID, ip string) Contact {
+		c := Contact{Id: id}
+		a := netip.AddrPortFrom(netip.MustParseAddr(ip), 1)
+		if a.Addr().Is4() {
+			c.V4 = a
+		} else {
+			c.V6 = a
+		}
+		return c
+	}
+
+	// the same /24 twice fills the bucket's share.
+	sut.Seen(ctx, at(idInBucket(250, 1), "10.0.0.1"))
+	sut.Seen(ctx, at(idInBucket(250, 2), "10.0.0.2"))
+	sut.Seen(ctx, at(idInBucket(250, 3), "10.0.0.3"))
+	sut.Seen(ctx, at(idInBucket(250, 4), "10.0.1.1"))
+
+	if sut.Len() != 3 || sut.Stats().BucketRejects != 1 {
+		t.Fatalf("third contact from a /24 should be rejected. %d %+v", sut.Len(), sut.Stats())
+	}
+
+	// spread over buckets the table wide limit applies.
+	for i := range 20 {
+		sut.Seen(ctx, at(idInBucket(i, 0), "2001:db8:1:2::"+strconv.Itoa(i+1)))
+	}
+
+	if sut.Len() != 3+DefaultTableLimit || sut.Stats().TableRejects != 20-DefaultTableLimit {
+		t.Fatalf("table should take %d contacts from a /48. %d %+v",
+			DefaultTableLimit, sut.Len(), sut.Stats())
+	}
+
+	// loopback isn't limited.
+	for i := range 5 {
+		sut.Seen(ctx, at(idInBucket(200, byte(i)), "127.0.0.1"))
+	}
+
+	if sut.Len() != 3+DefaultTableLimit+5 {
+		t.Fatal("loopback contacts should not be limited")
+	}
+
+	// a slot freed by remove can be taken again.
+	sut.Remove(idInBucket(250, 1))
+	sut.Seen(ctx, at(idInBucket(250, 5), "10.0.0.5"))
+
+	if sut.Len() != 3+DefaultTableLimit+5 {
+		t.Fatal("removing a contact should free its prefix slot")
+	}
+
+	// a known contact can't move into a full network.
+	sut.Seen(ctx, at(idInBucket(250, 4), "10.0.0.6"))
+
+	if sut.Stats().BucketRejects != 2 {
+		t.Fatalf("moving into a full /24 should be rejected. %+v", sut.Stats())
+	}
+
+	for _, c := range sut.ClosestContacts(idInBucket(250, 4), 1) {
+		if c.V4.Addr() != netip.MustParseAddr("10.0.1.1") {
+			t.Fatalf("rejected move should keep the old address. got %s", c.V4)
+		}
+	}
+
+	// while moving within its own network is fine.
+	sut.Seen(ctx, at(idInBucket(250, 4), "10.0.1.2"))
+
+	if sut.Stats().BucketRejects != 2 {
+		t.Fatalf("moving within a /24 should be accepted. %+v", sut.Stats())
+	}
+}
@@ -0,0 +1,343 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LibSEA/mixnet/session"
+	"github.com/flynn/noise"
+)
+
+// Transport sends single DHT requests to other nodes.
+type Transport interface {
+	Ping(ctx context.Context, to Contact) error
+	Store(ctx context.Context, to Contact, key NodeID, value []byte) error
+	FindNode(ctx context.Context, to Contact, target NodeID) ([]Contact, error)
+	// FindValue returns the value if to has it, else the closest contacts
+	// to key it knows.
+	FindValue(ctx context.Context, to Contact, key NodeID) ([]byte, []Contact, error)
+	// FindRecords returns the records to stores with salt whose key shares
+	// the first bits bits with target.
+	FindRecords(
+		ctx context.Context,
+		to Contact,
+		target NodeID,
+		bits int,
+		salt []byte,
+	) ([][]byte, error)
+}
+
+var errConnClosed = errors.New("connection closed")
+
+/*
+SessionTransport carries requests over Noise sessions, so all DHT traffic is
+encrypted and authenticated. It keeps one session per node and sends many
+requests over it at once, matching responses to requests by id.
+*/
+type SessionTransport struct {
+	self   func() Contact
+	cs     noise.CipherSuite
+	kp     noise.DHKey
+	logger *slog.Logger
+
+	nextID atomic.Uint64
+
+	mu    sync.Mutex
+	conns map[string]*rpcConn
+}
+
+type rpcConn struct {
+	addr string
+	s    *session.Session
+
+	wmu  sync.Mutex
+	wbuf []byte
+
+	mu      sync.Mutex
+	pending map[uint64]chan *message
+	closed  bool
+}
+
+/*
+NewSessionTransport makes a transport that tells other nodes it is self.
+self is called for every request as the contact can change.
+*/
+func NewSessionTransport(
+	self func() Contact,
+	cs noise.CipherSuite,
+	kp noise.DHKey,
+	logger *slog.Logger,
+) *SessionTransport {
+	return &SessionTransport{
+		self:   self,
+		cs:     cs,
+		kp:     kp,
+		logger: logger,
+		conns:  make(map[string]*rpcConn),
+	}
+}
+
+func (t *SessionTransport) Ping(ctx context.Context, to Contact) error {
+	_, err := t.call(ctx, to, &message{typ: msgPing}, msgPong)
+	return err
+}
+
+func (t *SessionTransport) Store(
+	ctx context.Context,
+	to Contact,
+	key NodeID,
+	value []byte,
+) error {
+	_, err := t.call(ctx, to, &message{typ: msgStore, key: key, value: value}, msgStored)
+	return err
+}
+
+func (t *SessionTransport) FindNode(
+	ctx context.Context,
+	to Contact,
+	target NodeID,
+) ([]Contact, error) {
+	resp, err := t.call(ctx, to, &message{typ: msgFindNode, key: target}, msgNodes)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.contacts, nil
+}
+
+func (t *SessionTransport) FindValue(
+	ctx context.Context,
+	to Contact,
+	key NodeID,
+) ([]byte, []Contact, error) {
+	resp, err := t.call(ctx, to, &message{typ: msgFindValue, key: key}, msgValue, msgNodes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp.value, resp.contacts, nil
+}
+
+func (t *SessionTransport) FindRecords(
+	ctx context.Context,
+	to Contact,
+	target NodeID,
+	bits int,
+	salt []byte,
+) ([][]byte, error) {
+	req := message{typ: msgFindRecords, key: target, bits: byte(bits), salt: salt}
+
+	resp, err := t.call(ctx, to, &req, msgRecords)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.values, nil
+}
+
+func (t *SessionTransport) call(
+	ctx context.Context,
+	to Contact,
+	req *message,
+	want ...msgType,
+) (*message, error) {
+	c, err := t.conn(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	req.id = t.nextID.Add(1)
+	req.from = t.self()
+
+	ch := make(chan *message, 1)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errConnClosed
+	}
+	c.pending[req.id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, req.id)
+		c.mu.Unlock()
+	}()
+
+	c.wmu.Lock()
+	err = c.s.WriteMessage(c.wbuf, req.encode())
+	c.wmu.Unlock()
+	if err != nil {
+		t.drop(c)
+		return nil, fmt.Errorf("failed to send dht request. %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, errConnClosed
+		}
+
+		if resp.typ == msgError {
+			return nil, fmt.Errorf("dht request failed. %s", resp.err)
+		}
+
+		for _, w := range want {
+			if resp.typ == w {
+				return resp, nil
+			}
+		}
+
+		return nil, fmt.Errorf("unexpected dht response type %d", resp.typ)
+	}
+}
+
+func (t *SessionTransport) conn(ctx context.Context, to Contact) (*rpcConn, error) {
+	addr := to.addr()
+
+	t.mu.Lock()
+	c, ok := t.conns[addr]
+	t.mu.Unlock()
+
+	if ok {
+		return c, c.check(to)
+	}
+
+	var d net.Dialer
+
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial dht node. %w", err)
+	}
+
+	if dl, ok := ctx.Deadline(); ok {
+		_ = nc.SetDeadline(dl)
+	}
+
+	c = &rpcConn{
+		addr:    addr,
+		s:       session.New(nc, t.cs, t.kp),
+		wbuf:    make([]byte, math.MaxInt16),
+		pending: make(map[uint64]chan *message),
+	}
+
+	err = c.s.ClientHandshake(c.wbuf)
+	if err != nil {
+		_ = c.s.Close()
+		return nil, fmt.Errorf("dht handshake failed. %w", err)
+	}
+
+	_ = nc.SetDeadline(time.Time{})
+
+	err = c.check(to)
+	if err != nil {
+		_ = c.s.Close()
+		return nil, err
+	}
+
+	t.mu.Lock()
+	if other, ok := t.conns[addr]; ok {
+		t.mu.Unlock()
+		_ = c.s.Close()
+		return other, nil
+	}
+	t.conns[addr] = c
+	t.mu.Unlock()
+
+	go t.read(c)
+
+	return c, nil
+}
+
+// read hands responses on c to the requests waiting for them.
+func (t *SessionTransport) read(c *rpcConn) {
+	defer t.drop(c)
+
+	var buf = make([]byte, math.MaxInt16)
+
+	for {
+		msg, err := c.s.ReadMessage(buf)
+		if err != nil {
+			t.logger.Debug("dht connection closed", "addr", c.addr, "error", err)
+			return
+		}
+
+		resp, err := decodeMessage(msg)
+		if err != nil {
+			t.logger.Warn("bad dht response", "addr", c.addr, "error", err)
+			return
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[resp.id]
+		delete(c.pending, resp.id)
+		c.mu.Unlock()
+
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+func (t *SessionTransport) drop(c *rpcConn) {
+	t.mu.Lock()
+	if t.conns[c.addr] == c {
+		delete(t.conns, c.addr)
+	}
+	t.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	c.closed = true
+	_ = c.s.Close()
+
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// Close closes all sessions.
+func (t *SessionTransport) Close() error {
+	t.mu.Lock()
+	conns := make([]*rpcConn, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mu.Unlock()
+
+	for _, c := range conns {
+		t.drop(c)
+	}
+
+	return nil
+}
+
+// check makes sure c is a session with the node to claims to be.
+func (c *rpcConn) check(to Contact) error {
+	if !bytes.Equal(c.s.PeerStatic(), to.PublicKey[:]) {
+		return fmt.Errorf("dht node at %s has a different key", c.addr)
+	}
+
+	return nil
+}
+
+func (c *Contact) addr() string {
+	return c.Addr().String()
+}
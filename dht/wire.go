@@ -0,0 +1,301 @@
+package dht
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+/*
+Every DHT message, request or response, is
+
+	version (1) | type (1) | request id (8) | sender contact | body
+
+Responses carry the id of the request they answer. The body depends on the
+type:
+
+	store         key (32) | value length (2) | value
+	find node     target (32)
+	find value    key (32)
+	nodes         count (1) | contacts
+	value         value length (2) | value
+	error         message length (2) | message
+	find records  target (32) | prefix bits (1) | salt length (2) | salt
+	records       count (1) | [value length (2) | value]...
+
+ping, pong and stored have no body. A contact is
+
+	public key (32) | nonce (8) | flags (1) | [ipv4 (4) | port (2)]
+	| [ipv6 (16) | port (2)]
+
+where flag 0x1 means the IPv4 address is there and 0x2 the IPv6 one.
+The receiver derives the contact's id from its key and nonce.
+*/
+const wireVersion = 0x1
+
+// MaxValueSize is the largest value the DHT stores.
+const MaxValueSize = 8192
+
+type msgType byte
+
+const (
+	msgPing msgType = iota + 1
+	msgPong
+	msgStore
+	msgStored
+	msgFindNode
+	msgNodes
+	msgFindValue
+	msgValue
+	msgError
+	msgFindRecords
+	msgRecords
+)
+
+// maxRecordsSize bounds the values in one records response.
+const maxRecordsSize = 16384
+
+var errShortMessage = errors.New("message too short")
+
+type message struct {
+	typ  msgType
+	id   uint64
+	from Contact
+	// key is the key of store and find value, or the target of find node.
+	key      NodeID
+	value    []byte
+	contacts []Contact
+	err      string
+	// bits and salt select the records of find records.
+	bits   byte
+	salt   []byte
+	values [][]byte
+}
+
+func (m *message) encode() []byte {
+	out := make([]byte, 0, 64+len(m.value)+len(m.contacts)*65+len(m.salt))
+
+	out = append(out, wireVersion, byte(m.typ))
+	out = binary.BigEndian.AppendUint64(out, m.id)
+	out = appendContact(out, &m.from)
+
+	switch m.typ {
+	case msgStore:
+		out = append(out, m.key[:]...)
+		out = appendBytes(out, m.value)
+	case msgFindNode, msgFindValue:
+		out = append(out, m.key[:]...)
+	case msgNodes:
+		out = append(out, byte(len(m.contacts)))
+		for i := range m.contacts {
+			out = appendContact(out, &m.contacts[i])
+		}
+	case msgValue:
+		out = appendBytes(out, m.value)
+	case msgError:
+		out = appendBytes(out, []byte(m.err))
+	case msgFindRecords:
+		out = append(out, m.key[:]...)
+		out = append(out, m.bits)
+		out = appendBytes(out, m.salt)
+	case msgRecords:
+		out = append(out, byte(len(m.values)))
+		for _, v := range m.values {
+			out = appendBytes(out, v)
+		}
+	}
+
+	return out
+}
+
+func decodeMessage(b []byte) (*message, error) {
+	var m message
+	var d = decoder{b: b}
+
+	if v := d.byte(); d.err == nil && v != wireVersion {
+		return nil, fmt.Errorf("unsupported dht message version %d", v)
+	}
+
+	m.typ = msgType(d.byte())
+	m.id = d.uint64()
+	m.from = d.contact()
+
+	switch m.typ {
+	case msgPing, msgPong, msgStored:
+	case msgStore:
+		m.key = d.id()
+		m.value = d.lenBytes()
+	case msgFindNode, msgFindValue:
+		m.key = d.id()
+	case msgNodes:
+		n := int(d.byte())
+		for range n {
+			m.contacts = append(m.contacts, d.contact())
+		}
+	case msgValue:
+		m.value = d.lenBytes()
+	case msgError:
+		m.err = string(d.lenBytes())
+	case msgFindRecords:
+		m.key = d.id()
+		m.bits = d.byte()
+		m.salt = d.lenBytes()
+	case msgRecords:
+		n := int(d.byte())
+		for range n {
+			m.values = append(m.values, d.lenBytes())
+		}
+	default:
+		return nil, fmt.Errorf("unknown dht message type %d", m.typ)
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	if len(d.b) != 0 {
+		return nil, fmt.Errorf("%d trailing bytes in dht message", len(d.b))
+	}
+
+	if len(m.value) > MaxValueSize {
+		return nil, fmt.Errorf("value too large %d", len(m.value))
+	}
+
+	for _, v := range m.values {
+		if len(v) > MaxValueSize {
+			return nil, fmt.Errorf("value too large %d", len(v))
+		}
+	}
+
+	return &m, nil
+}
+
+func appendBytes(out []byte, b []byte) []byte {
+	out = binary.BigEndian.AppendUint16(out, uint16(len(b)))
+	return append(out, b...)
+}
+
+const (
+	hasV4 = 1 << iota
+	hasV6
+)
+
+func appendContact(out []byte, c *Contact) []byte {
+	// an address in the wrong family is left out.
+	v4 := c.V4.IsValid() && c.V4.Addr().Is4()
+	v6 := c.V6.IsValid() && c.V6.Addr().Is6()
+
+	var flags byte
+	if v4 {
+		flags |= hasV4
+	}
+	if v6 {
+		flags |= hasV6
+	}
+
+	out = append(out, c.PublicKey[:]...)
+	out = binary.BigEndian.AppendUint64(out, c.Nonce)
+	out = append(out, flags)
+
+	if v4 {
+		a := c.V4.Addr().As4()
+		out = append(out, a[:]...)
+		out = binary.BigEndian.AppendUint16(out, c.V4.Port())
+	}
+
+	if v6 {
+		a := c.V6.Addr().As16()
+		out = append(out, a[:]...)
+		out = binary.BigEndian.AppendUint16(out, c.V6.Port())
+	}
+
+	return out
+}
+
+// decoder reads fields until one is short, then only records the error.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if len(d.b) < n {
+		d.err = errShortMessage
+		return nil
+	}
+
+	out := d.b[:n]
+	d.b = d.b[n:]
+
+	return out
+}
+
+func (d *decoder) byte() byte {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+
+	return b[0]
+}
+
+func (d *decoder) uint16() uint16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint16(b)
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *decoder) id() NodeID {
+	var id NodeID
+	copy(id[:], d.next(len(id)))
+
+	return id
+}
+
+func (d *decoder) lenBytes() []byte {
+	return d.next(int(d.uint16()))
+}
+
+func (d *decoder) contact() Contact {
+	var c Contact
+
+	copy(c.PublicKey[:], d.next(len(c.PublicKey)))
+	c.Nonce = d.uint64()
+	c.Id = NewNodeID(c.PublicKey, c.Nonce)
+
+	flags := d.byte()
+	if d.err == nil && flags&^(hasV4|hasV6) != 0 {
+		d.err = fmt.Errorf("bad contact flags %#x", flags)
+	}
+
+	if flags&hasV4 != 0 {
+		if b := d.next(4); b != nil {
+			c.V4 = netip.AddrPortFrom(netip.AddrFrom4([4]byte(b)), d.uint16())
+		}
+	}
+
+	if flags&hasV6 != 0 {
+		if b := d.next(16); b != nil {
+			c.V6 = netip.AddrPortFrom(netip.AddrFrom16([16]byte(b)), d.uint16())
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,146 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package dhtctl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/LibSEA/mixnet/dht"
+)
+
+/*
+The admin socket lets the other commands look into a running serve, whose
+database is locked while it runs. It is a unix socket only the user running
+serve can open. A request is one line naming what to get, and the answer is
+one JSON value, or an object with just an error.
+*/
+const (
+	adminTable = "table"
+
+	adminTimeout = 10 * time.Second
+)
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+/*
+listenAdmin listens on the unix socket path. A socket left behind by a serve
+that didn't stop cleanly is removed first, serve holding the database lock
+means it isn't in use.
+*/
+func listenAdmin(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on admin socket. %w", err)
+	}
+
+	err = os.Chmod(path, 0o600)
+	if err != nil {
+		_ = ln.Close()
+		return nil, fmt.Errorf("failed to restrict admin socket. %w", err)
+	}
+
+	return ln, nil
+}
+
+// serveAdmin answers admin requests about d until ln is closed.
+func serveAdmin(ln net.Listener, d *dht.DHT, log *slog.Logger) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go handleAdmin(conn, d, log)
+	}
+}
+
+func handleAdmin(conn net.Conn, d *dht.DHT, log *slog.Logger) {
+	defer func() { _ = conn.Close() }()
+
+	_ = conn.SetDeadline(time.Now().Add(adminTimeout))
+
+	req, err := bufio.NewReader(io.LimitReader(conn, 64)).ReadString('\n')
+	if err != nil {
+		log.Warn("bad admin request", "error", err)
+		return
+	}
+
+	var res any
+
+	switch req = strings.TrimSpace(req); req {
+	case adminTable:
+		res = newTable(d.Self.Id, d.RoutingTable.Entries(), time.Now())
+	default:
+		res = adminError{Error: fmt.Sprintf("unknown request %q", req)}
+	}
+
+	err = json.NewEncoder(conn).Encode(res)
+	if err != nil {
+		log.Warn("failed to answer admin request", "error", err)
+	}
+}
+
+// admin sends req to the serve listening on path and decodes its answer to v.
+func admin(ctx context.Context, path, req string, v any) error {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to reach serve, is it running? %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	_, err = io.WriteString(conn, req+"\n")
+	if err != nil {
+		return fmt.Errorf("failed to send admin request. %w", err)
+	}
+
+	var raw json.RawMessage
+
+	err = json.NewDecoder(conn).Decode(&raw)
+	if err != nil {
+		return fmt.Errorf("failed to read admin answer. %w", err)
+	}
+
+	var e adminError
+	if json.Unmarshal(raw, &e) == nil && e.Error != "" {
+		return errors.New(e.Error)
+	}
+
+	return json.Unmarshal(raw, v)
+}
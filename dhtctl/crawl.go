@@ -0,0 +1,230 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package dhtctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LibSEA/mixnet/dht"
+)
+
+// crawlBits is how many of its buckets the crawler asks each node about.
+const crawlBits = 16
+
+type CrawlOptions struct {
+	Options
+	// Max stops the crawl after this many nodes.
+	Max int
+	// Parallel is how many nodes are asked at once.
+	Parallel int
+	// QueryTimeout limits each request.
+	QueryTimeout time.Duration
+}
+
+type crawlJSON struct {
+	Found      int `json:"found"`
+	Responsive int `json:"responsive"`
+	IPv4       int `json:"ipv4"`
+	IPv6       int `json:"ipv6"`
+	// Prefixes counts responsive nodes by the first 4 bits of their ID.
+	Prefixes [16]int `json:"prefixes"`
+	// ChiSquare of Prefixes against an even spread, 15 degrees of freedom.
+	ChiSquare float64       `json:"chi_square"`
+	Nodes     []contactJSON `json:"nodes,omitempty"`
+}
+
+type crawler struct {
+	opts      CrawlOptions
+	transport dht.Transport
+	log       *slog.Logger
+
+	mu     sync.Mutex
+	seen   map[dht.NodeID]bool
+	alive  []dht.Contact
+	queue  chan dht.Contact
+	active sync.WaitGroup
+}
+
+/*
+Crawl visits every node it can reach from the seeds. It asks each node for
+the contacts near IDs that differ from the node's own in one of the first
+crawlBits bits, which returns the node's buckets for those distances, and
+reports the size of the network and how evenly the IDs are spread.
+*/
+func Crawl(opts CrawlOptions) int {
+	log := logger()
+
+	ctx, cancel := opts.context()
+	defer cancel()
+
+	c, err := opts.client(ctx, log)
+	if err != nil {
+		log.Error("failed to join the dht", "error", err)
+		return 1
+	}
+	defer func() { _ = c.Close() }()
+
+	start := c.dht.RoutingTable.ClosestContacts(c.dht.Self.Id, c.dht.RoutingTable.Len())
+
+	res := crawl(ctx, c.transport, start, opts, log)
+
+	opts.print(res, func(w io.Writer) {
+		fmt.Fprintf(w, "found %d nodes, %d responsive (%d ipv4, %d ipv6)\n",
+			res.Found, res.Responsive, res.IPv4, res.IPv6)
+		fmt.Fprintf(w, "ids by first 4 bits, chi square %.1f (15 df, 25.0 is p=0.05):\n", res.ChiSquare)
+
+		top := 1
+		for _, n := range res.Prefixes {
+			top = max(top, n)
+		}
+
+		for i, n := range res.Prefixes {
+			bar := strings.Repeat("#", n*40/top)
+			fmt.Fprintf(w, "  %x  %5d  %s\n", i, n, bar)
+		}
+	})
+
+	return 0
+}
+
+func crawl(
+	ctx context.Context,
+	t dht.Transport,
+	start []dht.Contact,
+	opts CrawlOptions,
+	log *slog.Logger,
+) crawlJSON {
+	if opts.Max <= 0 {
+		opts.Max = 10000
+	}
+
+	if opts.Parallel <= 0 {
+		opts.Parallel = 16
+	}
+
+	if opts.QueryTimeout <= 0 {
+		opts.QueryTimeout = 5 * time.Second
+	}
+
+	cr := crawler{
+		opts:      opts,
+		transport: t,
+		log:       log,
+		seen:      make(map[dht.NodeID]bool),
+		// add never queues more than Max, so it never blocks.
+		queue: make(chan dht.Contact, opts.Max),
+	}
+
+	cr.add(start...)
+
+	var workers sync.WaitGroup
+	for range cr.opts.Parallel {
+		workers.Go(func() {
+			for c := range cr.queue {
+				cr.visit(ctx, c)
+				cr.active.Done()
+			}
+		})
+	}
+
+	cr.active.Wait()
+	close(cr.queue)
+	workers.Wait()
+
+	res := crawlJSON{Found: len(cr.seen), Responsive: len(cr.alive)}
+
+	for _, c := range cr.alive {
+		if c.V4.IsValid() {
+			res.IPv4++
+		}
+		if c.V6.IsValid() {
+			res.IPv6++
+		}
+
+		res.Prefixes[c.Id[0]>>4]++
+		res.Nodes = append(res.Nodes, contactJSON{ID: hexID(c.Id), Contact: c.String()})
+	}
+
+	if len(cr.alive) > 0 {
+		want := float64(len(cr.alive)) / float64(len(res.Prefixes))
+		for _, n := range res.Prefixes {
+			d := float64(n) - want
+			res.ChiSquare += d * d / want
+		}
+	}
+
+	return res
+}
+
+// add queues the contacts not seen before, up to Max.
+func (cr *crawler) add(cs ...dht.Contact) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	for _, c := range cs {
+		if cr.seen[c.Id] || len(cr.seen) >= cr.opts.Max {
+			continue
+		}
+
+		cr.seen[c.Id] = true
+		cr.active.Add(1)
+		cr.queue <- c
+	}
+}
+
+func (cr *crawler) visit(ctx context.Context, c dht.Contact) {
+	answered := false
+
+	for i := range crawlBits {
+		target := c.Id
+		target[i/8] ^= 0x80 >> (i % 8)
+
+		qctx, cancel := context.WithTimeout(ctx, cr.opts.QueryTimeout)
+		found, err := cr.transport.FindNode(qctx, c, target)
+		cancel()
+
+		if err != nil {
+			cr.log.Debug("crawl request failed", "contact", c.String(), "error", err)
+			if !answered {
+				return
+			}
+			continue
+		}
+
+		answered = true
+
+		var valid []dht.Contact
+		for _, f := range found {
+			if f.Valid(cr.opts.Difficulty) {
+				valid = append(valid, f)
+			}
+		}
+
+		cr.add(valid...)
+	}
+
+	cr.mu.Lock()
+	cr.alive = append(cr.alive, c)
+	cr.mu.Unlock()
+}
@@ -0,0 +1,156 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+/*
+Package dhtctl has the operator commands behind mixnet dht: running a node,
+looking up and storing records, and looking at the routing table and the
+network.
+*/
+package dhtctl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/dht"
+	"github.com/LibSEA/mixnet/store"
+	"github.com/flynn/noise"
+)
+
+// Options are shared by every dht command.
+type Options struct {
+	// JSON prints results as JSON instead of text.
+	JSON bool
+	// Seeds are contacts written as ip:port/hex-key to join through.
+	Seeds []string
+	// Difficulty is the ID puzzle difficulty of the network.
+	Difficulty int
+	// Timeout limits the whole command, except serve.
+	Timeout time.Duration
+}
+
+var out io.Writer = os.Stdout
+
+func logger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelWarn,
+	}))
+}
+
+func (o *Options) seeds() ([]dht.Contact, error) {
+	var cs []dht.Contact
+
+	for _, s := range o.Seeds {
+		c, err := dht.ParseContact(s, o.Difficulty)
+		if err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+
+	return cs, nil
+}
+
+/*
+client is a node that doesn't listen, so other nodes don't add it to their
+tables, and that only joins for the length of one command.
+*/
+type client struct {
+	dht       *dht.DHT
+	transport *dht.SessionTransport
+}
+
+func (o *Options) client(ctx context.Context, log *slog.Logger) (*client, error) {
+	seeds, err := o.seeds()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(seeds) == 0 {
+		return nil, fmt.Errorf("at least one --seed is needed")
+	}
+
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+	kp, err := cs.GenerateKeypair(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate keypair. %w", err)
+	}
+
+	var c client
+
+	c.transport = dht.NewSessionTransport(
+		func() dht.Contact { return c.dht.Self },
+		cs,
+		kp,
+		log,
+	)
+
+	c.dht, err = dht.New(dht.Options{
+		PrivateKey: kp.Private,
+		PublicKey:  kp.Public,
+		Difficulty: o.Difficulty,
+		Storage:    store.NewMemory(clock.Real{}),
+		Logger:     log,
+		Transport:  c.transport,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.dht.Bootstrap(ctx, seeds)
+	if err != nil {
+		_ = c.transport.Close()
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func (c *client) Close() error {
+	return c.transport.Close()
+}
+
+func (o *Options) context() (context.Context, context.CancelFunc) {
+	if o.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), o.Timeout)
+}
+
+// print writes v as JSON, or calls text when JSON is off.
+func (o *Options) print(v any, text func(w io.Writer)) {
+	if !o.JSON {
+		text(out)
+		return
+	}
+
+	e := json.NewEncoder(out)
+	e.SetIndent("", "  ")
+	_ = e.Encode(v)
+}
+
+func hexID(id dht.NodeID) string {
+	return fmt.Sprintf("%x", id[:])
+}
@@ -0,0 +1,281 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package dhtctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/dht"
+	"github.com/LibSEA/mixnet/keys"
+	"github.com/LibSEA/mixnet/store"
+)
+
+// testNetwork starts n nodes, each knowing only its neighbours.
+func testNetwork(ctx context.Context, t *testing.T, n int) []*dht.DHT {
+	t.Helper()
+
+	var nodes []*dht.DHT
+
+	for range n {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = ln.Close() })
+
+		d, err := dht.New(dht.Options{
+			V4:         ln.Addr().(*net.TCPAddr).AddrPort(),
+			Difficulty: 4,
+			Storage:    store.NewMemory(clock.Real{}),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() { _ = d.Serve(ln) }()
+
+		nodes = append(nodes, d)
+	}
+
+	// a chain, so the crawler has to follow contacts to find everyone.
+	for i := 1; i < len(nodes); i++ {
+		nodes[i].RoutingTable.Seen(ctx, nodes[i-1].Self)
+		nodes[i-1].RoutingTable.Seen(ctx, nodes[i].Self)
+	}
+
+	return nodes
+}
+
+// capture collects what the commands print.
+func capture(t *testing.T) *bytes.Buffer {
+	var b bytes.Buffer
+
+	old := out
+	out = &b
+	t.Cleanup(func() { out = old })
+
+	return &b
+}
+
+func TestCrawl(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	nodes := testNetwork(ctx, t, 10)
+
+	opts := Options{Difficulty: 4, Seeds: []string{nodes[0].Self.String()}}
+
+	c, err := opts.client(ctx, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	res := crawl(ctx, c.transport, []dht.Contact{nodes[0].Self}, CrawlOptions{Options: opts}, slog.New(slog.DiscardHandler))
+
+	if res.Found != len(nodes) || res.Responsive != len(nodes) || res.IPv4 != len(nodes) {
+		t.Fatalf("crawl should find all %d nodes. %+v", len(nodes), res)
+	}
+
+	sum := 0
+	for _, n := range res.Prefixes {
+		sum += n
+	}
+
+	if sum != len(nodes) {
+		t.Fatal("every node should be in the id distribution")
+	}
+
+	// the client isn't listening, so nodes don't keep it.
+	for _, d := range nodes {
+		if d.RoutingTable.Len() > len(nodes)-1 {
+			t.Fatal("client should not be added to routing tables")
+		}
+	}
+
+	res = crawl(ctx, c.transport, []dht.Contact{nodes[0].Self}, CrawlOptions{Options: opts, Max: 3}, slog.New(slog.DiscardHandler))
+	if res.Found != 3 {
+		t.Fatalf("crawl should stop at max. found %d", res.Found)
+	}
+}
+
+func TestPutLookup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	nodes := testNetwork(ctx, t, 5)
+	buf := capture(t)
+
+	dir := t.TempDir()
+	_, err := keys.Generate(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{
+		JSON:       true,
+		Seeds:      []string{nodes[0].Self.String()},
+		Difficulty: 4,
+		Timeout:    10 * time.Second,
+	}
+
+	code := Put(PutOptions{
+		Options:      opts,
+		KeyFile:      filepath.Join(dir, keys.IdentityFile),
+		PassphraseFD: -1,
+		Salt:         "salt",
+		Value:        "hello",
+	})
+	if code != 0 {
+		t.Fatalf("put failed with %d", code)
+	}
+
+	var put recordJSON
+	err = json.Unmarshal(buf.Bytes(), &put)
+	if err != nil || put.Seq == 0 || string(put.Value) != "hello" {
+		t.Fatalf("bad put output %q. %v", buf, err)
+	}
+
+	buf.Reset()
+
+	code = Lookup(LookupOptions{Options: opts, PublicKey: put.PublicKey, Salt: "salt"})
+	if code != 0 {
+		t.Fatalf("lookup failed with %d", code)
+	}
+
+	var found lookupJSON
+	err = json.Unmarshal(buf.Bytes(), &found)
+	if err != nil || !found.Found || found.Record.Key != put.Key || string(found.Record.Value) != "hello" {
+		t.Fatalf("lookup should find the record. %q %v", buf, err)
+	}
+
+	buf.Reset()
+
+	code = Lookup(LookupOptions{Options: opts, PublicKey: put.PublicKey, Salt: "other"})
+	if code != 2 {
+		t.Fatalf("missing record should exit with 2. got %d", code)
+	}
+
+	var missing lookupJSON
+	err = json.Unmarshal(buf.Bytes(), &missing)
+	if err != nil || missing.Found || len(missing.Closest) == 0 {
+		t.Fatalf("missing record should give the closest nodes. %q %v", buf, err)
+	}
+
+	buf.Reset()
+	opts.JSON = false
+
+	code = Lookup(LookupOptions{Options: opts, Key: put.Key})
+	if code != 0 || !strings.Contains(buf.String(), `value       "hello"`) {
+		t.Fatalf("text lookup should show the value. %q", buf)
+	}
+}
+
+func TestTable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	nodes := testNetwork(ctx, t, 3)
+	buf := capture(t)
+
+	path := filepath.Join(t.TempDir(), "dht.sock")
+
+	ln, err := listenAdmin(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	go serveAdmin(ln, nodes[1], slog.New(slog.DiscardHandler))
+
+	opts := TableOptions{Options: Options{JSON: true, Timeout: 10 * time.Second}, Admin: path}
+
+	if code := Table(opts); code != 0 {
+		t.Fatalf("table failed with %d", code)
+	}
+
+	var res tableJSON
+	err = json.Unmarshal(buf.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fill := 0
+	for _, bk := range res.Buckets {
+		fill += bk.Fill
+	}
+
+	if res.ID != hexID(nodes[1].Self.Id) || res.Size != 2 || fill != 2 {
+		t.Fatalf("table should hold both neighbours. %+v", res)
+	}
+
+	buf.Reset()
+	opts.JSON = false
+
+	if code := Table(opts); code != 0 || !strings.HasPrefix(buf.String(), "node "+res.ID+", 2 contacts") {
+		t.Fatalf("bad text table %q", buf)
+	}
+
+	var v tableJSON
+	err = admin(ctx, path, "nope", &v)
+	if err == nil || !strings.Contains(err.Error(), "unknown request") {
+		t.Fatalf("unknown request should fail. got %v", err)
+	}
+
+	// a stopped node's table comes from its database.
+	dir := t.TempDir()
+
+	db, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := dht.New(dht.Options{V4: nodes[0].Self.V4, Difficulty: 4, Storage: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.RoutingTable.Seen(ctx, nodes[2].Self)
+
+	err = d.Save()
+	_ = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	opts = TableOptions{Options: Options{JSON: true}, DB: dir}
+
+	if code := Table(opts); code != 0 {
+		t.Fatalf("saved table failed with %d", code)
+	}
+
+	err = json.Unmarshal(buf.Bytes(), &res)
+	if err != nil || res.ID != hexID(d.Self.Id) || res.Size != 1 {
+		t.Fatalf("saved table should hold one contact. %+v %v", res, err)
+	}
+}
@@ -0,0 +1,224 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package dhtctl
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"github.com/LibSEA/mixnet/dht"
+	"github.com/LibSEA/mixnet/keys"
+)
+
+type LookupOptions struct {
+	Options
+	// Key is the hex record key. When empty it comes from PublicKey and Salt.
+	Key       string
+	PublicKey string
+	Salt      string
+	// Disjoint is the number of disjoint lookup paths.
+	Disjoint int
+}
+
+type recordJSON struct {
+	Key       string `json:"key"`
+	PublicKey string `json:"public_key"`
+	Salt      string `json:"salt"`
+	Seq       uint64 `json:"seq"`
+	Value     []byte `json:"value"`
+}
+
+type contactJSON struct {
+	ID      string `json:"id"`
+	Contact string `json:"contact"`
+}
+
+type lookupJSON struct {
+	Found   bool          `json:"found"`
+	Record  *recordJSON   `json:"record,omitempty"`
+	Closest []contactJSON `json:"closest,omitempty"`
+}
+
+func (o *LookupOptions) key() (dht.NodeID, error) {
+	var key dht.NodeID
+
+	if o.Key != "" {
+		b, err := hex.DecodeString(o.Key)
+		if err != nil || len(b) != len(key) {
+			return key, fmt.Errorf("key should be %d hex bytes", len(key))
+		}
+
+		copy(key[:], b)
+
+		return key, nil
+	}
+
+	pub, err := hex.DecodeString(o.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return key, fmt.Errorf("give a key or a %d byte hex public key", ed25519.PublicKeySize)
+	}
+
+	return dht.RecordKey(pub, []byte(o.Salt)), nil
+}
+
+// Lookup finds the record under a key and prints it, or the closest nodes.
+func Lookup(opts LookupOptions) int {
+	log := logger()
+
+	key, err := opts.key()
+	if err != nil {
+		log.Error("bad key", "error", err)
+		return 1
+	}
+
+	ctx, cancel := opts.context()
+	defer cancel()
+
+	c, err := opts.client(ctx, log)
+	if err != nil {
+		log.Error("failed to join the dht", "error", err)
+		return 1
+	}
+	defer func() { _ = c.Close() }()
+
+	r, closest, err := c.dht.FindValue(ctx, key, dht.Disjoint(max(opts.Disjoint, 1)))
+	if err != nil {
+		log.Error("lookup failed", "error", err)
+		return 1
+	}
+
+	res := lookupJSON{Found: r != nil}
+	if r != nil {
+		res.Record = &recordJSON{
+			Key:       hexID(key),
+			PublicKey: hex.EncodeToString(r.PublicKey),
+			Salt:      string(r.Salt),
+			Seq:       r.Seq,
+			Value:     r.Value,
+		}
+	}
+
+	for _, c := range closest {
+		res.Closest = append(res.Closest, contactJSON{ID: hexID(c.Id), Contact: c.String()})
+	}
+
+	opts.print(res, func(w io.Writer) {
+		if r == nil {
+			fmt.Fprintf(w, "no record under %x, closest nodes:\n", key)
+			for _, c := range res.Closest {
+				fmt.Fprintf(w, "  %s  %s\n", c.ID, c.Contact)
+			}
+			return
+		}
+
+		fmt.Fprintf(w, "key         %s\n", res.Record.Key)
+		fmt.Fprintf(w, "public key  %s\n", res.Record.PublicKey)
+		fmt.Fprintf(w, "salt        %q\n", res.Record.Salt)
+		fmt.Fprintf(w, "seq         %d\n", r.Seq)
+		if utf8.Valid(r.Value) {
+			fmt.Fprintf(w, "value       %q\n", r.Value)
+		} else {
+			fmt.Fprintf(w, "value       %x\n", r.Value)
+		}
+	})
+
+	if r == nil {
+		return 2
+	}
+
+	return 0
+}
+
+type PutOptions struct {
+	Options
+	// KeyFile is a PKCS #8 PEM Ed25519 private key, such as the
+	// identity.key of mixnet keygen or one made by
+	// openssl genpkey -algorithm ed25519.
+	KeyFile string
+	// PassphraseFD is where the passphrase of an encrypted KeyFile is read
+	// from, see keys.Source.
+	PassphraseFD int
+	Salt         string
+	// Seq defaults to the current unix time, so later puts replace
+	// earlier ones.
+	Seq   uint64
+	Value string
+}
+
+// Put signs a record with the key in KeyFile and stores it in the DHT.
+func Put(opts PutOptions) int {
+	log := logger()
+
+	passphrase, err := keys.ReadPassphrase(opts.PassphraseFD, keys.PassphraseEnv)
+	if err != nil {
+		log.Error("failed to read passphrase", "error", err)
+		return 1
+	}
+
+	priv, err := keys.ReadIdentity(opts.KeyFile, passphrase)
+	if err != nil {
+		log.Error("failed to read key", "error", err)
+		return 1
+	}
+
+	seq := opts.Seq
+	if seq == 0 {
+		seq = uint64(time.Now().Unix())
+	}
+
+	r, err := dht.NewRecord(priv, []byte(opts.Salt), seq, []byte(opts.Value))
+	if err != nil {
+		log.Error("failed to sign record", "error", err)
+		return 1
+	}
+
+	ctx, cancel := opts.context()
+	defer cancel()
+
+	c, err := opts.client(ctx, log)
+	if err != nil {
+		log.Error("failed to join the dht", "error", err)
+		return 1
+	}
+	defer func() { _ = c.Close() }()
+
+	err = c.dht.Store(ctx, r)
+	if err != nil {
+		log.Error("store failed", "error", err)
+		return 1
+	}
+
+	key := r.Key()
+	res := recordJSON{
+		Key:       hexID(key),
+		PublicKey: hex.EncodeToString(r.PublicKey),
+		Salt:      opts.Salt,
+		Seq:       seq,
+		Value:     r.Value,
+	}
+
+	opts.print(res, func(w io.Writer) {
+		fmt.Fprintf(w, "stored %s seq %d\n", res.Key, seq)
+	})
+
+	return 0
+}
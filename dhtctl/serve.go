@@ -0,0 +1,153 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package dhtctl
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/dht"
+	"github.com/LibSEA/mixnet/keys"
+	"github.com/LibSEA/mixnet/store"
+)
+
+type ServeOptions struct {
+	Options
+	Host string
+	Port uint16
+	// DB is the database directory holding records and the routing table.
+	DB string
+	// Admin is the unix socket table and other local commands ask the
+	// running node on. Empty turns it off.
+	Admin string
+	// Keys is where the keys are loaded from, see the keys package.
+	Keys keys.Source
+}
+
+/*
+Serve runs a DHT node until it gets SIGINT or SIGTERM. It rejoins through
+the routing table saved in DB, or the seeds, and saves the table again when
+it stops. The node ID comes from the DHT key in the key directory, which is
+made on the first start and kept across key rotations.
+*/
+func Serve(opts ServeOptions) int {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(opts.Host, strconv.Itoa(int(opts.Port))))
+	if err != nil {
+		logger.Error("couldn't listen", "port", opts.Port, "host", opts.Host, "error", err)
+		return 1
+	}
+	defer func() { _ = ln.Close() }()
+
+	db, err := store.Open(opts.DB)
+	if err != nil {
+		logger.Error("failed to open database", "error", err)
+		return 1
+	}
+	defer func() { _ = db.Close() }()
+
+	seeds, err := opts.seeds()
+	if err != nil {
+		logger.Error("bad seed", "error", err)
+		return 1
+	}
+
+	k, err := opts.Keys.Load()
+	if err != nil {
+		logger.Error("couldn't load keys", "error", err)
+		return 1
+	}
+
+	// not the link key, rotating it would make the node a new one.
+	kp, err := k.DHT()
+	if err != nil {
+		logger.Error("couldn't load dht key", "error", err)
+		return 1
+	}
+
+	o := dht.Options{
+		PrivateKey: kp.Private,
+		PublicKey:  kp.Public,
+		Difficulty: opts.Difficulty,
+		Storage:    db,
+		Logger:     logger,
+		Clock:      clock.Real{},
+		Seeds:      seeds,
+	}
+
+	a := ln.Addr().(*net.TCPAddr).AddrPort()
+	if a.Addr().Unmap().Is4() {
+		o.V4 = netip.AddrPortFrom(a.Addr().Unmap(), a.Port())
+	} else {
+		o.V6 = a
+	}
+
+	d, err := dht.New(o)
+	if err != nil {
+		logger.Error("failed to create dht node", "error", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	if opts.Admin != "" {
+		aln, err := listenAdmin(opts.Admin)
+		if err != nil {
+			logger.Error("couldn't open admin socket", "error", err)
+			return 1
+		}
+		defer func() { _ = aln.Close() }()
+
+		go serveAdmin(aln, d, logger)
+	}
+
+	go func() {
+		err := d.Serve(ln)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("serve failed", "error", err)
+			stop()
+		}
+	}()
+
+	logger.Info("started", "contact", d.Self.String(), "id", hexID(d.Self.Id))
+
+	err = d.Join(ctx)
+	if err != nil {
+		logger.Warn("failed to join, waiting for other nodes", "error", err)
+	}
+
+	d.Maintain(ctx)
+
+	logger.Info("stopped", "contacts", d.RoutingTable.Len())
+
+	return 0
+}
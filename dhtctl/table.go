@@ -0,0 +1,134 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package dhtctl
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/LibSEA/mixnet/dht"
+	"github.com/LibSEA/mixnet/store"
+)
+
+type TableOptions struct {
+	Options
+	// Admin is the admin socket of a running serve.
+	Admin string
+	// DB is the database directory of a stopped serve. When set the table
+	// it saved is shown instead of asking Admin.
+	DB string
+}
+
+type bucketJSON struct {
+	Index    int         `json:"index"`
+	Fill     int         `json:"fill"`
+	Contacts []entryJSON `json:"contacts"`
+}
+
+type entryJSON struct {
+	ID       string  `json:"id"`
+	Contact  string  `json:"contact"`
+	LastSeen string  `json:"last_seen"`
+	Age      float64 `json:"age_seconds"`
+}
+
+type tableJSON struct {
+	ID      string       `json:"id"`
+	Size    int          `json:"size"`
+	Buckets []bucketJSON `json:"buckets"`
+}
+
+func newTable(self dht.NodeID, entries []dht.TableEntry, now time.Time) tableJSON {
+	res := tableJSON{ID: hexID(self), Size: len(entries)}
+
+	for _, e := range entries {
+		if n := len(res.Buckets); n == 0 || res.Buckets[n-1].Index != e.Bucket {
+			res.Buckets = append(res.Buckets, bucketJSON{Index: e.Bucket})
+		}
+
+		bk := &res.Buckets[len(res.Buckets)-1]
+		bk.Fill++
+		bk.Contacts = append(bk.Contacts, entryJSON{
+			ID:       hexID(e.Id),
+			Contact:  e.String(),
+			LastSeen: e.LastSeen.UTC().Format(time.RFC3339),
+			Age:      now.Sub(e.LastSeen).Seconds(),
+		})
+	}
+
+	return res
+}
+
+// saved reads the table a stopped serve saved in db.
+func saved(db string) (tableJSON, error) {
+	s, err := store.Open(db)
+	if err != nil {
+		return tableJSON{}, err
+	}
+	defer func() { _ = s.Close() }()
+
+	self, entries, err := dht.LoadTable(s)
+	if err != nil {
+		return tableJSON{}, err
+	}
+
+	return newTable(self, entries, time.Now()), nil
+}
+
+/*
+Table prints the routing table of the serve listening on the admin socket,
+bucket by bucket, with how long ago each contact was last seen. With DB it
+prints the table a stopped serve saved instead.
+*/
+func Table(opts TableOptions) int {
+	log := logger()
+
+	var (
+		res tableJSON
+		err error
+	)
+
+	if opts.DB != "" {
+		res, err = saved(opts.DB)
+	} else {
+		ctx, cancel := opts.context()
+		defer cancel()
+
+		err = admin(ctx, opts.Admin, adminTable, &res)
+	}
+
+	if err != nil {
+		log.Error("no routing table", "error", err)
+		return 1
+	}
+
+	opts.print(res, func(w io.Writer) {
+		fmt.Fprintf(w, "node %s, %d contacts\n", res.ID, res.Size)
+
+		for _, bk := range res.Buckets {
+			fmt.Fprintf(w, "\nbucket %3d  %2d/%d\n", bk.Index, bk.Fill, dht.BucketSize)
+			for _, c := range bk.Contacts {
+				age := time.Duration(c.Age * float64(time.Second)).Round(time.Second)
+				fmt.Fprintf(w, "  %.16s  %-50s  %s ago\n", c.ID, c.Contact, age)
+			}
+		}
+	})
+
+	return 0
+}
@@ -0,0 +1,272 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package directory
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/descriptor"
+	"github.com/LibSEA/mixnet/dht"
+)
+
+/*
+The directory lets nodes find each other without a directory authority. Every
+node publishes its descriptor, see the descriptor package, as a DHT record
+signed by its identity key, with Salt, so the record of a node is found
+under dht.RecordKey(identity, Salt). The sequence number of the record is
+the unix time it was published at, which tells clients whether the node is
+still around.
+
+Clients learn about nodes they don't know the identity of by sampling: they
+pick random points in the keyspace and ask the nodes there for the
+descriptors they hold, see dht.Sample.
+*/
+
+// Salt is the salt descriptor records are published with.
+var Salt = []byte("mixnet/descriptor")
+
+const (
+	// republish is how often Run publishes the descriptor again.
+	republish = time.Hour
+
+	// MaxAge is how long after it was published a descriptor is taken to
+	// belong to a live node.
+	MaxAge = 3 * republish
+
+	// perWalk is how many descriptors Sample takes from each random walk,
+	// so the nodes at one point of the keyspace can't fill a sample.
+	perWalk = 2
+
+	// lookupPaths is how many disjoint paths Lookup uses.
+	lookupPaths = 3
+)
+
+type Options struct {
+	DHT    *dht.DHT
+	Clock  clock.Clock
+	Logger *slog.Logger
+	// Quorum is how many DHT nodes must hold a descriptor before Sample
+	// believes it. Defaults to 2.
+	Quorum int
+}
+
+type Directory struct {
+	dht    *dht.DHT
+	clock  clock.Clock
+	logger *slog.Logger
+	quorum int
+}
+
+// Entry is a descriptor found in the directory.
+type Entry struct {
+	Identity   ed25519.PublicKey
+	Descriptor *descriptor.Descriptor
+	Published  time.Time
+}
+
+func New(opts Options) *Directory {
+	d := Directory{
+		dht:    opts.DHT,
+		clock:  opts.Clock,
+		logger: opts.Logger,
+		quorum: opts.Quorum,
+	}
+
+	if d.clock == nil {
+		d.clock = clock.Real{}
+	}
+
+	if d.logger == nil {
+		d.logger = slog.New(slog.DiscardHandler)
+	}
+
+	if d.quorum == 0 {
+		d.quorum = 2
+	}
+
+	return &d
+}
+
+/*
+Publish stores desc in the DHT in a record signed with the identity key
+priv, which must be the key that signed desc.
+*/
+func (d *Directory) Publish(
+	ctx context.Context,
+	priv ed25519.PrivateKey,
+	desc *descriptor.Descriptor,
+) error {
+	if !desc.Identity.Equal(priv.Public()) {
+		return fmt.Errorf("descriptor belongs to another identity")
+	}
+
+	seq := uint64(d.clock.Now().Unix())
+
+	r, err := dht.NewRecord(priv, Salt, seq, desc.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to sign descriptor. %w", err)
+	}
+
+	err = d.dht.Store(ctx, r)
+	if err != nil {
+		return fmt.Errorf("failed to publish descriptor. %w", err)
+	}
+
+	return nil
+}
+
+/*
+Run publishes desc now and again every republish, so it stays younger than
+MaxAge, until ctx is done. Clients drop desc once it expires, whatever its
+age.
+*/
+func (d *Directory) Run(
+	ctx context.Context,
+	priv ed25519.PrivateKey,
+	desc *descriptor.Descriptor,
+) {
+	t := time.NewTicker(republish)
+	defer t.Stop()
+
+	for {
+		err := d.Publish(ctx, priv, desc)
+		if err != nil && ctx.Err() == nil {
+			d.logger.Warn("failed to publish descriptor", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Lookup returns the descriptor of the node with identity key pub.
+func (d *Directory) Lookup(ctx context.Context, pub ed25519.PublicKey) (*Entry, error) {
+	r, _, err := d.dht.FindValue(ctx, dht.RecordKey(pub, Salt), dht.Disjoint(lookupPaths))
+	if err != nil {
+		return nil, err
+	}
+
+	if r == nil {
+		return nil, fmt.Errorf("no descriptor for %x", []byte(pub))
+	}
+
+	e, err := d.entry(r)
+	if err != nil {
+		return nil, fmt.Errorf("bad descriptor for %x. %w", []byte(pub), err)
+	}
+
+	return e, nil
+}
+
+/*
+Sample returns up to n descriptors of live nodes, found by random walks to
+random points of the keyspace. Each walk adds at most perWalk descriptors,
+the closest to its point, and needs the quorum of DHT nodes to agree on them.
+When walks return the same node the newest descriptor wins. Sample gives up
+after 4n walks, so it returns fewer than n when the network is smaller.
+*/
+func (d *Directory) Sample(ctx context.Context, n int) ([]Entry, error) {
+	found := make(map[string]int)
+
+	var out []Entry
+
+	for walk := 0; len(out) < n && walk < 4*n; walk++ {
+		var target dht.NodeID
+
+		_, err := rand.Read(target[:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to pick walk target. %w", err)
+		}
+
+		records, err := d.dht.Sample(ctx, target, Salt, d.quorum)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			d.logger.Debug("random walk failed", "error", err)
+			continue
+		}
+
+		added := 0
+		for _, r := range records {
+			e, err := d.entry(r)
+			if err != nil {
+				d.logger.Debug("skipping descriptor", "error", err)
+				continue
+			}
+
+			id := string(e.Identity)
+			if i, ok := found[id]; ok {
+				if e.Published.After(out[i].Published) {
+					out[i] = *e
+				}
+				continue
+			}
+
+			if added == perWalk || len(out) == n {
+				continue
+			}
+
+			found[id] = len(out)
+			out = append(out, *e)
+			added++
+		}
+	}
+
+	return out, nil
+}
+
+/*
+entry checks that r holds a valid descriptor of the key that signed r, of a
+node that is still live.
+*/
+func (d *Directory) entry(r *dht.Record) (*Entry, error) {
+	now := d.clock.Now()
+
+	e := Entry{
+		Identity:  r.PublicKey,
+		Published: time.Unix(int64(r.Seq), 0),
+	}
+
+	if now.Sub(e.Published) >= MaxAge {
+		return nil, fmt.Errorf("descriptor is too old")
+	}
+
+	desc, err := descriptor.Verify(r.Value, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if !desc.Identity.Equal(r.PublicKey) {
+		return nil, fmt.Errorf("descriptor belongs to another identity")
+	}
+
+	e.Descriptor = desc
+
+	return &e, nil
+}
@@ -0,0 +1,185 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package directory
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/cert"
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/descriptor"
+	"github.com/LibSEA/mixnet/dht"
+	"github.com/LibSEA/mixnet/store"
+)
+
+// startNetwork starts n DHT nodes on loopback, joined through the first.
+func startNetwork(t *testing.T, n int) []*dht.DHT {
+	t.Helper()
+
+	ctx := context.Background()
+
+	var nodes []*dht.DHT
+
+	for range n {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = ln.Close() })
+
+		d, err := dht.New(dht.Options{
+			V4:         ln.Addr().(*net.TCPAddr).AddrPort(),
+			Difficulty: 4,
+			Storage:    store.NewMemory(clock.Real{}),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() { _ = d.Serve(ln) }()
+
+		if len(nodes) > 0 {
+			err = d.Bootstrap(ctx, []dht.Contact{nodes[0].Self})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		nodes = append(nodes, d)
+	}
+
+	return nodes
+}
+
+func certify(t *testing.T, priv ed25519.PrivateKey, kind cert.Kind, key []byte, epoch uint64, now time.Time) *cert.Certificate {
+	c := cert.Certificate{
+		Kind:      kind,
+		Epoch:     epoch,
+		ValidFrom: now.Add(-time.Hour),
+		Expires:   now.Add(3 * MaxAge),
+	}
+	copy(c.Key[:], key)
+
+	err := cert.Sign(priv, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &c
+}
+
+func newDescriptor(t *testing.T, priv ed25519.PrivateKey, now time.Time) *descriptor.Descriptor {
+	_, signing, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := descriptor.Descriptor{
+		Addrs:     []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:8080")},
+		Role:      descriptor.RoleEntry,
+		Published: now,
+		Expires:   now.Add(2 * MaxAge),
+		LinkKey:   [32]byte{2},
+		MixKeys:   []descriptor.MixKey{{Epoch: 1, Key: [32]byte{1}}},
+	}
+
+	d.SigningCert = certify(t, priv, cert.KindSigning, signing.Public().(ed25519.PublicKey), 0, now)
+	d.LinkCert = certify(t, priv, cert.KindLink, d.LinkKey[:], 0, now)
+	d.MixKeys[0].Cert = certify(t, priv, cert.KindMix, d.MixKeys[0].Key[:], 1, now)
+
+	err = descriptor.Sign(signing, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &d
+}
+
+func TestDirectory(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	nodes := startNetwork(t, 8)
+	clk := clock.NewFake(time.Now())
+
+	published := map[string]bool{}
+
+	var (
+		first     ed25519.PublicKey
+		firstPriv ed25519.PrivateKey
+	)
+
+	for i := range 5 {
+		pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+		if first == nil {
+			first, firstPriv = pub, priv
+		}
+
+		dir := New(Options{DHT: nodes[i], Clock: clk})
+		err := dir.Publish(ctx, priv, newDescriptor(t, priv, clk.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		published[string(pub)] = true
+	}
+
+	client := New(Options{DHT: nodes[7], Clock: clk})
+
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	if err := client.Publish(ctx, other, newDescriptor(t, firstPriv, clk.Now())); err == nil {
+		t.Fatal("should not publish the descriptor of another identity")
+	}
+
+	e, err := client.Lookup(ctx, first)
+	if err != nil || !e.Descriptor.Identity.Equal(first) || e.Descriptor.Role != descriptor.RoleEntry {
+		t.Fatalf("should find descriptor by identity. %v %v", e, err)
+	}
+
+	got, err := client.Sample(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(published) {
+		t.Fatalf("sample should find all %d nodes. got %d", len(published), len(got))
+	}
+
+	for _, e := range got {
+		if !published[string(e.Identity)] {
+			t.Fatal("sample returned an unknown node")
+		}
+	}
+
+	// nodes that stopped publishing age out.
+	clk.Advance(MaxAge)
+
+	got, err = client.Sample(ctx, 10)
+	if err != nil || len(got) != 0 {
+		t.Fatalf("old descriptors should not be sampled. %d %v", len(got), err)
+	}
+
+	if _, err := client.Lookup(ctx, first); err == nil {
+		t.Fatal("old descriptor should not be returned by lookup")
+	}
+}
@@ -18,7 +18,7 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package entry
 
 import (
-	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
 	"math"
@@ -26,6 +26,7 @@ import (
 	"os"
 	"strconv"
 
+	"github.com/LibSEA/mixnet/keys"
 	"github.com/LibSEA/mixnet/session"
 	"github.com/flynn/noise"
 )
@@ -33,6 +34,8 @@ import (
 type Options struct {
 	Port uint16
 	Host string
+	// Keys is where the keys are loaded from, see the keys package.
+	Keys keys.Source
 }
 
 type cmd struct {
@@ -82,16 +85,16 @@ func Run(opts Options) int {
 	cs := noise.NewCipherSuite(
 		noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b,
 	)
-	kp, err := cs.GenerateKeypair(rand.Reader)
-
+	k, err := opts.Keys.Load()
 	if err != nil {
-		c.logger.Error("error generating keypair.", "error", err)
+		c.logger.Error("couldn't load keys.", "error", err)
 		return 1
 	}
+	kp := k.Link
 
 	cf := 0
 
-	c.logger.Info("started")
+	c.logger.Info("started", "link_key", hex.EncodeToString(kp.Public))
 
 	for {
 		if cf > 10 {
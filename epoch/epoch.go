@@ -0,0 +1,53 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package epoch
+
+import "time"
+
+/*
+The network runs in epochs of Period, counted from Genesis. Mix keys and pki
+documents are per epoch, so every node has to agree on when one starts. All
+nodes use Default unless a network picks another genesis.
+*/
+
+const Period = 20 * time.Minute
+
+// Genesis is when epoch 0 starts.
+var Genesis = time.Unix(0, 0)
+
+// Default is the epoch clock of the network.
+var Default = Clock{Genesis: Genesis, Period: Period}
+
+type Clock struct {
+	Genesis time.Time
+	Period  time.Duration
+}
+
+// At returns the epoch t is in. Times before Genesis are in epoch 0.
+func (c Clock) At(t time.Time) uint64 {
+	if t.Before(c.Genesis) {
+		return 0
+	}
+
+	return uint64(t.Sub(c.Genesis) / c.Period)
+}
+
+// Start returns when epoch e starts.
+func (c Clock) Start(e uint64) time.Time {
+	return c.Genesis.Add(time.Duration(e) * c.Period)
+}
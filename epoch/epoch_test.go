@@ -0,0 +1,50 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package epoch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	sut := Clock{Genesis: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Period: time.Hour}
+
+	for _, c := range []struct {
+		at   time.Time
+		want uint64
+	}{
+		{sut.Genesis.Add(-time.Hour), 0},
+		{sut.Genesis, 0},
+		{sut.Genesis.Add(59 * time.Minute), 0},
+		{sut.Genesis.Add(time.Hour), 1},
+		{sut.Genesis.Add(49*time.Hour + time.Second), 49},
+	} {
+		if got := sut.At(c.at); got != c.want {
+			t.Fatalf("%s should be in epoch %d. got %d", c.at, c.want, got)
+		}
+	}
+
+	if !sut.Start(49).Equal(sut.Genesis.Add(49 * time.Hour)) {
+		t.Fatal("epoch should start a period after the last")
+	}
+
+	if Default.At(time.Unix(1200, 0)) != 1 || !Default.Start(3).Equal(time.Unix(3600, 0)) {
+		t.Fatal("default epochs are 20 minutes from the unix epoch")
+	}
+}
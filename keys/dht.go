@@ -0,0 +1,58 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package keys
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/flynn/noise"
+)
+
+const DHTFile = "dht.key"
+
+/*
+DHT returns the static Noise key of a DHT node, making it the first time.
+The node ID is derived from it, so unlike the link key it is never rotated:
+a new key would make the node a new one, losing its place in the network
+and the records it holds.
+*/
+func (k *Keys) DHT() (noise.DHKey, error) {
+	path := filepath.Join(k.dir, DHTFile)
+
+	kp, err := readLink(path, k.passphrase)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return kp, err
+	}
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return noise.DHKey{}, fmt.Errorf("failed to generate dht key. %w", err)
+	}
+
+	err = writeKey(path, priv, k.passphrase)
+	if err != nil {
+		return noise.DHKey{}, err
+	}
+
+	return dhKey(priv), nil
+}
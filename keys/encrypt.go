@@ -0,0 +1,187 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package keys
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+/*
+Encrypted keys are PEM blocks of type encryptedType holding
+
+	version(1)|time(4)|memory(4)|threads(1)|salt(16)|nonce(24)|ciphertext
+
+The PKCS #8 key is sealed with XChaCha20-Poly1305 under a key derived from
+the passphrase with Argon2id, using the time, memory in KiB and threads
+parameters of the header. The whole header is authenticated, so parameters
+can't be weakened without the passphrase. The parameters default to the
+second recommendation of RFC 9106.
+*/
+
+const (
+	encryptedType = "MIXNET ENCRYPTED PRIVATE KEY"
+	// PassphraseEnv is the environment variable daemons take the
+	// passphrase from when they aren't given a file descriptor.
+	PassphraseEnv = "MIXNET_PASSPHRASE"
+
+	encryptedVersion = 1
+	saltSize         = 16
+	headerSize       = 1 + 4 + 4 + 1 + saltSize + chacha20poly1305.NonceSizeX
+
+	// maxMemory and maxTime bound the work a key file can ask for.
+	maxMemory = 4 << 20
+	maxTime   = 64
+
+	maxPassphrase = 1024
+)
+
+var (
+	ErrNoPassphrase = errors.New("key is encrypted and no passphrase was given")
+	ErrPassphrase   = errors.New("wrong passphrase")
+)
+
+// kdf holds the Argon2id parameters new keys are encrypted with.
+var kdf = struct {
+	time, memory uint32
+	threads      uint8
+}{time: 3, memory: 64 << 10, threads: 4}
+
+func encrypt(der, passphrase []byte) ([]byte, error) {
+	header := make([]byte, 0, headerSize)
+	header = append(header, encryptedVersion)
+	header = binary.BigEndian.AppendUint32(header, kdf.time)
+	header = binary.BigEndian.AppendUint32(header, kdf.memory)
+	header = append(header, kdf.threads)
+	header = header[:headerSize]
+
+	_, err := rand.Read(header[headerSize-saltSize-chacha20poly1305.NonceSizeX:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to make salt. %w", err)
+	}
+
+	aead, err := chacha20poly1305.NewX(deriveKey(header, passphrase))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := header[headerSize-chacha20poly1305.NonceSizeX:]
+
+	return aead.Seal(header, nonce, der, header), nil
+}
+
+func decrypt(b, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrNoPassphrase
+	}
+
+	if len(b) < headerSize+chacha20poly1305.Overhead || b[0] != encryptedVersion {
+		return nil, fmt.Errorf("bad encrypted key")
+	}
+
+	header := b[:headerSize]
+
+	time := binary.BigEndian.Uint32(header[1:])
+	memory := binary.BigEndian.Uint32(header[5:])
+	threads := header[9]
+	if time == 0 || time > maxTime || memory > maxMemory || threads == 0 || memory < 8*uint32(threads) {
+		return nil, fmt.Errorf("bad key derivation parameters")
+	}
+
+	aead, err := chacha20poly1305.NewX(deriveKey(header, passphrase))
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := aead.Open(nil, header[headerSize-chacha20poly1305.NonceSizeX:], b[headerSize:], header)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+
+	return der, nil
+}
+
+// deriveKey derives the encryption key from passphrase and the header.
+func deriveKey(header, passphrase []byte) []byte {
+	salt := header[10 : 10+saltSize]
+
+	return argon2.IDKey(
+		passphrase,
+		salt,
+		binary.BigEndian.Uint32(header[1:]),
+		binary.BigEndian.Uint32(header[5:]),
+		header[9],
+		chacha20poly1305.KeySize,
+	)
+}
+
+/*
+ReadPassphrase reads a passphrase from the file descriptor fd, up to the
+first newline, or when fd is negative from the environment variable env,
+which is then removed so child processes don't see it. It returns nil when
+there is neither. fd is read a byte at a time and left open, so it can hold
+several passphrases, one per line.
+*/
+func ReadPassphrase(fd int, env string) ([]byte, error) {
+	if fd < 0 {
+		p, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, nil
+		}
+
+		_ = os.Unsetenv(env)
+
+		return []byte(p), nil
+	}
+
+	var (
+		b []byte
+		c [1]byte
+	)
+
+	for {
+		n, err := syscall.Read(fd, c[:])
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase. %w", err)
+		}
+
+		if n == 0 || c[0] == '\n' {
+			break
+		}
+
+		if len(b) == maxPassphrase {
+			return nil, fmt.Errorf("passphrase is longer than %d bytes", maxPassphrase)
+		}
+
+		b = append(b, c[0])
+	}
+
+	return bytes.TrimSuffix(b, []byte("\r")), nil
+}
@@ -0,0 +1,157 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package keys
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncrypted(t *testing.T) {
+	fastKDF(t)
+
+	dir := t.TempDir()
+	passphrase := []byte("correct horse")
+
+	k, err := Generate(dir, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{IdentityFile, LinkFile} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(b), encryptedType) {
+			t.Fatalf("%s should be encrypted", name)
+		}
+	}
+
+	if _, err := Load(dir, nil); !errors.Is(err, ErrNoPassphrase) {
+		t.Fatalf("loading without a passphrase should fail. %v", err)
+	}
+
+	if _, err := Load(dir, []byte("wrong")); !errors.Is(err, ErrPassphrase) {
+		t.Fatalf("loading with the wrong passphrase should fail. %v", err)
+	}
+
+	got, err := Load(dir, passphrase)
+	if err != nil || !got.Identity.Equal(k.Identity) {
+		t.Fatalf("should load with the passphrase. %v", err)
+	}
+
+	if err := Passwd(dir, []byte("wrong"), []byte("new")); err == nil {
+		t.Fatal("passwd with the wrong passphrase should fail")
+	}
+
+	if err := Passwd(dir, passphrase, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(dir, passphrase); err == nil {
+		t.Fatal("the old passphrase should not work after passwd")
+	}
+
+	got, err = Load(dir, []byte("new"))
+	if err != nil || !got.Identity.Equal(k.Identity) {
+		t.Fatalf("passwd should keep the keys. %v", err)
+	}
+
+	if err := Passwd(dir, []byte("new"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = Load(dir, nil)
+	if err != nil || !got.Identity.Equal(k.Identity) {
+		t.Fatalf("keys should be stored in plaintext. %v", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 5 {
+		t.Fatal("passwd should not leave temporary files behind")
+	}
+}
+
+func TestTamperedParameters(t *testing.T) {
+	fastKDF(t)
+
+	b, err := encrypt([]byte("key"), []byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if der, err := decrypt(b, []byte("pass")); err != nil || string(der) != "key" {
+		t.Fatalf("should decrypt. %v", err)
+	}
+
+	// a weaker time parameter changes the derived key and the header.
+	b[4] = 2
+	if _, err := decrypt(b, []byte("pass")); !errors.Is(err, ErrPassphrase) {
+		t.Fatalf("changed parameters should fail. %v", err)
+	}
+
+	b[1] = 0xff
+	if _, err := decrypt(b, []byte("pass")); err == nil || errors.Is(err, ErrPassphrase) {
+		t.Fatalf("too expensive parameters should be refused before deriving. %v", err)
+	}
+}
+
+func TestReadPassphrase(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+
+	_, _ = w.WriteString("first\r\nsecond")
+	_ = w.Close()
+
+	for _, want := range []string{"first", "second", ""} {
+		p, err := ReadPassphrase(int(r.Fd()), "")
+		if err != nil || string(p) != want {
+			t.Fatalf("want %q got %q. %v", want, p, err)
+		}
+	}
+
+	t.Setenv("TEST_PASSPHRASE", "from env")
+
+	p, err := ReadPassphrase(-1, "TEST_PASSPHRASE")
+	if err != nil || string(p) != "from env" {
+		t.Fatalf("should read the environment. %q %v", p, err)
+	}
+
+	if _, ok := os.LookupEnv("TEST_PASSPHRASE"); ok {
+		t.Fatal("the environment variable should be removed")
+	}
+
+	if p, err := ReadPassphrase(-1, "TEST_PASSPHRASE"); p != nil || err != nil {
+		t.Fatal("no passphrase should be nil")
+	}
+}
+
+// fastKDF makes key derivation cheap for the test.
+func fastKDF(t *testing.T) {
+	saved := kdf
+	kdf.time, kdf.memory, kdf.threads = 1, 64, 1
+	t.Cleanup(func() { kdf = saved })
+}
@@ -0,0 +1,564 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package keys
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/LibSEA/mixnet/cert"
+	"github.com/flynn/noise"
+)
+
+/*
+A key directory holds the long-term keys of a node, so it keeps the same
+identity and Noise static key across restarts and peers can pin them:
+
+	identity.key  Ed25519 key that certifies the others and signs records
+	signing.key   Ed25519 key descriptors are signed with
+	signing.cert  certificate of the signing key, signed by the identity key
+	link.key      X25519 static key of the Noise sessions
+	link.cert     certificate of the link key, signed by the identity key
+	dht.key       X25519 static key of a DHT node, see DHT
+	mix/          the Sphinx keys of a mix and their certificates, see mix.go
+
+The keys are PKCS #8 PEM files, encrypted with a passphrase if one is
+given, see encrypt.go. The signing and link keys are short-lived: Rotate
+replaces them and their certificates without changing the identity, so a
+leaked key costs a rotation and not the identity. Daemons only need the
+short-lived keys, so identity.key can be kept offline and brought back to
+rotate or to certify mix keys ahead of time.
+*/
+
+const (
+	IdentityFile = "identity.key"
+	LinkFile     = "link.key"
+	LinkCertFile = "link.cert"
+
+	SigningFile     = "signing.key"
+	SigningCertFile = "signing.cert"
+
+	// LinkLifetime is how long link and signing certificates are valid by
+	// default.
+	LinkLifetime = 30 * 24 * time.Hour
+
+	certType = "MIXNET CERTIFICATE"
+)
+
+var ErrOffline = errors.New("the identity key isn't in the key directory")
+
+type Keys struct {
+	// Identity is nil when the identity key is offline.
+	Identity ed25519.PrivateKey
+	// Public is the public identity key, known from LinkCert.
+	Public   ed25519.PublicKey
+	Link     noise.DHKey
+	LinkCert *cert.Certificate
+	// Signing signs descriptors, see descriptor.Sign.
+	Signing     ed25519.PrivateKey
+	SigningCert *cert.Certificate
+
+	// dir and passphrase are kept for the mix keys, see mix.go.
+	dir        string
+	passphrase []byte
+}
+
+// Source is where a daemon finds its keys.
+type Source struct {
+	Dir string
+	// PassphraseFD is the file descriptor the passphrase of encrypted keys
+	// is read from. When it is negative the passphrase is taken from
+	// PassphraseEnv.
+	PassphraseFD int
+}
+
+// Load reads the passphrase, if any, and the keys in s.Dir.
+func (s Source) Load() (*Keys, error) {
+	passphrase, err := ReadPassphrase(s.PassphraseFD, PassphraseEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	return Load(s.Dir, passphrase)
+}
+
+/*
+Load reads the keys in dir and checks that the link and signing
+certificates are valid now. passphrase is only used for encrypted keys and
+may be nil otherwise. The identity key is left out when it isn't in dir.
+*/
+func Load(dir string, passphrase []byte) (*Keys, error) {
+	return load(dir, passphrase, time.Now())
+}
+
+func load(dir string, passphrase []byte, now time.Time) (*Keys, error) {
+	link, err := readLink(filepath.Join(dir, LinkFile), passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := readPEM(filepath.Join(dir, LinkCertFile), certType)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := cert.Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("bad link certificate. %w", err)
+	}
+
+	c, err = cert.Verify(b, cert.KindLink, c.Identity, link.Public, now)
+	if err != nil {
+		return nil, fmt.Errorf("bad link certificate, rotate the link key. %w", err)
+	}
+
+	signing, err := ReadIdentity(filepath.Join(dir, SigningFile), passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err = readPEM(filepath.Join(dir, SigningCertFile), certType)
+	if err != nil {
+		return nil, err
+	}
+
+	sc, err := cert.Verify(b, cert.KindSigning, c.Identity, signing.Public().(ed25519.PublicKey), now)
+	if err != nil {
+		return nil, fmt.Errorf("bad signing certificate, rotate the keys. %w", err)
+	}
+
+	k := Keys{
+		Public:      c.Identity,
+		Link:        link,
+		LinkCert:    c,
+		Signing:     signing,
+		SigningCert: sc,
+		dir:         dir,
+		passphrase:  passphrase,
+	}
+
+	k.Identity, err = ReadIdentity(filepath.Join(dir, IdentityFile), passphrase)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &k, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !k.Identity.Public().(ed25519.PublicKey).Equal(k.Public) {
+		return nil, fmt.Errorf("link certificate belongs to another identity")
+	}
+
+	return &k, nil
+}
+
+/*
+Generate makes new keys and writes them to dir, making dir if needed. They
+are encrypted with passphrase unless it is empty. It won't overwrite keys
+that are already there.
+*/
+func Generate(dir string, passphrase []byte) (*Keys, error) {
+	for _, name := range []string{IdentityFile, LinkFile, LinkCertFile, SigningFile, SigningCertFile} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if err == nil {
+			return nil, fmt.Errorf("%s already exists. %w", filepath.Join(dir, name), fs.ErrExist)
+		}
+	}
+
+	_, identity, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity key. %w", err)
+	}
+
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make key directory. %w", err)
+	}
+
+	err = writeKey(filepath.Join(dir, IdentityFile), identity, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return Rotate(dir, identity, passphrase, LinkLifetime)
+}
+
+/*
+CheckIdentity checks that identity is the one the link certificate in dir
+was signed by, expired or not. A dir without one has no identity yet.
+*/
+func CheckIdentity(dir string, identity ed25519.PrivateKey) error {
+	b, err := readPEM(filepath.Join(dir, LinkCertFile), certType)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	c, err := cert.Parse(b)
+	if err != nil {
+		return fmt.Errorf("bad link certificate. %w", err)
+	}
+
+	if !c.Identity.Equal(identity.Public()) {
+		return fmt.Errorf("the identity key isn't the one of the keys in %s", dir)
+	}
+
+	return nil
+}
+
+/*
+Rotate replaces the link and signing keys in dir with new ones, certified
+by identity for lifetime from now. They are encrypted with passphrase
+unless it is empty. identity has to be the one of the keys already in dir,
+see CheckIdentity.
+*/
+func Rotate(
+	dir string,
+	identity ed25519.PrivateKey,
+	passphrase []byte,
+	lifetime time.Duration,
+) (*Keys, error) {
+	err := CheckIdentity(dir, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate link key. %w", err)
+	}
+
+	signPub, signing, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key. %w", err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+
+	lc, err := certify(identity, cert.KindLink, link.PublicKey().Bytes(), now, now.Add(lifetime))
+	if err != nil {
+		return nil, err
+	}
+
+	sc, err := certify(identity, cert.KindSigning, signPub, now, now.Add(lifetime))
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeKey(filepath.Join(dir, LinkFile), link, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeFile(filepath.Join(dir, LinkCertFile), &pem.Block{Type: certType, Bytes: lc.Bytes()})
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeKey(filepath.Join(dir, SigningFile), signing, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeFile(filepath.Join(dir, SigningCertFile), &pem.Block{Type: certType, Bytes: sc.Bytes()})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Keys{
+		Identity:    identity,
+		Public:      lc.Identity,
+		Link:        dhKey(link),
+		LinkCert:    lc,
+		Signing:     signing,
+		SigningCert: sc,
+		dir:         dir,
+		passphrase:  passphrase,
+	}, nil
+}
+
+// certify signs a certificate of kind for key with identity.
+func certify(
+	identity ed25519.PrivateKey,
+	kind cert.Kind,
+	key []byte,
+	from, expires time.Time,
+) (*cert.Certificate, error) {
+	c := cert.Certificate{
+		Kind:      kind,
+		ValidFrom: from,
+		Expires:   expires,
+	}
+	copy(c.Key[:], key)
+
+	err := cert.Sign(identity, &c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign %s certificate. %w", kind, err)
+	}
+
+	return &c, nil
+}
+
+/*
+CertifyMix signs a certificate for the mix key of epoch, valid from from
+until expires. It needs the identity key.
+*/
+func (k *Keys) CertifyMix(
+	epoch uint64,
+	key [32]byte,
+	from, expires time.Time,
+) (*cert.Certificate, error) {
+	if k.Identity == nil {
+		return nil, ErrOffline
+	}
+
+	c := cert.Certificate{
+		Kind:      cert.KindMix,
+		Key:       key,
+		Epoch:     epoch,
+		ValidFrom: from,
+		Expires:   expires,
+	}
+
+	err := cert.Sign(k.Identity, &c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign mix certificate. %w", err)
+	}
+
+	return &c, nil
+}
+
+/*
+Passwd encrypts the keys in dir, encrypted with old, with next instead. An
+empty next stores them in plaintext. An offline identity key is left alone.
+Mix keys are encrypted again too. Every key is read and encrypted before
+any is written, so a key that can't be read leaves the directory as it was.
+*/
+func Passwd(dir string, old, next []byte) error {
+	k := Keys{dir: dir, passphrase: old}
+
+	epochs, err := k.MixEpochs()
+	if err != nil {
+		return err
+	}
+
+	paths := []string{
+		filepath.Join(dir, LinkFile),
+		filepath.Join(dir, SigningFile),
+		filepath.Join(dir, IdentityFile),
+		filepath.Join(dir, DHTFile),
+	}
+	for _, e := range epochs {
+		paths = append(paths, k.mixPath(e))
+	}
+
+	blocks := make(map[string]*pem.Block)
+
+	for i, path := range paths {
+		key, err := read(path, old)
+		// the identity key may be offline and the dht key not made yet.
+		if (i == 2 || i == 3) && errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		blocks[path], err = encodeKey(key, next)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, path := range paths {
+		if b, ok := blocks[path]; ok {
+			err = writeFile(path, b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReadIdentity reads the Ed25519 key in the PEM file at path.
+func ReadIdentity(path string, passphrase []byte) (ed25519.PrivateKey, error) {
+	k, err := read(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 key", path)
+	}
+
+	return priv, nil
+}
+
+func readLink(path string, passphrase []byte) (noise.DHKey, error) {
+	k, err := read(path, passphrase)
+	if err != nil {
+		return noise.DHKey{}, err
+	}
+
+	priv, ok := k.(*ecdh.PrivateKey)
+	if !ok || priv.Curve() != ecdh.X25519() {
+		return noise.DHKey{}, fmt.Errorf("%s is not an x25519 key", path)
+	}
+
+	return dhKey(priv), nil
+}
+
+func dhKey(priv *ecdh.PrivateKey) noise.DHKey {
+	return noise.DHKey{Private: priv.Bytes(), Public: priv.PublicKey().Bytes()}
+}
+
+func read(path string, passphrase []byte) (any, error) {
+	block, err := readBlock(path)
+	if err != nil {
+		return nil, err
+	}
+
+	der := block.Bytes
+
+	switch block.Type {
+	case "PRIVATE KEY":
+	case encryptedType:
+		der, err = decrypt(der, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s. %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s is not a PEM private key", path)
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key. %w", err)
+	}
+
+	return k, nil
+}
+
+// readPEM returns the bytes of the PEM block of type typ at path.
+func readPEM(path, typ string) ([]byte, error) {
+	block, err := readBlock(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.Type != typ {
+		return nil, fmt.Errorf("%s is not a %s", path, strings.ToLower(typ))
+	}
+
+	return block.Bytes, nil
+}
+
+func readBlock(path string) (*pem.Block, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no %s, make one with mixnet keygen. %w", path, err)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s. %w", path, err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	return block, nil
+}
+
+// writeKey writes k to path, encrypted with passphrase unless it is empty.
+func writeKey(path string, k any, passphrase []byte) error {
+	block, err := encodeKey(k, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path, block)
+}
+
+// encodeKey encodes k as a PEM block, encrypted with passphrase if it is set.
+func encodeKey(k any, passphrase []byte) (*pem.Block, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key. %w", err)
+	}
+
+	block := pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	if len(passphrase) > 0 {
+		block.Type = encryptedType
+		block.Bytes, err = encrypt(der, passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &block, nil
+}
+
+/*
+writeFile writes block to a temporary file that is renamed to path, so a
+crash never leaves a half written file behind.
+*/
+func writeFile(path string, block *pem.Block) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create %s. %w", path, err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	err = pem.Encode(f, block)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write %s. %w", path, err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write %s. %w", path, err)
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed to replace %s. %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,266 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package keys
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/cert"
+)
+
+func TestGenerateAndLoad(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+
+	k, err := Generate(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{IdentityFile, LinkFile, LinkCertFile, SigningFile, SigningCertFile} {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi.Mode().Perm() != 0o600 {
+			t.Fatalf("%s should only be readable by the owner. %v", name, fi.Mode())
+		}
+	}
+
+	got, err := Load(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.Identity.Equal(k.Identity) ||
+		!bytes.Equal(got.Link.Private, k.Link.Private) ||
+		!bytes.Equal(got.Link.Public, k.Link.Public) ||
+		!got.Signing.Equal(k.Signing) {
+		t.Fatal("loaded keys should be the generated ones")
+	}
+
+	if _, err := Generate(dir, nil); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("generate should not overwrite keys. %v", err)
+	}
+
+	again, err := Load(dir, nil)
+	if err != nil || !again.Identity.Equal(k.Identity) {
+		t.Fatal("keys should be unchanged")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := Load(dir, nil); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("missing keys should fail. %v", err)
+	}
+
+	if _, err := Generate(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the identity key is not a link key.
+	b, err := os.ReadFile(filepath.Join(dir, IdentityFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, LinkFile), b, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(dir, nil); err == nil {
+		t.Fatal("an ed25519 link key should be rejected")
+	}
+
+	err = os.WriteFile(filepath.Join(dir, IdentityFile), []byte("not a key"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadIdentity(filepath.Join(dir, IdentityFile), nil); err == nil {
+		t.Fatal("a file that isn't PEM should be rejected")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+
+	k, err := Generate(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !k.Public.Equal(k.Identity.Public()) || k.LinkCert.Expires.Sub(k.LinkCert.ValidFrom) != LinkLifetime {
+		t.Fatal("the link key should be certified by the identity")
+	}
+
+	rotated, err := Rotate(dir, k.Identity, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.Public.Equal(k.Public) || bytes.Equal(got.Link.Public, k.Link.Public) ||
+		!bytes.Equal(got.Link.Public, rotated.Link.Public) {
+		t.Fatal("rotation should replace the link key and keep the identity")
+	}
+
+	if got.Signing.Equal(k.Signing) || !got.Signing.Equal(rotated.Signing) ||
+		got.SigningCert.Kind != cert.KindSigning || !got.SigningCert.Identity.Equal(k.Public) {
+		t.Fatal("rotation should replace the signing key too")
+	}
+
+	if _, err := load(dir, nil, time.Now().Add(2*time.Hour)); !errors.Is(err, cert.ErrExpired) {
+		t.Fatalf("an expired link certificate should fail. %v", err)
+	}
+
+	// a link key from another rotation doesn't match the certificate.
+	b, err := os.ReadFile(filepath.Join(dir, LinkCertFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Rotate(dir, k.Identity, nil, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, LinkCertFile), b, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(dir, nil); err == nil {
+		t.Fatal("a certificate of another link key should fail")
+	}
+
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+
+	if _, err := Rotate(dir, other, nil, time.Hour); err == nil {
+		t.Fatal("rotating with another identity key should fail")
+	}
+
+	if got, err := os.ReadFile(filepath.Join(dir, LinkCertFile)); err != nil || !bytes.Equal(got, b) {
+		t.Fatal("a refused rotation should leave the keys alone")
+	}
+}
+
+func TestOffline(t *testing.T) {
+	dir := t.TempDir()
+
+	k, err := Generate(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Rename(filepath.Join(dir, IdentityFile), filepath.Join(t.TempDir(), IdentityFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Identity != nil || !got.Public.Equal(k.Public) {
+		t.Fatal("keys should load without the identity key")
+	}
+
+	if _, err := got.CertifyMix(1, [32]byte{1}, time.Now(), time.Now().Add(time.Hour)); !errors.Is(err, ErrOffline) {
+		t.Fatalf("mix keys can't be certified offline. %v", err)
+	}
+
+	c, err := k.CertifyMix(1, [32]byte{1}, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := [32]byte{1}
+	if _, err := cert.Verify(c.Bytes(), cert.KindMix, got.Public, key[:], time.Now()); err != nil {
+		t.Fatalf("mix certificate should verify for the identity. %v", err)
+	}
+
+	// an identity that didn't sign the link certificate is refused.
+	other := t.TempDir()
+	if _, err := Generate(other, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(other, IdentityFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, IdentityFile), b, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(dir, nil); err == nil {
+		t.Fatal("an identity key of another node should fail")
+	}
+}
+
+func TestDHTKey(t *testing.T) {
+	fastKDF(t)
+
+	dir := t.TempDir()
+
+	k, err := Generate(dir, []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kp, err := k.DHT()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Rotate(dir, k.Identity, []byte("old"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Passwd(dir, []byte("old"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load(dir, []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := got.DHT()
+	if err != nil || !bytes.Equal(again.Private, kp.Private) {
+		t.Fatalf("the dht key should outlive rotations and passwd. %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package keys
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LibSEA/mixnet/cert"
+	"github.com/LibSEA/mixnet/epoch"
+)
+
+/*
+Mix keys are the Sphinx keys a mix uses for one epoch each. They are kept in
+the mix directory of the key directory, encrypted like the other keys:
+
+	mix/<epoch>.key   X25519 mix key of epoch
+	mix/<epoch>.cert  its certificate, signed by the identity key
+
+MakeMix certifies them with the identity key, so a mix whose identity key is
+offline has them made ahead of time for as many epochs as it should run.
+
+Unlike the long-term keys they are meant to go away. DestroyMix overwrites
+the key with zeros before removing it, so it can't be read back from the
+directory. That doesn't reach copies the file system or the disk keep on
+their own, copy on write file systems and SSDs may still hold the old
+blocks.
+*/
+const MixDir = "mix"
+
+func (k *Keys) mixPath(epoch uint64) string {
+	return filepath.Join(k.dir, MixDir, strconv.FormatUint(epoch, 10)+".key")
+}
+
+func (k *Keys) mixCertPath(epoch uint64) string {
+	return filepath.Join(k.dir, MixDir, strconv.FormatUint(epoch, 10)+".cert")
+}
+
+/*
+MakeMix makes and certifies the mix keys of the epochs from first to last
+that aren't there yet. A certificate is valid from now until the end of the
+epoch after its own, which covers the grace window. It needs the identity
+key.
+*/
+func (k *Keys) MakeMix(clock epoch.Clock, first, last uint64, now time.Time) error {
+	if k.Identity == nil {
+		return ErrOffline
+	}
+
+	err := os.MkdirAll(filepath.Join(k.dir, MixDir), 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to make mix key directory. %w", err)
+	}
+
+	now = now.Truncate(time.Second)
+
+	for e := first; e <= last; e++ {
+		_, err := os.Stat(k.mixPath(e))
+		if err == nil {
+			continue
+		}
+
+		priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to generate mix key. %w", err)
+		}
+
+		c, err := k.CertifyMix(e, [32]byte(priv.PublicKey().Bytes()), now, clock.Start(e+2))
+		if err != nil {
+			return err
+		}
+
+		// the certificate goes first, so there is never a key without one.
+		err = writeFile(k.mixCertPath(e), &pem.Block{Type: certType, Bytes: c.Bytes()})
+		if err != nil {
+			return err
+		}
+
+		err = writeKey(k.mixPath(e), priv, k.passphrase)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+LoadMix reads the mix key of epoch and its certificate, which has to be
+valid at now. The error is an fs.ErrNotExist one if there is no key.
+*/
+func (k *Keys) LoadMix(epoch uint64, now time.Time) (*ecdh.PrivateKey, *cert.Certificate, error) {
+	path := k.mixPath(epoch)
+
+	_, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("no mix key for epoch %d. %w", epoch, err)
+	}
+
+	priv, err := readMix(path, k.passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err := readPEM(k.mixCertPath(epoch), certType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, err := cert.Verify(b, cert.KindMix, k.Public, priv.PublicKey().Bytes(), now)
+	if err == nil && c.Epoch != epoch {
+		err = fmt.Errorf("certificate is for epoch %d", c.Epoch)
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad certificate for mix key of epoch %d. %w", epoch, err)
+	}
+
+	return priv, c, nil
+}
+
+func readMix(path string, passphrase []byte) (*ecdh.PrivateKey, error) {
+	key, err := read(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, ok := key.(*ecdh.PrivateKey)
+	if !ok || priv.Curve() != ecdh.X25519() {
+		return nil, fmt.Errorf("%s is not an x25519 key", path)
+	}
+
+	return priv, nil
+}
+
+/*
+DestroyMix overwrites the mix key of epoch with zeros and removes it and its
+certificate.
+*/
+func (k *Keys) DestroyMix(epoch uint64) error {
+	path := k.mixPath(epoch)
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return k.removeMixCert(epoch)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to open %s. %w", path, err)
+	}
+
+	fi, err := f.Stat()
+	if err == nil {
+		_, err = f.Write(make([]byte, fi.Size()))
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	cerr := f.Close()
+	if err = errors.Join(err, cerr); err != nil {
+		return fmt.Errorf("failed to overwrite %s. %w", path, err)
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		return fmt.Errorf("failed to remove %s. %w", path, err)
+	}
+
+	return k.removeMixCert(epoch)
+}
+
+func (k *Keys) removeMixCert(epoch uint64) error {
+	err := os.Remove(k.mixCertPath(epoch))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove mix certificate. %w", err)
+	}
+
+	return nil
+}
+
+// MixEpochs returns the epochs there are mix keys for, in order.
+func (k *Keys) MixEpochs() ([]uint64, error) {
+	es, err := os.ReadDir(filepath.Join(k.dir, MixDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mix keys. %w", err)
+	}
+
+	var out []uint64
+
+	for _, e := range es {
+		name, ok := strings.CutSuffix(e.Name(), ".key")
+		if !ok {
+			continue
+		}
+
+		epoch, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		out = append(out, epoch)
+	}
+
+	slices.Sort(out)
+
+	return out, nil
+}
@@ -0,0 +1,160 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package keys
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/LibSEA/mixnet/epoch"
+)
+
+func TestMixKeys(t *testing.T) {
+	fastKDF(t)
+
+	dir := t.TempDir()
+	now := epoch.Default.Start(10)
+
+	k, err := Generate(dir, []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = k.MakeMix(epoch.Default, 10, 12, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(k.mixPath(10))
+	if err != nil || !bytes.Contains(b, []byte(encryptedType)) {
+		t.Fatal("mix keys should be encrypted with the passphrase")
+	}
+
+	epochs, err := k.MixEpochs()
+	if err != nil || !slices.Equal(epochs, []uint64{10, 11, 12}) {
+		t.Fatalf("should list the made epochs in order. %v %v", epochs, err)
+	}
+
+	priv, c, err := k.LoadMix(11, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Epoch != 11 || c.Key != [32]byte(priv.PublicKey().Bytes()) || !c.Identity.Equal(k.Public) {
+		t.Fatalf("certificate should be for the key of its epoch. %+v", c)
+	}
+
+	if !c.Expires.Equal(epoch.Default.Start(13)) {
+		t.Fatalf("certificate should outlast the grace window. %s", c.Expires)
+	}
+
+	if _, _, err := k.LoadMix(11, epoch.Default.Start(13)); err == nil {
+		t.Fatal("expired certificate should be rejected")
+	}
+
+	err = os.Remove(filepath.Join(dir, IdentityFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Passwd(dir, []byte("old"), []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offline, err := Load(dir, []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, err := offline.LoadMix(11, now)
+	if err != nil || !got.Equal(priv) {
+		t.Fatalf("passwd should encrypt the mix keys again. %v", err)
+	}
+
+	if err := offline.MakeMix(epoch.Default, 13, 13, now); !errors.Is(err, ErrOffline) {
+		t.Fatalf("mix keys can't be certified without the identity key. %v", err)
+	}
+
+	err = offline.DestroyMix(11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := offline.LoadMix(11, now); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("destroyed key should be gone. got %v", err)
+	}
+
+	if _, err := os.Stat(offline.mixCertPath(11)); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("the certificate should go with the key")
+	}
+
+	if err := offline.DestroyMix(11); err != nil {
+		t.Fatal("destroying a missing key should do nothing")
+	}
+}
+
+func TestPasswdUnreadableMixKey(t *testing.T) {
+	fastKDF(t)
+
+	dir := t.TempDir()
+	now := epoch.Default.Start(10)
+
+	k, err := Generate(dir, []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = k.MakeMix(epoch.Default, 10, 11, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(k.mixPath(11), []byte("not a key"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := make(map[string][]byte)
+	for _, path := range []string{IdentityFile, LinkFile, SigningFile, filepath.Join(MixDir, "10.key")} {
+		before[path], err = os.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Passwd(dir, []byte("old"), []byte("new")); err == nil {
+		t.Fatal("passwd should fail on an unreadable mix key")
+	}
+
+	for path, b := range before {
+		after, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil || !bytes.Equal(after, b) {
+			t.Fatalf("%s should not be rewritten. %v", path, err)
+		}
+	}
+
+	if _, err := Load(dir, []byte("old")); err != nil {
+		t.Fatalf("the keys should still load with the old passphrase. %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package mix
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/LibSEA/mixnet/cert"
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/descriptor"
+	"github.com/LibSEA/mixnet/epoch"
+	"github.com/LibSEA/mixnet/keys"
+	"github.com/LibSEA/mixnet/replay"
+	"github.com/LibSEA/mixnet/sphinx"
+)
+
+/*
+The key ring holds the Sphinx mix keys of the epochs around now. A key is
+made ahead epochs before its epoch starts, so it can be published in
+advance, and saved in the key directory so a restart doesn't lose published
+keys. Making a key needs the identity key to certify it. Without it the
+ring uses the keys made ahead of time with mixnet key mix.
+
+Packets are unwrapped with the key of the current epoch, or with the one of
+the previous epoch until grace into the current one, for packets that were
+on their way at the switch. After that the previous key is destroyed: wiped
+from memory, its file overwritten and removed, and its replay tags dropped.
+See keys.DestroyMix for what overwriting can't reach.
+*/
+type keyring struct {
+	mu       sync.Mutex
+	files    *keys.Keys
+	storage  replay.Storage
+	clock    clock.Clock
+	epochs   epoch.Clock
+	ahead    int
+	grace    time.Duration
+	capacity int
+	logger   *slog.Logger
+	keys     map[uint64]*mixKey
+	// started is set after the first rotation.
+	started bool
+}
+
+type mixKey struct {
+	epoch  uint64
+	pair   *sphinx.KeyPair
+	cert   *cert.Certificate
+	replay *replay.Cache
+}
+
+/*
+rotate makes the missing keys up to ahead epochs from now and destroys the
+keys whose grace window is over. It reports whether the keys changed and
+returns when it should run again.
+
+The first rotation also picks up the previous epoch's key if the grace
+window is still open, and destroys the saved keys of epochs that ended while
+the mix was down.
+*/
+func (r *keyring) rotate() (time.Time, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	cur := r.epochs.At(now)
+	inGrace := cur > 0 && now.Before(r.epochs.Start(cur).Add(r.grace))
+	changed := false
+
+	if !r.started {
+		err := r.resume(cur, inGrace, now)
+		if err != nil {
+			return now.Add(time.Second), changed, err
+		}
+
+		r.started = true
+	}
+
+	for e, k := range r.keys {
+		if e+1 < cur || (e+1 == cur && !inGrace) {
+			err := r.destroy(k)
+			if err != nil {
+				return now.Add(time.Second), changed, err
+			}
+
+			changed = true
+		}
+	}
+
+	for e := cur; e <= cur+uint64(r.ahead); e++ {
+		if _, ok := r.keys[e]; ok {
+			continue
+		}
+
+		k, err := r.key(e, now, true)
+		// a mix without its identity key can run until it is out of keys.
+		if errors.Is(err, keys.ErrOffline) && e > cur {
+			r.logger.Warn("no mix key", "epoch", e, "error", err)
+			continue
+		}
+
+		if err != nil {
+			return now.Add(time.Second), changed, err
+		}
+
+		r.keys[e] = k
+		changed = true
+	}
+
+	next := r.epochs.Start(cur + 1)
+	if _, ok := r.keys[cur-1]; ok && cur > 0 {
+		next = r.epochs.Start(cur).Add(r.grace)
+	}
+
+	return next, changed, nil
+}
+
+/*
+resume loads the saved key of the previous epoch while packets for it are
+still accepted, and destroys the saved keys that are too old for that.
+*/
+func (r *keyring) resume(cur uint64, inGrace bool, now time.Time) error {
+	saved, err := r.files.MixEpochs()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range saved {
+		if e >= cur {
+			break
+		}
+
+		if e+1 == cur && inGrace {
+			k, err := r.key(e, now, false)
+			if err != nil {
+				return err
+			}
+
+			r.keys[e] = k
+			continue
+		}
+
+		// its certificate may be expired, but it was valid in its epoch.
+		k, err := r.key(e, r.epochs.Start(e+1).Add(-time.Second), false)
+		if err != nil {
+			return err
+		}
+
+		err = r.destroy(k)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+key loads the saved key of epoch e, with a certificate valid at now, or
+makes and certifies a new one if create is set.
+*/
+func (r *keyring) key(e uint64, now time.Time, create bool) (*mixKey, error) {
+	priv, c, err := r.files.LoadMix(e, now)
+	if errors.Is(err, fs.ErrNotExist) && create {
+		err = r.files.MakeMix(r.epochs, e, e, now)
+		if errors.Is(err, keys.ErrOffline) {
+			return nil, fmt.Errorf("failed to make mix key, make them with mixnet key mix. %w", err)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to make mix key. %w", err)
+		}
+
+		priv, c, err = r.files.LoadMix(e, now)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mix key. %w", err)
+	}
+
+	pair, err := sphinx.GenerateKey(bytes.NewReader(priv.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mix key. %w", err)
+	}
+
+	// tags are only valid for the key they were made with.
+	cache, err := replay.New(
+		r.storage,
+		r.clock,
+		fmt.Appendf(nil, "replay/%x/", pair.Public[:8]),
+		r.epochs.Period+r.grace,
+		r.capacity,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	r.logger.Info("mix key", "epoch", e, "key", hex.EncodeToString(pair.Public[:]))
+
+	return &mixKey{
+		epoch:  e,
+		pair:   pair,
+		cert:   c,
+		replay: cache,
+	}, nil
+}
+
+// destroy forgets k, erases its file and drops its replay tags.
+func (r *keyring) destroy(k *mixKey) error {
+	err := r.files.DestroyMix(k.epoch)
+	if err != nil {
+		return fmt.Errorf("failed to delete mix key. %w", err)
+	}
+
+	err = k.replay.Drop()
+	if err != nil {
+		return err
+	}
+
+	clear(k.pair.Private[:])
+	delete(r.keys, k.epoch)
+
+	r.logger.Info("destroyed mix key", "epoch", k.epoch)
+
+	return nil
+}
+
+// usable returns the keys packets can be unwrapped with now, newest first.
+func (r *keyring) usable() []*mixKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	cur := r.epochs.At(now)
+
+	var out []*mixKey
+
+	if k, ok := r.keys[cur]; ok {
+		out = append(out, k)
+	}
+
+	if k, ok := r.keys[cur-1]; ok && cur > 0 && now.Before(r.epochs.Start(cur).Add(r.grace)) {
+		out = append(out, k)
+	}
+
+	return out
+}
+
+// public returns the public keys from the current epoch on, to publish.
+func (r *keyring) public() []descriptor.MixKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cur := r.epochs.At(r.clock.Now())
+
+	var out []descriptor.MixKey
+
+	for e := cur; e <= cur+uint64(r.ahead); e++ {
+		if k, ok := r.keys[e]; ok {
+			out = append(out, descriptor.MixKey{Epoch: e, Key: k.pair.Public, Cert: k.cert})
+		}
+	}
+
+	return out
+}
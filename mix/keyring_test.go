@@ -0,0 +1,212 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package mix
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/epoch"
+	"github.com/LibSEA/mixnet/keys"
+	"github.com/LibSEA/mixnet/sphinx"
+	"github.com/LibSEA/mixnet/store"
+)
+
+func newKeys(t *testing.T) *keys.Keys {
+	t.Helper()
+
+	k, err := keys.Generate(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+func newKeyring(clk clock.Clock, k *keys.Keys, s *store.Memory) *keyring {
+	return &keyring{
+		files:    k,
+		storage:  s,
+		clock:    clk,
+		epochs:   epoch.Default,
+		ahead:    2,
+		grace:    5 * time.Minute,
+		capacity: 1000,
+		logger:   slog.New(slog.DiscardHandler),
+		keys:     make(map[uint64]*mixKey),
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	clk := clock.NewFake(epoch.Default.Start(10).Add(10 * time.Minute))
+	s := store.NewMemory(clk)
+	k := newKeys(t)
+	sut := newKeyring(clk, k, s)
+
+	next, changed, err := sut.rotate()
+	if err != nil || !changed {
+		t.Fatalf("first rotation should make keys. %v", err)
+	}
+
+	if !next.Equal(epoch.Default.Start(11)) {
+		t.Fatalf("should wake at the next epoch. %s", next)
+	}
+
+	pub := sut.public()
+	if len(pub) != 3 || pub[0].Epoch != 10 || pub[2].Epoch != 12 {
+		t.Fatalf("should publish keys for this and the next 2 epochs. %+v", pub)
+	}
+
+	if _, changed, _ := sut.rotate(); changed {
+		t.Fatal("keys should not change within an epoch")
+	}
+
+	// a restart loads the saved keys.
+	restarted := newKeyring(clk, k, s)
+	if _, _, err := restarted.rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if restarted.keys[11].pair.Public != sut.keys[11].pair.Public {
+		t.Fatal("a restarted mix should keep its published keys")
+	}
+
+	old := sut.keys[10]
+	seen, err := old.replay.Check([]byte("tag"))
+	if err != nil || seen {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get(fmt.Appendf(nil, "replay/%x/tag", old.pair.Public[:8])); err != nil {
+		t.Fatal("replay tags should be stored per key")
+	}
+
+	clk.Set(epoch.Default.Start(11).Add(time.Minute))
+
+	next, _, err = sut.rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !next.Equal(epoch.Default.Start(11).Add(5 * time.Minute)) {
+		t.Fatalf("should wake at the end of the grace window. %s", next)
+	}
+
+	usable := sut.usable()
+	if len(usable) != 2 || usable[0].epoch != 11 || usable[1].epoch != 10 {
+		t.Fatal("the previous key should work during the grace window")
+	}
+
+	if pub := sut.public(); len(pub) != 3 || pub[0].Epoch != 11 || pub[2].Epoch != 13 {
+		t.Fatalf("a key for a new epoch should be made. %+v", pub)
+	}
+
+	clk.Set(epoch.Default.Start(11).Add(5 * time.Minute))
+
+	if _, _, err := sut.rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if usable := sut.usable(); len(usable) != 1 || usable[0].epoch != 11 {
+		t.Fatal("the previous key should be gone after the grace window")
+	}
+
+	if old.pair.Private != [sphinx.KeySize]byte{} {
+		t.Fatal("the destroyed key should be wiped")
+	}
+
+	if _, _, err := k.LoadMix(10, clk.Now()); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("the destroyed key should be deleted")
+	}
+
+	if _, err := s.Get(fmt.Appendf(nil, "replay/%x/tag", old.pair.Public[:8])); err == nil {
+		t.Fatal("the replay tags of the destroyed key should be deleted")
+	}
+}
+
+func TestKeyringRestart(t *testing.T) {
+	clk := clock.NewFake(epoch.Default.Start(10).Add(10 * time.Minute))
+	s := store.NewMemory(clk)
+	k := newKeys(t)
+
+	if _, _, err := newKeyring(clk, k, s).rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// down for an epoch, back within the grace window of epoch 12.
+	clk.Set(epoch.Default.Start(12).Add(time.Minute))
+
+	sut := newKeyring(clk, k, s)
+	if _, _, err := sut.rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	usable := sut.usable()
+	if len(usable) != 2 || usable[1].epoch != 11 {
+		t.Fatal("the previous key should be loaded during the grace window")
+	}
+
+	if _, _, err := k.LoadMix(10, clk.Now()); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("keys past their grace window should be destroyed on start")
+	}
+
+	clk.Set(epoch.Default.Start(12).Add(5 * time.Minute))
+
+	if _, _, err := sut.rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := k.LoadMix(11, clk.Now()); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal("the previous key should be destroyed after the grace window")
+	}
+}
+
+func TestKeyringOffline(t *testing.T) {
+	clk := clock.NewFake(epoch.Default.Start(10).Add(10 * time.Minute))
+	s := store.NewMemory(clk)
+	k := newKeys(t)
+
+	// keys for epochs 10 and 11 are made while the identity key is there.
+	err := k.MakeMix(epoch.Default, 10, 11, clk.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k.Identity = nil
+	sut := newKeyring(clk, k, s)
+
+	if _, _, err := sut.rotate(); err != nil {
+		t.Fatalf("a missing key ahead should not stop the mix. %v", err)
+	}
+
+	pub := sut.public()
+	if len(pub) != 2 || pub[1].Epoch != 11 || pub[1].Cert == nil || pub[1].Cert.Epoch != 11 {
+		t.Fatalf("should publish the certified keys it has. %+v", pub)
+	}
+
+	clk.Set(epoch.Default.Start(12))
+
+	if _, _, err := sut.rotate(); !errors.Is(err, keys.ErrOffline) {
+		t.Fatalf("no key for the current epoch should fail. %v", err)
+	}
+}
@@ -0,0 +1,606 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package mix
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/LibSEA/mixnet/cert"
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/descriptor"
+	"github.com/LibSEA/mixnet/epoch"
+	"github.com/LibSEA/mixnet/keys"
+	"github.com/LibSEA/mixnet/mixer"
+	"github.com/LibSEA/mixnet/pki"
+	"github.com/LibSEA/mixnet/session"
+	"github.com/LibSEA/mixnet/sphinx"
+	"github.com/LibSEA/mixnet/store"
+	"github.com/flynn/noise"
+)
+
+type Options struct {
+	Port uint16
+	Host string
+	// MaxDelay caps the delay a sender can ask for.
+	MaxDelay time.Duration
+	// Strategy is the name of the mixer, see mixer.Strategies.
+	Strategy string
+	Mixer    mixer.Config
+	// Tick is how often the mixer is asked for packets that are due.
+	Tick time.Duration
+	// DB is the directory of the database holding replay tags.
+	DB string
+	// ReplayCapacity is about how many packets arrive in one epoch.
+	ReplayCapacity int
+	// Ahead is how many epochs ahead mix keys are made and published.
+	Ahead int
+	// Grace is how long into an epoch packets for the previous epoch's key
+	// are still accepted.
+	Grace time.Duration
+	// PKI are the directory authorities the descriptor is uploaded to.
+	PKI []string
+	// Addr is the ip:port published in the descriptor. It defaults to the
+	// address listened on.
+	Addr string
+	// Layer is the mix layer published in the descriptor.
+	Layer uint8
+	// Keys is where the keys are loaded from, see the keys package.
+	Keys keys.Source
+}
+
+type cmd struct {
+	logger *slog.Logger
+	opts   Options
+	cs     noise.CipherSuite
+	kp     noise.DHKey
+	keys   *keys.Keys
+	ring   *keyring
+	addr   netip.AddrPort
+
+	mu    sync.Mutex
+	peers map[netip.AddrPort]*peer
+
+	mixMu sync.Mutex
+	mixer mixer.Mixer[outbound]
+
+	// sending tracks packets on their way to the next hop.
+	sending sync.WaitGroup
+}
+
+var errNoKey = errors.New("no mix key for this epoch")
+
+// dialTimeout bounds connecting and handshaking with the next hop.
+const dialTimeout = 10 * time.Second
+
+type outbound struct {
+	next netip.AddrPort
+	p    *sphinx.Packet
+}
+
+// peer is an outbound session to the next hop.
+type peer struct {
+	// ready is closed once the handshake is done, err is how it went.
+	ready chan struct{}
+	err   error
+
+	mu  sync.Mutex
+	s   *session.Session
+	buf []byte
+}
+
+func (c *cmd) handle(s *session.Session) {
+	defer func() { _ = s.Close() }()
+
+	var buf = make([]byte, math.MaxInt16)
+
+	err := s.ServerHandshake(buf)
+	if err != nil {
+		c.logger.Warn("ServerHandshake failed.", "error", err)
+		return
+	}
+
+	for {
+		msg, err := s.ReadMessage(buf)
+		if err != nil {
+			c.logger.Warn("ReadMessage failed", "error", err)
+			return
+		}
+
+		c.process(msg)
+	}
+}
+
+func (c *cmd) process(msg []byte) {
+	p, err := sphinx.Parse(msg)
+	if err != nil {
+		c.logger.Warn("dropping malformed packet", "error", err)
+		return
+	}
+
+	var (
+		res *sphinx.Result
+		key *mixKey
+	)
+
+	err = errNoKey
+
+	// near the start of an epoch the packet may be for the previous key.
+	for _, key = range c.ring.usable() {
+		res, err = sphinx.Unwrap(&key.pair.Private, p)
+		if !errors.Is(err, sphinx.ErrInvalidMAC) {
+			break
+		}
+	}
+
+	if res == nil {
+		c.logger.Warn("dropping packet", "error", err)
+		return
+	}
+
+	seen, err := key.replay.Check(res.Tag[:])
+	if err != nil {
+		c.logger.Error("replay check failed", "error", err)
+		return
+	}
+
+	if seen {
+		c.logger.Warn("dropping replayed packet")
+		return
+	}
+
+	switch res.Command {
+	case sphinx.CommandRelay:
+		c.mixMu.Lock()
+		out := c.mixer.Enqueue(
+			outbound{next: res.Next, p: res.Packet},
+			min(res.Delay, c.opts.MaxDelay),
+		)
+		c.mixMu.Unlock()
+
+		c.send(out)
+	case sphinx.CommandDeliver:
+		m, err := sphinx.DecodePayload(res.Packet.Payload[:])
+		if err != nil {
+			c.logger.Warn("dropping packet", "error", err)
+			return
+		}
+
+		surb, body, err := sphinx.DecodeMessage(m)
+		if err != nil {
+			c.logger.Warn("dropping packet", "error", err)
+			return
+		}
+
+		// the body is the sender's plaintext, it stays out of the logs.
+		c.logger.Debug(
+			"delivered",
+			"id", hex.EncodeToString(res.ID[:]),
+			"length", len(body),
+			"surb", surb != nil,
+		)
+
+		if surb != nil {
+			c.echo(surb, body, min(res.Delay, c.opts.MaxDelay))
+		}
+	}
+}
+
+/*
+echo sends body back to the sender through surb. The reply is mixed like a
+relayed packet, sending it at once would tie it to the delivery.
+*/
+func (c *cmd) echo(surb *sphinx.SURB, body []byte, delay time.Duration) {
+	p, err := sphinx.NewReply(surb, body)
+	if err != nil {
+		c.logger.Warn("couldn't make reply", "error", err)
+		return
+	}
+
+	c.mixMu.Lock()
+	out := c.mixer.Enqueue(outbound{next: surb.FirstHop, p: p}, delay)
+	c.mixMu.Unlock()
+
+	c.send(out)
+}
+
+func (c *cmd) tick(ctx context.Context) {
+	t := time.NewTicker(c.opts.Tick)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		c.mixMu.Lock()
+		out := c.mixer.Tick()
+		c.mixMu.Unlock()
+
+		c.send(out)
+	}
+}
+
+// flush sends whatever the mixer still holds and waits for it to go out.
+func (c *cmd) flush() {
+	c.mixMu.Lock()
+	out := c.mixer.Flush()
+	c.mixMu.Unlock()
+
+	c.send(out)
+	c.sending.Wait()
+}
+
+func (c *cmd) send(out []outbound) {
+	if len(out) == 0 {
+		return
+	}
+
+	c.sending.Go(func() {
+		for _, o := range out {
+			c.forward(o.next, o.p)
+		}
+	})
+}
+
+func (c *cmd) forward(addr netip.AddrPort, p *sphinx.Packet) {
+	pr, err := c.peer(addr)
+	if err != nil {
+		c.logger.Warn("couldn't connect to next hop", "addr", addr, "error", err)
+		return
+	}
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	err = pr.s.WriteMessage(pr.buf, p.Bytes())
+	if err != nil {
+		c.logger.Warn("failed forwarding packet", "addr", addr, "error", err)
+		c.dropPeer(addr, pr)
+	}
+}
+
+/*
+peer returns the session to addr, connecting if there is none. The dial and
+handshake happen outside c.mu so a slow next hop only holds up the packets
+going to it; callers for the same addr wait on the one in flight.
+*/
+func (c *cmd) peer(addr netip.AddrPort) (*peer, error) {
+	c.mu.Lock()
+	pr, ok := c.peers[addr]
+	if !ok {
+		pr = &peer{ready: make(chan struct{})}
+		c.peers[addr] = pr
+	}
+	c.mu.Unlock()
+
+	if ok {
+		<-pr.ready
+		if pr.err != nil {
+			return nil, pr.err
+		}
+		return pr, nil
+	}
+
+	pr.err = c.connect(addr, pr)
+	if pr.err != nil {
+		c.mu.Lock()
+		delete(c.peers, addr)
+		c.mu.Unlock()
+	}
+	close(pr.ready)
+
+	if pr.err != nil {
+		return nil, pr.err
+	}
+
+	return pr, nil
+}
+
+func (c *cmd) connect(addr netip.AddrPort, pr *peer) error {
+	d := net.Dialer{Timeout: dialTimeout}
+
+	conn, err := d.Dial("tcp", addr.String())
+	if err != nil {
+		return err
+	}
+
+	pr.s = session.New(conn, c.cs, c.kp)
+	pr.buf = make([]byte, math.MaxInt16)
+
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	err = pr.s.ClientHandshake(pr.buf)
+	if err != nil {
+		_ = pr.s.Close()
+		return err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	return nil
+}
+
+func (c *cmd) dropPeer(addr netip.AddrPort, pr *peer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.peers[addr] == pr {
+		delete(c.peers, addr)
+	}
+
+	_ = pr.s.Close()
+}
+
+// rotate keeps the mix keys rotating, from next on, until ctx is done.
+func (c *cmd) rotate(ctx context.Context, next time.Time) {
+	for {
+		t := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		var (
+			changed bool
+			err     error
+		)
+
+		next, changed, err = c.ring.rotate()
+		if err != nil {
+			c.logger.Error("couldn't rotate mix keys", "error", err)
+		}
+
+		if changed {
+			c.publish()
+		}
+	}
+}
+
+// publish uploads a descriptor with the current mix keys to the pki.
+func (c *cmd) publish() {
+	if len(c.opts.PKI) == 0 {
+		return
+	}
+
+	now := time.Now()
+	mixKeys := c.ring.public()
+
+	if len(mixKeys) == 0 {
+		c.logger.Error("couldn't publish descriptor", "error", fmt.Errorf("no mix keys"))
+		return
+	}
+
+	// the descriptor lasts as long as its last key and its certificates.
+	expires := epoch.Default.Start(mixKeys[len(mixKeys)-1].Epoch + 1)
+	for _, ct := range []*cert.Certificate{c.keys.SigningCert, c.keys.LinkCert} {
+		if ct.Expires.Before(expires) {
+			expires = ct.Expires
+		}
+	}
+
+	d := descriptor.Descriptor{
+		LinkKey:     [32]byte(c.kp.Public),
+		LinkCert:    c.keys.LinkCert,
+		SigningCert: c.keys.SigningCert,
+		MixKeys:     mixKeys,
+		Addrs:       []netip.AddrPort{c.addr},
+		Role:        descriptor.RoleMix,
+		Layer:       c.opts.Layer,
+		Published:   now.Truncate(time.Second),
+		Expires:     expires,
+	}
+
+	err := descriptor.Sign(c.keys.Signing, &d)
+	if err != nil {
+		c.logger.Error("couldn't sign descriptor", "error", err)
+		return
+	}
+
+	for _, addr := range c.opts.PKI {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			err := pki.Upload(ctx, addr, c.kp, &d)
+			if err != nil {
+				c.logger.Warn("couldn't upload descriptor", "pki", addr, "error", err)
+			}
+		}()
+	}
+}
+
+/*
+advertised returns addr, or the address listened on if addr is empty, as
+the address to publish.
+*/
+func advertised(addr string, listen net.Addr) (netip.AddrPort, error) {
+	a := listen.(*net.TCPAddr).AddrPort()
+	if addr != "" {
+		var err error
+
+		a, err = netip.ParseAddrPort(addr)
+		if err != nil {
+			return a, fmt.Errorf("bad address. %w", err)
+		}
+	}
+
+	a = netip.AddrPortFrom(a.Addr().Unmap(), a.Port())
+	if a.Addr().IsUnspecified() {
+		return a, fmt.Errorf("listening on %s, give the address to publish", a)
+	}
+
+	return a, nil
+}
+
+func Run(opts Options) int {
+	var c = cmd{
+		logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		opts:   opts,
+		peers:  make(map[netip.AddrPort]*peer),
+	}
+	ln, err := net.Listen(
+		"tcp",
+		net.JoinHostPort(
+			opts.Host,
+			strconv.Itoa(int(opts.Port))),
+	)
+	if err != nil {
+		c.logger.Error(
+			"couldn't listen",
+			"port", opts.Port,
+			"host", opts.Host,
+		)
+		return 1
+	}
+
+	c.cs = noise.NewCipherSuite(
+		noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b,
+	)
+	k, err := opts.Keys.Load()
+	if err != nil {
+		c.logger.Error("couldn't load keys.", "error", err)
+		return 1
+	}
+	c.kp = k.Link
+	c.keys = k
+
+	if opts.Ahead < 0 || opts.Ahead >= descriptor.MaxMixKeys {
+		c.logger.Error("bad options.", "error", fmt.Errorf("ahead must be below %d", descriptor.MaxMixKeys))
+		return 1
+	}
+
+	if opts.Grace < 0 || opts.Grace >= epoch.Period {
+		c.logger.Error("bad options.", "error", fmt.Errorf("grace must be shorter than an epoch"))
+		return 1
+	}
+
+	if opts.ReplayCapacity <= 0 {
+		c.logger.Error("bad options.", "error", fmt.Errorf("replay capacity must be positive"))
+		return 1
+	}
+
+	if len(opts.PKI) > 0 {
+		c.addr, err = advertised(opts.Addr, ln.Addr())
+		if err != nil {
+			c.logger.Error("bad options.", "error", err)
+			return 1
+		}
+	}
+
+	db, err := store.Open(opts.DB)
+	if err != nil {
+		c.logger.Error("couldn't open database.", "error", err)
+		return 1
+	}
+	defer func() { _ = db.Close() }()
+
+	c.ring = &keyring{
+		files:    k,
+		storage:  db,
+		clock:    clock.Real{},
+		epochs:   epoch.Default,
+		ahead:    opts.Ahead,
+		grace:    opts.Grace,
+		capacity: opts.ReplayCapacity,
+		logger:   c.logger,
+		keys:     make(map[uint64]*mixKey),
+	}
+
+	next, _, err := c.ring.rotate()
+	if err != nil {
+		c.logger.Error("couldn't make mix keys.", "error", err)
+		return 1
+	}
+
+	c.publish()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	// the key ring uses db, so rotation stops before it is closed.
+	var rotating sync.WaitGroup
+	defer func() {
+		stop()
+		rotating.Wait()
+	}()
+
+	rotating.Go(func() { c.rotate(ctx, next) })
+
+	c.mixer, err = mixer.New[outbound](opts.Strategy, opts.Mixer)
+	if err != nil {
+		c.logger.Error("bad mixer options.", "error", err)
+		return 1
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	go c.tick(ctx)
+
+	cf := 0
+
+	c.logger.Info(
+		"started",
+		"identity", hex.EncodeToString(k.Public),
+		"link_key", hex.EncodeToString(c.kp.Public),
+		"strategy", opts.Strategy,
+	)
+
+	for {
+		if cf > 10 {
+			c.logger.Error("failed calling accept to many times.")
+			return 1
+		}
+		conn, err := ln.Accept()
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			c.logger.Error("error calling accept", "error", err)
+			cf++
+			continue
+		}
+		cf = 0
+		go c.handle(session.New(conn, c.cs, c.kp))
+	}
+
+	c.flush()
+
+	c.logger.Info("stopped")
+
+	return 0
+}
@@ -0,0 +1,237 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package mix
+
+import (
+	"bytes"
+	"crypto/rand"
+	"log/slog"
+	"math"
+	mrand "math/rand/v2"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/mixer"
+	"github.com/LibSEA/mixnet/session"
+	"github.com/LibSEA/mixnet/sphinx"
+	"github.com/flynn/noise"
+)
+
+func TestPeerDialOutsideLock(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	kp, err := cs.GenerateKeypair(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cmd{
+		logger: slog.New(slog.DiscardHandler),
+		cs:     cs,
+		kp:     kp,
+		peers:  make(map[netip.AddrPort]*peer),
+	}
+
+	// a next hop that accepts but never answers the handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr).AddrPort()
+
+	done := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := c.peer(addr)
+			done <- err
+		}()
+	}
+
+	var conn net.Conn
+	select {
+	case conn = <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("peer never dialed")
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		c.mu.Lock()
+		c.mu.Unlock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("the handshake shouldn't hold the peers lock")
+	}
+
+	_ = conn.Close()
+
+	for range 2 {
+		if err := <-done; err == nil {
+			t.Fatal("the failed handshake should fail every caller")
+		}
+	}
+
+	if len(c.peers) != 0 {
+		t.Fatal("a failed peer should be forgotten")
+	}
+}
+
+// nextHop listens for one session and passes on the first message it reads.
+func nextHop(t *testing.T, cs noise.CipherSuite, kp noise.DHKey) (netip.AddrPort, chan []byte) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	got := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s := session.New(conn, cs, kp)
+		defer func() { _ = s.Close() }()
+
+		buf := make([]byte, math.MaxInt16)
+		if s.ServerHandshake(buf) != nil {
+			return
+		}
+
+		msg, err := s.ReadMessage(buf)
+		if err == nil {
+			got <- bytes.Clone(msg)
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).AddrPort(), got
+}
+
+func TestFlush(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	kp, err := cs.GenerateKeypair(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, got := nextHop(t, cs, kp)
+
+	hop, err := sphinx.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := sphinx.NewPacket(
+		[]sphinx.Hop{{PublicKey: hop.Public, Addr: addr}},
+		[sphinx.IDSize]byte{},
+		[]byte("hi"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cmd{
+		logger: slog.New(slog.DiscardHandler),
+		cs:     cs,
+		kp:     kp,
+		peers:  make(map[netip.AddrPort]*peer),
+		// the batch never fills so only Flush sends the packet.
+		mixer: mixer.NewThreshold[outbound](mrand.New(mrand.NewPCG(1, 2)), 10),
+	}
+
+	if out := c.mixer.Enqueue(outbound{next: addr, p: p}, 0); len(out) != 0 {
+		t.Fatal("the batch shouldn't be sent yet")
+	}
+
+	c.flush()
+
+	select {
+	case msg := <-got:
+		if !bytes.Equal(msg, p.Bytes()) {
+			t.Fatal("the next hop got the wrong packet")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("flush didn't send the held packet")
+	}
+}
+
+func TestEchoMixed(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	kp, err := cs.GenerateKeypair(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, got := nextHop(t, cs, kp)
+
+	hop, err := sphinx.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	surb, err := sphinx.NewReplyTable(clock.Real{}, time.Hour).NewSURB(
+		[]sphinx.Hop{{PublicKey: hop.Public, Addr: addr}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cmd{
+		logger: slog.New(slog.DiscardHandler),
+		cs:     cs,
+		kp:     kp,
+		peers:  make(map[netip.AddrPort]*peer),
+		mixer:  mixer.NewThreshold[outbound](mrand.New(mrand.NewPCG(1, 2)), 10),
+	}
+
+	c.echo(surb, []byte("hi"), 0)
+
+	select {
+	case <-got:
+		t.Fatal("the reply should wait in the mixer")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.flush()
+
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("flush didn't send the reply")
+	}
+}
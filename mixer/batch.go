@@ -0,0 +1,168 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package mixer
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+)
+
+// Threshold sends every packet in random order once it holds n of them.
+type Threshold[T any] struct {
+	pool pool[T]
+	n    int
+}
+
+func NewThreshold[T any](rng *rand.Rand, n int) *Threshold[T] {
+	return &Threshold[T]{pool: pool[T]{rng: rng}, n: n}
+}
+
+func (m *Threshold[T]) Enqueue(v T, _ time.Duration) []T {
+	m.pool.add(v)
+
+	if len(m.pool.items) < m.n {
+		return nil
+	}
+
+	return m.pool.all()
+}
+
+func (m *Threshold[T]) Tick() []T {
+	return nil
+}
+
+func (m *Threshold[T]) Flush() []T {
+	return m.pool.all()
+}
+
+// Timed sends everything it holds in random order once every interval.
+type Timed[T any] struct {
+	pool     pool[T]
+	interval interval
+}
+
+func NewTimed[T any](c clock.Clock, rng *rand.Rand, period time.Duration) *Timed[T] {
+	return &Timed[T]{
+		pool:     pool[T]{rng: rng},
+		interval: newInterval(c, period),
+	}
+}
+
+func (m *Timed[T]) Enqueue(v T, _ time.Duration) []T {
+	m.pool.add(v)
+	return nil
+}
+
+func (m *Timed[T]) Tick() []T {
+	if !m.interval.fired() {
+		return nil
+	}
+
+	return m.pool.all()
+}
+
+func (m *Timed[T]) Flush() []T {
+	return m.pool.all()
+}
+
+/*
+TimedPool fires once every interval like Timed but always keeps n packets
+back, chosen at random. A packet can stay in the pool for any number of
+rounds, which hides the link between rounds.
+*/
+type TimedPool[T any] struct {
+	pool     pool[T]
+	interval interval
+	n        int
+}
+
+func NewTimedPool[T any](
+	c clock.Clock,
+	rng *rand.Rand,
+	period time.Duration,
+	n int,
+) *TimedPool[T] {
+	return &TimedPool[T]{
+		pool:     pool[T]{rng: rng},
+		interval: newInterval(c, period),
+		n:        n,
+	}
+}
+
+func (m *TimedPool[T]) Enqueue(v T, _ time.Duration) []T {
+	m.pool.add(v)
+	return nil
+}
+
+func (m *TimedPool[T]) Tick() []T {
+	if !m.interval.fired() {
+		return nil
+	}
+
+	return m.pool.take(len(m.pool.items) - m.n)
+}
+
+func (m *TimedPool[T]) Flush() []T {
+	return m.pool.all()
+}
+
+// Binomial fires once every interval and sends each packet with chance p.
+type Binomial[T any] struct {
+	pool     pool[T]
+	interval interval
+	p        float64
+}
+
+func NewBinomial[T any](
+	c clock.Clock,
+	rng *rand.Rand,
+	period time.Duration,
+	p float64,
+) *Binomial[T] {
+	return &Binomial[T]{
+		pool:     pool[T]{rng: rng},
+		interval: newInterval(c, period),
+		p:        p,
+	}
+}
+
+func (m *Binomial[T]) Enqueue(v T, _ time.Duration) []T {
+	m.pool.add(v)
+	return nil
+}
+
+func (m *Binomial[T]) Tick() []T {
+	if !m.interval.fired() {
+		return nil
+	}
+
+	n := 0
+	for range m.pool.items {
+		if m.pool.rng.Float64() < m.p {
+			n++
+		}
+	}
+
+	return m.pool.take(n)
+}
+
+func (m *Binomial[T]) Flush() []T {
+	return m.pool.all()
+}
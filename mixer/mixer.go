@@ -0,0 +1,171 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package mixer
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+)
+
+/*
+Mixer decides when the packets a node has received are sent on. The node
+calls Enqueue for every packet, Tick on a short timer and Flush when shutting
+down. Every call returns the packets to send now, in the order to send them.
+
+Implementations aren't safe for concurrent use.
+*/
+type Mixer[T any] interface {
+	// Enqueue adds v. delay is the delay the sender asked for, strategies
+	// that work in batches ignore it.
+	Enqueue(v T, delay time.Duration) []T
+	Tick() []T
+	Flush() []T
+}
+
+type Config struct {
+	Clock clock.Clock
+	Rand  *rand.Rand
+
+	// Threshold is the batch size of the threshold mix.
+	Threshold int
+	// Interval is how often timed mixes fire.
+	Interval time.Duration
+	// Pool is how many packets the timed pool mix keeps back.
+	Pool int
+	// Prob is the chance the binomial mix sends each pooled packet.
+	Prob float64
+	// Delay is the mean delay stop-and-go uses when the sender picked none.
+	Delay time.Duration
+}
+
+const (
+	StrategyThreshold = "threshold"
+	StrategyTimed     = "timed"
+	StrategyTimedPool = "timed-pool"
+	StrategyBinomial  = "binomial"
+	StrategyStopAndGo = "stop-and-go"
+)
+
+var Strategies = []string{
+	StrategyThreshold,
+	StrategyTimed,
+	StrategyTimedPool,
+	StrategyBinomial,
+	StrategyStopAndGo,
+}
+
+func New[T any](strategy string, cfg Config) (Mixer[T], error) {
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real{}
+	}
+
+	if cfg.Rand == nil {
+		cfg.Rand = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+
+	switch strategy {
+	case StrategyThreshold:
+		if cfg.Threshold < 1 {
+			return nil, fmt.Errorf("threshold must be at least 1")
+		}
+		return NewThreshold[T](cfg.Rand, cfg.Threshold), nil
+	case StrategyTimed:
+		if cfg.Interval <= 0 {
+			return nil, fmt.Errorf("interval must be positive")
+		}
+		return NewTimed[T](cfg.Clock, cfg.Rand, cfg.Interval), nil
+	case StrategyTimedPool:
+		if cfg.Interval <= 0 || cfg.Pool < 0 {
+			return nil, fmt.Errorf("interval must be positive and pool not negative")
+		}
+		return NewTimedPool[T](cfg.Clock, cfg.Rand, cfg.Interval, cfg.Pool), nil
+	case StrategyBinomial:
+		if cfg.Interval <= 0 || cfg.Prob <= 0 || cfg.Prob > 1 {
+			return nil, fmt.Errorf("interval must be positive and prob in (0, 1]")
+		}
+		return NewBinomial[T](cfg.Clock, cfg.Rand, cfg.Interval, cfg.Prob), nil
+	case StrategyStopAndGo:
+		if cfg.Delay < 0 {
+			return nil, fmt.Errorf("delay can't be negative")
+		}
+		return NewStopAndGo[T](cfg.Clock, cfg.Rand, cfg.Delay), nil
+	}
+
+	return nil, fmt.Errorf("unknown mixing strategy %q", strategy)
+}
+
+// pool holds packets for the batching strategies.
+type pool[T any] struct {
+	items []T
+	rng   *rand.Rand
+}
+
+func (p *pool[T]) add(v T) {
+	p.items = append(p.items, v)
+}
+
+// take removes k packets picked at random.
+func (p *pool[T]) take(k int) []T {
+	if k <= 0 {
+		return nil
+	}
+
+	p.rng.Shuffle(len(p.items), func(i, j int) {
+		p.items[i], p.items[j] = p.items[j], p.items[i]
+	})
+
+	out := p.items[:k:k]
+	p.items = append([]T(nil), p.items[k:]...)
+
+	return out
+}
+
+func (p *pool[T]) all() []T {
+	return p.take(len(p.items))
+}
+
+// interval fires once every period of the clock.
+type interval struct {
+	clock  clock.Clock
+	period time.Duration
+	next   time.Time
+}
+
+func newInterval(c clock.Clock, period time.Duration) interval {
+	return interval{
+		clock:  c,
+		period: period,
+		next:   c.Now().Add(period),
+	}
+}
+
+func (i *interval) fired() bool {
+	now := i.clock.Now()
+	if now.Before(i.next) {
+		return false
+	}
+
+	for !now.Before(i.next) {
+		i.next = i.next.Add(i.period)
+	}
+
+	return true
+}
@@ -0,0 +1,200 @@
+package mixer
+
+import (
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+)
+
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func testRand() *rand.Rand {
+	return rand.New(rand.NewPCG(1, 2))
+}
+
+func sorted(s []int) []int {
+	s = slices.Clone(s)
+	slices.Sort(s)
+	return s
+}
+
+func TestThreshold(t *testing.T) {
+	sut := NewThreshold[int](testRand(), 3)
+
+	if out := sut.Enqueue(1, 0); out != nil {
+		t.Fatalf("should hold below threshold. got %v", out)
+	}
+
+	if out := sut.Enqueue(2, 0); out != nil {
+		t.Fatalf("should hold below threshold. got %v", out)
+	}
+
+	if out := sut.Tick(); out != nil {
+		t.Fatal("threshold mix ignores ticks")
+	}
+
+	out := sut.Enqueue(3, 0)
+	if !slices.Equal(sorted(out), []int{1, 2, 3}) {
+		t.Fatalf("should send whole batch at threshold. got %v", out)
+	}
+
+	sut.Enqueue(4, 0)
+	if out := sut.Flush(); !slices.Equal(out, []int{4}) {
+		t.Fatalf("flush should send everything. got %v", out)
+	}
+}
+
+func TestTimed(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	sut := NewTimed[int](clk, testRand(), time.Second)
+
+	sut.Enqueue(1, 0)
+	sut.Enqueue(2, time.Hour)
+
+	clk.Advance(999 * time.Millisecond)
+	if out := sut.Tick(); out != nil {
+		t.Fatalf("should not fire before interval. got %v", out)
+	}
+
+	clk.Advance(time.Millisecond)
+	if out := sut.Tick(); !slices.Equal(sorted(out), []int{1, 2}) {
+		t.Fatalf("should send everything on interval. got %v", out)
+	}
+
+	sut.Enqueue(3, 0)
+	if out := sut.Tick(); out != nil {
+		t.Fatal("should wait for the next interval")
+	}
+
+	clk.Advance(5 * time.Second)
+	if out := sut.Tick(); !slices.Equal(out, []int{3}) {
+		t.Fatalf("should fire once after a stall. got %v", out)
+	}
+
+	sut.Enqueue(4, 0)
+	if out := sut.Tick(); out != nil {
+		t.Fatalf("stall should not cause a burst of firings. got %v", out)
+	}
+}
+
+func TestTimedPool(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	sut := NewTimedPool[int](clk, testRand(), time.Second, 2)
+
+	for i := range 5 {
+		sut.Enqueue(i, 0)
+	}
+
+	clk.Advance(time.Second)
+	out := sut.Tick()
+	if len(out) != 3 {
+		t.Fatalf("should keep 2 in the pool. sent %v", out)
+	}
+
+	clk.Advance(time.Second)
+	if out := sut.Tick(); len(out) != 0 {
+		t.Fatalf("should never go below pool size. sent %v", out)
+	}
+
+	rest := sut.Flush()
+	if !slices.Equal(sorted(append(out, rest...)), []int{0, 1, 2, 3, 4}) {
+		t.Fatalf("lost or duplicated packets. %v %v", out, rest)
+	}
+}
+
+func TestBinomial(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	sut := NewBinomial[int](clk, testRand(), time.Second, 0.5)
+
+	for i := range 1000 {
+		sut.Enqueue(i, 0)
+	}
+
+	clk.Advance(time.Second)
+	out := sut.Tick()
+	if len(out) < 400 || len(out) > 600 {
+		t.Fatalf("should send about half. sent %d", len(out))
+	}
+
+	rest := sut.Flush()
+	if len(out)+len(rest) != 1000 {
+		t.Fatal("lost packets")
+	}
+
+	// deterministic for a seeded rng.
+	clk2 := clock.NewFake(epoch)
+	again := NewBinomial[int](clk2, testRand(), time.Second, 0.5)
+	for i := range 1000 {
+		again.Enqueue(i, 0)
+	}
+	clk2.Advance(time.Second)
+	if !slices.Equal(again.Tick(), out) {
+		t.Fatal("same seed should give the same batch")
+	}
+}
+
+func TestStopAndGo(t *testing.T) {
+	clk := clock.NewFake(epoch)
+	sut := NewStopAndGo[int](clk, testRand(), time.Second)
+
+	sut.Enqueue(1, 3*time.Second)
+	sut.Enqueue(2, time.Second)
+	sut.Enqueue(3, 2*time.Second)
+
+	if out := sut.Enqueue(4, time.Nanosecond); out != nil {
+		t.Fatalf("nothing due yet. got %v", out)
+	}
+
+	clk.Advance(2 * time.Second)
+	if out := sut.Tick(); !slices.Equal(out, []int{4, 2, 3}) {
+		t.Fatalf("should send due packets in due order. got %v", out)
+	}
+
+	clk.Advance(time.Second)
+	if out := sut.Tick(); !slices.Equal(out, []int{1}) {
+		t.Fatalf("got %v", out)
+	}
+
+	// packets without a delay get one with the configured mean.
+	start := clk.Now()
+	for i := range 1000 {
+		sut.Enqueue(i, 0)
+	}
+
+	var total time.Duration
+	for len(sut.queue) > 0 {
+		clk.Advance(10 * time.Millisecond)
+		total += time.Duration(len(sut.Tick())) * clk.Now().Sub(start)
+	}
+
+	mean := total / 1000
+	if mean < 900*time.Millisecond || mean > 1100*time.Millisecond {
+		t.Fatalf("mean sampled delay should be about 1s. got %s", mean)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, s := range Strategies {
+		_, err := New[int](s, Config{
+			Threshold: 1,
+			Interval:  time.Second,
+			Prob:      0.5,
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+	}
+
+	_, err := New[int]("bogus", Config{})
+	if err == nil {
+		t.Fatal("unknown strategy should fail")
+	}
+
+	_, err = New[int](StrategyThreshold, Config{})
+	if err == nil {
+		t.Fatal("zero threshold should fail")
+	}
+}
@@ -0,0 +1,101 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package mixer
+
+import (
+	"container/heap"
+	"math/rand/v2"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+)
+
+/*
+StopAndGo holds every packet for its own delay, independent of the others.
+Senders pick the delays, a packet without one gets an exponentially
+distributed delay with the configured mean. With exponential delays this is
+the continuous mix used by Loopix.
+*/
+type StopAndGo[T any] struct {
+	clock clock.Clock
+	rng   *rand.Rand
+	mean  time.Duration
+	queue dueQueue[T]
+}
+
+func NewStopAndGo[T any](
+	c clock.Clock,
+	rng *rand.Rand,
+	mean time.Duration,
+) *StopAndGo[T] {
+	return &StopAndGo[T]{clock: c, rng: rng, mean: mean}
+}
+
+func (m *StopAndGo[T]) Enqueue(v T, delay time.Duration) []T {
+	if delay == 0 {
+		delay = time.Duration(m.rng.ExpFloat64() * float64(m.mean))
+	}
+
+	heap.Push(&m.queue, due[T]{v: v, at: m.clock.Now().Add(delay)})
+
+	return m.Tick()
+}
+
+func (m *StopAndGo[T]) Tick() []T {
+	var out []T
+
+	now := m.clock.Now()
+	for len(m.queue) > 0 && !m.queue[0].at.After(now) {
+		out = append(out, heap.Pop(&m.queue).(due[T]).v)
+	}
+
+	return out
+}
+
+func (m *StopAndGo[T]) Flush() []T {
+	var out []T
+
+	for len(m.queue) > 0 {
+		out = append(out, heap.Pop(&m.queue).(due[T]).v)
+	}
+
+	return out
+}
+
+type due[T any] struct {
+	v  T
+	at time.Time
+}
+
+type dueQueue[T any] []due[T]
+
+func (q dueQueue[T]) Len() int           { return len(q) }
+func (q dueQueue[T]) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+func (q dueQueue[T]) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *dueQueue[T]) Push(x any) {
+	*q = append(*q, x.(due[T]))
+}
+
+func (q *dueQueue[T]) Pop() any {
+	old := *q
+	v := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return v
+}
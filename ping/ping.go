@@ -18,43 +18,118 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package ping
 
 import (
+	"bytes"
 	"crypto/rand"
+	"fmt"
 	"log/slog"
 	"math"
+	mrand "math/rand/v2"
 	"net"
-	"strconv"
+	"net/netip"
+	"slices"
+	"time"
 
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/keys"
 	"github.com/LibSEA/mixnet/session"
+	"github.com/LibSEA/mixnet/sphinx"
 	"github.com/flynn/noise"
 )
 
 type Options struct {
-	Host string
-	Port uint16
+	// Route is the path to the mix that echoes the ping, as ip:port/key.
+	Route []string
+	// ReplyRoute is the path the echo takes back. By default it is Route
+	// reversed without the echoing mix.
+	ReplyRoute []string
+	// Listen is where the echo is delivered.
+	Listen string
+	// Delay is the mean of the delay picked for each hop. 0 leaves it to
+	// the mixes.
+	Delay   time.Duration
+	Timeout time.Duration
+	// Keys is where the keys are loaded from, see the keys package.
+	Keys keys.Source
 }
 
+var payload = []byte("ping")
+
 func Run(opts Options) int {
-	conn, err := net.Dial(
-		"tcp",
-		net.JoinHostPort(
-			opts.Host,
-			strconv.Itoa(int(opts.Port)),
-		),
-	)
+	route, err := parseRoute(opts.Route)
+	if err != nil || len(route) == 0 {
+		slog.Error("bad route", "error", err)
+		return 1
+	}
+
+	var reply []sphinx.Hop
+	if opts.ReplyRoute == nil {
+		reply = slices.Clone(route[:len(route)-1])
+		slices.Reverse(reply)
+	} else {
+		reply, err = parseRoute(opts.ReplyRoute)
+		if err != nil {
+			slog.Error("bad reply route", "error", err)
+			return 1
+		}
+	}
+
+	listen, err := netip.ParseAddrPort(opts.Listen)
 	if err != nil {
-		slog.Error("error connecting", "error", err)
+		slog.Error("bad listen address", "error", err)
 		return 1
 	}
 
+	ln, err := net.Listen("tcp", listen.String())
+	if err != nil {
+		slog.Error("couldn't listen", "addr", listen, "error", err)
+		return 1
+	}
+	defer func() { _ = ln.Close() }()
+
 	cs := noise.NewCipherSuite(
 		noise.DH25519,
 		noise.CipherChaChaPoly,
 		noise.HashBLAKE2b,
 	)
 
-	kp, err := cs.GenerateKeypair(rand.Reader)
+	k, err := opts.Keys.Load()
+	if err != nil {
+		slog.Error("couldn't load keys", "error", err)
+		return 1
+	}
+	kp := k.Link
+
+	self, err := sphinx.GenerateKey(rand.Reader)
+	if err != nil {
+		slog.Error("error generating reply key", "error", err)
+		return 1
+	}
+
+	reply = append(reply, sphinx.Hop{PublicKey: self.Public, Addr: listen})
+	pickDelays(route, opts.Delay)
+	pickDelays(reply[:len(reply)-1], opts.Delay)
+
+	table := sphinx.NewReplyTable(clock.Real{}, opts.Timeout)
+
+	surb, err := table.NewSURB(reply)
+	if err != nil {
+		slog.Error("failed making surb", "error", err)
+		return 1
+	}
+
+	p, err := sphinx.NewPacket(
+		route,
+		[sphinx.IDSize]byte{},
+		sphinx.EncodeMessage(surb, payload),
+	)
+	if err != nil {
+		slog.Error("failed making packet", "error", err)
+		return 1
+	}
+
+	conn, err := net.Dial("tcp", route[0].Addr.String())
 	if err != nil {
-		slog.Error("error generating keypair. panicking.", "error", err)
+		slog.Error("error connecting", "error", err)
 		return 1
 	}
 
@@ -69,11 +144,106 @@ func Run(opts Options) int {
 		return 1
 	}
 
-	err = s.WriteMessage(buf, []byte("ping"))
+	start := time.Now()
+
+	err = s.WriteMessage(buf, p.Bytes())
 	if err != nil {
 		slog.Error("failed write", "error", err)
 		return 1
 	}
 
-	return 0
+	_ = ln.(*net.TCPListener).SetDeadline(start.Add(opts.Timeout))
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			slog.Error("no reply", "error", err)
+			return 1
+		}
+
+		_ = conn.SetDeadline(start.Add(opts.Timeout))
+
+		ok, err := receive(session.New(conn, cs, kp), buf, self, table)
+		if err != nil {
+			slog.Warn("bad reply", "error", err)
+			continue
+		}
+
+		if ok {
+			slog.Info("pong", "rtt", time.Since(start))
+			return 0
+		}
+	}
+}
+
+// receive reads packets from s until one is the reply to our ping.
+func receive(
+	s *session.Session,
+	buf []byte,
+	self *sphinx.KeyPair,
+	table *sphinx.ReplyTable,
+) (bool, error) {
+	defer func() { _ = s.Close() }()
+
+	err := s.ServerHandshake(buf)
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		msg, err := s.ReadMessage(buf)
+		if err != nil {
+			return false, err
+		}
+
+		p, err := sphinx.Parse(msg)
+		if err != nil {
+			return false, err
+		}
+
+		res, err := sphinx.Unwrap(&self.Private, p)
+		if err != nil {
+			return false, err
+		}
+
+		if res.Command != sphinx.CommandDeliver {
+			return false, fmt.Errorf("reply wasn't addressed to us")
+		}
+
+		m, err := table.Open(res.ID, res.Packet.Payload[:])
+		if err != nil {
+			return false, err
+		}
+
+		if !bytes.Equal(m, payload) {
+			return false, fmt.Errorf("echo doesn't match. got %q", m)
+		}
+
+		return true, nil
+	}
+}
+
+func parseRoute(hops []string) ([]sphinx.Hop, error) {
+	if len(hops) > sphinx.MaxHops {
+		return nil, fmt.Errorf("route has more than %d hops", sphinx.MaxHops)
+	}
+
+	var out []sphinx.Hop
+
+	for _, h := range hops {
+		hop, err := sphinx.ParseHop(h)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, hop)
+	}
+
+	return out, nil
+}
+
+// pickDelays picks an exponentially distributed delay for every hop.
+func pickDelays(hops []sphinx.Hop, mean time.Duration) {
+	for i := range hops {
+		hops[i].Delay = time.Duration(mrand.ExpFloat64() * float64(mean))
+	}
 }
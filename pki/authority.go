@@ -0,0 +1,512 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/LibSEA/mixnet/cert"
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/descriptor"
+	"github.com/LibSEA/mixnet/session"
+	"github.com/LibSEA/mixnet/store"
+	"github.com/flynn/noise"
+)
+
+const (
+	/*
+		PublishAhead is how long before an epoch starts the authorities vote
+		on its document. A descriptor uploaded later only makes it into the
+		documents of later epochs.
+	*/
+	PublishAhead = 5 * time.Minute
+
+	// VoteWindow is how long after voting the authorities make the
+	// consensus. Votes that come later are refused.
+	VoteWindow = time.Minute
+
+	// maxEpochsAhead is how many epochs ahead a descriptor can have keys.
+	maxEpochsAhead = 3
+
+	// keepEpochs is how many epochs documents are kept after they end.
+	keepEpochs = 3
+
+	// ioTimeout bounds how long a client has to finish its request.
+	ioTimeout = 30 * time.Second
+)
+
+var (
+	nodesKey       = []byte("pki/nodes")
+	documentPrefix = []byte("pki/document/")
+	votePrefix     = []byte("pki/vote/")
+
+	ErrNotReady = errors.New("document is not ready yet")
+)
+
+type AuthorityOptions struct {
+	// Identity is the key documents are signed with.
+	Identity ed25519.PrivateKey
+	// PrivateKey and PublicKey are the static Noise key of the authority.
+	// A new one is made when they are empty.
+	PrivateKey []byte
+	PublicKey  []byte
+	Storage    store.Storage
+	Clock      clock.Clock
+	Logger     *slog.Logger
+	// Layers is how many mix layers the network has. Defaults to 3.
+	Layers int
+	// Peers are the other authorities of the network.
+	Peers []Peer
+	// Threshold is how many authorities must sign a document. Defaults to,
+	// and must be, more than half of them.
+	Threshold int
+}
+
+/*
+Authority collects the descriptors nodes upload and agrees with the other
+authorities on a signed document of them for every epoch, see round.
+Documents are stored once enough authorities signed them, so every client
+gets the same one for an epoch.
+*/
+type Authority struct {
+	identity ed25519.PrivateKey
+	storage  store.Storage
+	clock    clock.Clock
+	logger   *slog.Logger
+	layers   int
+	cs       noise.CipherSuite
+	kp       noise.DHKey
+
+	peers     []Peer
+	threshold int
+	dial      dialFunc
+
+	// mu guards nodes and rounds.
+	mu sync.Mutex
+	// nodes holds the newest descriptor of every identity.
+	nodes map[string]*descriptor.Descriptor
+	// rounds holds the votes and signatures of every epoch being agreed on.
+	rounds map[uint64]*round
+}
+
+func NewAuthority(opts AuthorityOptions) (*Authority, error) {
+	a := Authority{
+		identity:  opts.Identity,
+		storage:   opts.Storage,
+		clock:     opts.Clock,
+		logger:    opts.Logger,
+		layers:    opts.Layers,
+		cs:        cipherSuite,
+		kp:        noise.DHKey{Private: opts.PrivateKey, Public: opts.PublicKey},
+		peers:     opts.Peers,
+		threshold: opts.Threshold,
+		dial:      new(net.Dialer).DialContext,
+		nodes:     make(map[string]*descriptor.Descriptor),
+		rounds:    make(map[uint64]*round),
+	}
+
+	if len(a.identity) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("authority needs an ed25519 identity key")
+	}
+
+	if a.clock == nil {
+		a.clock = clock.Real{}
+	}
+
+	if a.logger == nil {
+		a.logger = slog.New(slog.DiscardHandler)
+	}
+
+	if a.layers == 0 {
+		a.layers = 3
+	}
+
+	n := len(a.peers) + 1
+
+	if a.threshold == 0 {
+		a.threshold = n/2 + 1
+	}
+
+	// with half or less, two sides of a partition could both sign.
+	if a.threshold <= n/2 || a.threshold > n {
+		return nil, fmt.Errorf("threshold %d should be a majority of %d authorities", a.threshold, n)
+	}
+
+	if a.kp.Private == nil {
+		kp, err := a.cs.GenerateKeypair(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate keypair. %w", err)
+		}
+		a.kp = kp
+	}
+
+	err := a.load()
+	if err != nil {
+		a.logger.Warn("ignoring saved descriptors", "error", err)
+	}
+
+	return &a, nil
+}
+
+// PublicKey is the static Noise key clients make sessions with.
+func (a *Authority) PublicKey() []byte {
+	return a.kp.Public
+}
+
+// Identity is the key that signs the documents.
+func (a *Authority) Identity() ed25519.PublicKey {
+	return a.identity.Public().(ed25519.PublicKey)
+}
+
+/*
+Upload checks d and keeps it in place of any older descriptor of the same
+node. link is the static key the node proved in its session, which has to be
+the link key of the descriptor.
+*/
+func (a *Authority) Upload(d *descriptor.Descriptor, link []byte) error {
+	err := a.check(d, link)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.prune()
+
+	if old, ok := a.nodes[string(d.Identity)]; ok && !d.Published.After(old.Published) {
+		return fmt.Errorf("descriptor is not newer than the one uploaded before")
+	}
+
+	a.nodes[string(d.Identity)] = d
+
+	err = a.save()
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info("descriptor uploaded", "identity", fmt.Sprintf("%x", d.Identity), "layer", d.Layer)
+
+	return nil
+}
+
+func (a *Authority) check(d *descriptor.Descriptor, link []byte) error {
+	now := a.clock.Now()
+	epoch := Epoch(now)
+
+	// the session has to be made with the link key the identity certified.
+	_, err := cert.Verify(d.LinkCert.Bytes(), cert.KindLink, d.Identity, link, now)
+	if err != nil {
+		return fmt.Errorf("descriptor link key doesn't match the session. %w", err)
+	}
+
+	if d.Published.Sub(now) > descriptor.MaxSkew {
+		return descriptor.ErrNotYetValid
+	}
+
+	if d.Expired(now) {
+		return descriptor.ErrExpired
+	}
+
+	err = a.valid(d)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range d.MixKeys {
+		if k.Epoch < epoch || k.Epoch > epoch+maxEpochsAhead {
+			return fmt.Errorf("descriptor has a mix key for epoch %d, now is %d", k.Epoch, epoch)
+		}
+	}
+
+	return nil
+}
+
+/*
+valid checks what the network asks of a descriptor, beyond its format. Its
+certificates have to be valid now and last as long as it does.
+*/
+func (a *Authority) valid(d *descriptor.Descriptor) error {
+	if d.Role == descriptor.RoleMix && int(d.Layer) >= a.layers {
+		return fmt.Errorf("layer %d is not in the network's %d layers", d.Layer, a.layers)
+	}
+
+	err := d.ValidAt(a.clock.Now())
+	if err != nil {
+		return err
+	}
+
+	if d.Expires.After(d.SigningCert.Expires) || d.Expires.After(d.LinkCert.Expires) {
+		return fmt.Errorf("descriptor expires after its certificates")
+	}
+
+	return nil
+}
+
+/*
+listed reports whether d belongs in the document of epoch e, which it does
+if it has a key for e and doesn't expire before e starts.
+*/
+func listed(d *descriptor.Descriptor, e uint64) bool {
+	_, ok := d.MixKey(e)
+
+	return ok && !d.Expired(EpochStart(e))
+}
+
+// prune drops expired descriptors and ones without keys for now or later.
+func (a *Authority) prune() {
+	now := a.clock.Now()
+	epoch := Epoch(now)
+
+	for id, d := range a.nodes {
+		if d.Expired(now) || d.MixKeys[len(d.MixKeys)-1].Epoch < epoch {
+			delete(a.nodes, id)
+		}
+	}
+}
+
+/*
+Document returns the document of epoch e once enough authorities signed it,
+ErrNotReady before that.
+*/
+func (a *Authority) Document(e uint64) ([]byte, error) {
+	b, err := a.storage.Get(documentKey(e))
+	if errors.Is(err, store.ErrKeyMissing) {
+		return nil, ErrNotReady
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load document. %w", err)
+	}
+
+	return b, nil
+}
+
+// document lists the nodes of epoch e, ordered by identity.
+func (a *Authority) document(e uint64) *Document {
+	doc := Document{Epoch: e}
+
+	for _, d := range a.nodes {
+		if listed(d, e) {
+			doc.Nodes = append(doc.Nodes, d)
+		}
+	}
+
+	slices.SortFunc(doc.Nodes, func(x, y *descriptor.Descriptor) int {
+		return bytes.Compare(x.Identity, y.Identity)
+	})
+
+	return &doc
+}
+
+// Maintain votes and makes the consensus of every epoch until ctx is done.
+func (a *Authority) Maintain(ctx context.Context) {
+	for {
+		t := time.NewTimer(a.step(ctx).Sub(a.clock.Now()))
+
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+/*
+Serve answers clients on ln until ln is closed or fails to accept
+connections too many times.
+*/
+func (a *Authority) Serve(ln net.Listener) error {
+	cf := 0
+
+	for {
+		if cf > 10 {
+			return fmt.Errorf("failed calling accept to many times")
+		}
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			a.logger.Error("error calling accept", "error", err)
+			cf++
+			continue
+		}
+		cf = 0
+		go a.serve(conn)
+	}
+}
+
+func (a *Authority) serve(conn net.Conn) {
+	s := session.New(conn, a.cs, a.kp)
+	defer func() { _ = s.Close() }()
+
+	_ = conn.SetDeadline(time.Now().Add(ioTimeout))
+
+	var buf = make([]byte, math.MaxInt16)
+	var out = make([]byte, math.MaxInt16)
+
+	err := s.ServerHandshake(buf)
+	if err != nil {
+		a.logger.Warn("ServerHandshake failed.", "error", err)
+		return
+	}
+
+	msg, err := s.ReadMessage(buf)
+	if err != nil {
+		a.logger.Debug("ReadMessage failed", "error", err)
+		return
+	}
+
+	typ, body, err := decode(msg)
+	if err != nil {
+		a.logger.Warn("bad pki request", "error", err)
+		return
+	}
+
+	switch typ {
+	case msgUpload:
+		uerr := a.upload(body, s.PeerStatic())
+		if uerr != nil {
+			a.logger.Info("rejected descriptor", "addr", conn.RemoteAddr(), "error", uerr)
+		}
+		err = reply(s, out, uerr)
+	case msgFetch:
+		err = a.fetch(s, out, body)
+	case msgVote:
+		err = a.receiveVote(s, buf, out, body)
+	case msgGetVotes:
+		err = a.sendVotes(s, out, body)
+	case msgSignature:
+		err = a.receiveSignature(s, out, body)
+	case msgGetSignatures:
+		err = a.sendSignatures(s, out, body)
+	default:
+		err = s.WriteMessage(out, encode(msgError, fmt.Appendf(nil, "unknown request type %d", typ)))
+	}
+
+	if err != nil {
+		a.logger.Debug("WriteMessage failed", "error", err)
+	}
+}
+
+func (a *Authority) upload(b []byte, link []byte) error {
+	d, err := descriptor.Parse(b)
+	if err != nil {
+		return err
+	}
+
+	return a.Upload(d, link)
+}
+
+// fetch sends the document of the epoch in body.
+func (a *Authority) fetch(s *session.Session, out []byte, body []byte) error {
+	if len(body) != 8 {
+		return s.WriteMessage(out, encode(msgError, []byte("bad fetch request")))
+	}
+
+	doc, err := a.Document(binary.BigEndian.Uint64(body))
+	if err != nil {
+		return s.WriteMessage(out, encode(msgError, []byte(err.Error())))
+	}
+
+	return writeBlob(s, out, msgDocument, doc)
+}
+
+// reply answers a request with ok, or err if it failed.
+func reply(s *session.Session, out []byte, err error) error {
+	if err != nil {
+		return s.WriteMessage(out, encode(msgError, []byte(err.Error())))
+	}
+
+	return s.WriteMessage(out, encode(msgOK, nil))
+}
+
+func documentKey(e uint64) []byte {
+	return binary.BigEndian.AppendUint64(slices.Clone(documentPrefix), e)
+}
+
+func voteKey(e uint64) []byte {
+	return binary.BigEndian.AppendUint64(slices.Clone(votePrefix), e)
+}
+
+/*
+The descriptors are saved as
+
+	count (2) | [length (2) | descriptor]...
+
+and checked again when loaded.
+*/
+func (a *Authority) save() error {
+	out := binary.BigEndian.AppendUint16(nil, uint16(len(a.nodes)))
+	for _, d := range a.nodes {
+		out = binary.BigEndian.AppendUint16(out, uint16(len(d.Bytes())))
+		out = append(out, d.Bytes()...)
+	}
+
+	err := a.storage.Put(nodesKey, out, 0)
+	if err != nil {
+		return fmt.Errorf("failed to save descriptors. %w", err)
+	}
+
+	return nil
+}
+
+func (a *Authority) load() error {
+	b, err := a.storage.Get(nodesKey)
+	if errors.Is(err, store.ErrKeyMissing) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to load descriptors. %w", err)
+	}
+
+	r := reader{b: b}
+
+	for range int(r.uint16()) {
+		raw := r.next(int(r.uint16()))
+		if r.err != nil {
+			return fmt.Errorf("bad saved descriptors. %w", r.err)
+		}
+
+		d, err := descriptor.Parse(raw)
+		if err != nil {
+			return err
+		}
+
+		a.nodes[string(d.Identity)] = d
+	}
+
+	a.prune()
+
+	return nil
+}
@@ -0,0 +1,191 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package pki
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+
+	"github.com/LibSEA/mixnet/descriptor"
+	"github.com/LibSEA/mixnet/session"
+	"github.com/flynn/noise"
+)
+
+var cipherSuite = noise.NewCipherSuite(
+	noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b,
+)
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+/*
+Upload sends d to the authority at addr. The session is made with kp, the
+link key of the node, so the authority can tell the node holds it. Nodes
+upload to every authority.
+*/
+func Upload(ctx context.Context, addr string, kp noise.DHKey, d *descriptor.Descriptor) error {
+	return call(ctx, new(net.Dialer).DialContext, addr, kp, msgUpload, d.Bytes())
+}
+
+/*
+Fetch downloads the document of epoch from the authority at addr and checks
+that at least threshold of authorities signed it.
+*/
+func Fetch(
+	ctx context.Context,
+	addr string,
+	authorities []ed25519.PublicKey,
+	threshold int,
+	epoch uint64,
+) (*Document, error) {
+	kp, err := cipherSuite.GenerateKeypair(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate keypair. %w", err)
+	}
+
+	b, err := get(ctx, new(net.Dialer).DialContext, addr, kp, msgFetch, epoch)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := VerifyDocument(b, authorities, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.Epoch != epoch {
+		return nil, fmt.Errorf("asked for epoch %d, got %d", epoch, doc.Epoch)
+	}
+
+	return doc, nil
+}
+
+// call sends a request that is answered with ok or an error.
+func call(
+	ctx context.Context,
+	dial dialFunc,
+	addr string,
+	kp noise.DHKey,
+	typ msgType,
+	body []byte,
+) error {
+	s, buf, err := connect(ctx, dial, addr, kp)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = s.Close() }()
+
+	// votes don't have to fit in a message.
+	if typ == msgVote {
+		err = writeBlob(s, buf, typ, body)
+	} else {
+		err = s.WriteMessage(buf, encode(typ, body))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send pki request. %w", err)
+	}
+
+	typ, body, err = read(s, buf)
+	if err != nil {
+		return err
+	}
+
+	switch typ {
+	case msgOK:
+		return nil
+	case msgError:
+		return fmt.Errorf("authority refused. %s", body)
+	default:
+		return fmt.Errorf("unexpected pki response type %d", typ)
+	}
+}
+
+// get sends a request for epoch that is answered with a blob.
+func get(
+	ctx context.Context,
+	dial dialFunc,
+	addr string,
+	kp noise.DHKey,
+	typ msgType,
+	epoch uint64,
+) ([]byte, error) {
+	s, buf, err := connect(ctx, dial, addr, kp)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = s.Close() }()
+
+	err = s.WriteMessage(buf, encode(typ, binary.BigEndian.AppendUint64(nil, epoch)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to send pki request. %w", err)
+	}
+
+	typ, body, err := read(s, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case msgDocument, msgVotes, msgSignatures:
+		return readBlob(s, buf, body)
+	case msgError:
+		return nil, fmt.Errorf("authority refused. %s", body)
+	default:
+		return nil, fmt.Errorf("unexpected pki response type %d", typ)
+	}
+}
+
+func connect(
+	ctx context.Context,
+	dial dialFunc,
+	addr string,
+	kp noise.DHKey,
+) (*session.Session, []byte, error) {
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to dial authority. %w", err)
+	}
+
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	s := session.New(conn, cipherSuite, kp)
+	buf := make([]byte, math.MaxInt16)
+
+	err = s.ClientHandshake(buf)
+	if err != nil {
+		_ = s.Close()
+		return nil, nil, fmt.Errorf("pki handshake failed. %w", err)
+	}
+
+	return s, buf, nil
+}
+
+func read(s *session.Session, buf []byte) (msgType, []byte, error) {
+	msg, err := s.ReadMessage(buf)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read pki response. %w", err)
+	}
+
+	return decode(msg)
+}
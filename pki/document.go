@@ -0,0 +1,185 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package pki
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/LibSEA/mixnet/descriptor"
+	"github.com/LibSEA/mixnet/epoch"
+)
+
+// EpochPeriod is how long an epoch lasts, see the epoch package.
+const EpochPeriod = epoch.Period
+
+// Epoch returns the epoch t is in.
+func Epoch(t time.Time) uint64 {
+	return epoch.Default.At(t)
+}
+
+// EpochStart returns when epoch e starts.
+func EpochStart(e uint64) time.Time {
+	return epoch.Default.Start(e)
+}
+
+/*
+Document lists the nodes of the network for one epoch, every one with its
+signed descriptor, ordered by identity. The encoding is
+
+	version (1) | epoch (8) | count (2) | [length (2) | descriptor]...
+	| signature count (1) | [identity (32) | signature (64)]...
+
+and every signature covers everything before the signature count, prefixed
+with documentContext.
+*/
+type Document struct {
+	Epoch      uint64
+	Nodes      []*descriptor.Descriptor
+	Signatures []Signature
+}
+
+// Signature is the signature of an authority over a document.
+type Signature struct {
+	Identity  ed25519.PublicKey
+	Signature []byte
+}
+
+const (
+	documentVersion = 0x1
+	documentContext = "mixnet pki document v1"
+)
+
+func (doc *Document) body() []byte {
+	out := []byte{documentVersion}
+	out = binary.BigEndian.AppendUint64(out, doc.Epoch)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(doc.Nodes)))
+
+	for _, d := range doc.Nodes {
+		out = binary.BigEndian.AppendUint16(out, uint16(len(d.Bytes())))
+		out = append(out, d.Bytes()...)
+	}
+
+	return out
+}
+
+// Sign adds the signature of the authority with key priv.
+func (doc *Document) Sign(priv ed25519.PrivateKey) {
+	doc.Signatures = append(doc.Signatures, Signature{
+		Identity:  priv.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(priv, append([]byte(documentContext), doc.body()...)),
+	})
+}
+
+func (doc *Document) Bytes() []byte {
+	out := doc.body()
+	out = append(out, byte(len(doc.Signatures)))
+
+	for _, s := range doc.Signatures {
+		out = append(out, s.Identity...)
+		out = append(out, s.Signature...)
+	}
+
+	return out
+}
+
+/*
+ParseDocument decodes b and checks the signatures of its descriptors, but not
+the signatures of the document, see VerifyDocument.
+*/
+func ParseDocument(b []byte) (*Document, error) {
+	var doc Document
+	var r = reader{b: b}
+
+	if v := r.byte(); r.err == nil && v != documentVersion {
+		return nil, fmt.Errorf("unsupported document version %d", v)
+	}
+
+	doc.Epoch = r.uint64()
+
+	for range int(r.uint16()) {
+		raw := r.next(int(r.uint16()))
+		if r.err != nil {
+			break
+		}
+
+		d, err := descriptor.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("bad document. %w", err)
+		}
+
+		doc.Nodes = append(doc.Nodes, d)
+	}
+
+	for range int(r.byte()) {
+		doc.Signatures = append(doc.Signatures, Signature{
+			Identity:  ed25519.PublicKey(r.next(ed25519.PublicKeySize)),
+			Signature: r.next(ed25519.SignatureSize),
+		})
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("bad document. %w", r.err)
+	}
+
+	if len(r.b) != 0 {
+		return nil, fmt.Errorf("%d trailing bytes in document", len(r.b))
+	}
+
+	return &doc, nil
+}
+
+/*
+VerifyDocument parses b and checks that at least threshold of authorities
+signed it.
+*/
+func VerifyDocument(
+	b []byte,
+	authorities []ed25519.PublicKey,
+	threshold int,
+) (*Document, error) {
+	doc, err := ParseDocument(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.valid(authorities) < threshold {
+		return nil, fmt.Errorf("document for epoch %d has too few valid signatures", doc.Epoch)
+	}
+
+	return doc, nil
+}
+
+// valid counts the authorities with a valid signature on doc.
+func (doc *Document) valid(authorities []ed25519.PublicKey) int {
+	signed := append([]byte(documentContext), doc.body()...)
+
+	n := 0
+	for _, a := range authorities {
+		for _, s := range doc.Signatures {
+			if a.Equal(s.Identity) && ed25519.Verify(a, signed, s.Signature) {
+				n++
+				break
+			}
+		}
+	}
+
+	return n
+}
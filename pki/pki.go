@@ -17,11 +17,126 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 */
 package pki
 
-type Options struct {
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/keys"
+	"github.com/LibSEA/mixnet/store"
+)
 
+type Options struct {
+	Port uint16
+	Host string
+	// DB is the directory of the database holding descriptors and
+	// documents.
+	DB string
+	// Keys is where the keys are loaded from, see the keys package.
+	// Documents are signed with the identity key.
+	Keys keys.Source
+	// Layers is how many mix layers the network has.
+	Layers int
+	// Peers are the other authorities, as host:port/hex-identity/hex-link-key.
+	Peers []string
+	// Threshold is how many authorities must sign a document. 0 is more
+	// than half of them.
+	Threshold int
 }
 
-func Run(opt Options) int {
+func Run(opts Options) int {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	var peers []Peer
+	for _, s := range opts.Peers {
+		p, err := ParsePeer(s)
+		if err != nil {
+			logger.Error("bad peer", "error", err)
+			return 1
+		}
+		peers = append(peers, p)
+	}
+
+	k, err := opts.Keys.Load()
+	if err != nil {
+		logger.Error("couldn't load keys", "error", err)
+		return 1
+	}
+
+	// authorities sign documents every epoch.
+	if k.Identity == nil {
+		logger.Error("couldn't load keys", "error", keys.ErrOffline)
+		return 1
+	}
+
+	db, err := store.Open(opts.DB)
+	if err != nil {
+		logger.Error("couldn't open database.", "error", err)
+		return 1
+	}
+	defer func() { _ = db.Close() }()
+
+	a, err := NewAuthority(AuthorityOptions{
+		Identity:   k.Identity,
+		PrivateKey: k.Link.Private,
+		PublicKey:  k.Link.Public,
+		Storage:    db,
+		Clock:      clock.Real{},
+		Logger:     logger,
+		Layers:     opts.Layers,
+		Peers:      peers,
+		Threshold:  opts.Threshold,
+	})
+	if err != nil {
+		logger.Error("couldn't start authority", "error", err)
+		return 1
+	}
+
+	ln, err := net.Listen(
+		"tcp",
+		net.JoinHostPort(
+			opts.Host,
+			strconv.Itoa(int(opts.Port))),
+	)
+	if err != nil {
+		logger.Error(
+			"couldn't listen",
+			"port", opts.Port,
+			"host", opts.Host,
+		)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	go a.Maintain(ctx)
+
+	logger.Info(
+		"started",
+		"addr", ln.Addr(),
+		"identity", hex.EncodeToString(a.Identity()),
+		"link_key", hex.EncodeToString(a.PublicKey()),
+	)
+
+	err = a.Serve(ln)
+	if err != nil {
+		logger.Error("stopped serving", "error", err)
+		return 1
+	}
+
+	logger.Info("stopped")
 
-    return 0
+	return 0
 }
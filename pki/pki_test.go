@@ -0,0 +1,351 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/cert"
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/descriptor"
+	"github.com/LibSEA/mixnet/store"
+	"github.com/flynn/noise"
+)
+
+// start is 10 minutes into epoch 10.
+var start = EpochStart(10).Add(10 * time.Minute)
+
+type node struct {
+	priv    ed25519.PrivateKey
+	signing ed25519.PrivateKey
+	link    noise.DHKey
+	desc    descriptor.Descriptor
+	// certs is when the certificates sign makes expire.
+	certs time.Time
+}
+
+func newNode(t *testing.T, clk clock.Clock, layer uint8) *node {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, signing, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := cipherSuite.GenerateKeypair(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := node{priv: priv, signing: signing, link: link, certs: clk.Now().Add(24 * time.Hour)}
+	n.desc = descriptor.Descriptor{
+		Addrs:     []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:8081")},
+		Role:      descriptor.RoleMix,
+		Layer:     layer,
+		Bandwidth: 1 << 20,
+		Published: clk.Now().Truncate(time.Second),
+		Expires:   clk.Now().Add(time.Hour),
+	}
+	copy(n.desc.LinkKey[:], link.Public)
+
+	for e := Epoch(clk.Now()); e < Epoch(clk.Now())+2; e++ {
+		k := descriptor.MixKey{Epoch: e}
+		_, _ = rand.Read(k.Key[:])
+		n.desc.MixKeys = append(n.desc.MixKeys, k)
+	}
+
+	n.sign(t)
+
+	return &n
+}
+
+// sign certifies the keys of the descriptor and signs it.
+func (n *node) sign(t *testing.T) {
+	n.desc.SigningCert = n.certify(t, cert.KindSigning, n.signing.Public().(ed25519.PublicKey), 0)
+	n.desc.LinkCert = n.certify(t, cert.KindLink, n.desc.LinkKey[:], 0)
+
+	for i, k := range n.desc.MixKeys {
+		n.desc.MixKeys[i].Cert = n.certify(t, cert.KindMix, k.Key[:], k.Epoch)
+	}
+
+	err := descriptor.Sign(n.signing, &n.desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (n *node) certify(t *testing.T, kind cert.Kind, key []byte, epoch uint64) *cert.Certificate {
+	c := cert.Certificate{
+		Kind:      kind,
+		Epoch:     epoch,
+		ValidFrom: start.Add(-time.Hour),
+		Expires:   n.certs,
+	}
+	copy(c.Key[:], key)
+
+	err := cert.Sign(n.priv, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &c
+}
+
+func newAuthority(t *testing.T, clk clock.Clock) *Authority {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewAuthority(AuthorityOptions{
+		Identity: priv,
+		Storage:  store.NewMemory(clk),
+		Clock:    clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+func TestUploadChecks(t *testing.T) {
+	clk := clock.NewFake(start)
+	sut := newAuthority(t, clk)
+
+	n := newNode(t, clk, 0)
+	if err := sut.Upload(&n.desc, n.link.Public); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sut.Upload(&n.desc, n.link.Public); err == nil {
+		t.Fatal("the same descriptor again should be rejected")
+	}
+
+	for name, change := range map[string]func(n *node){
+		"link key": func(n *node) { n.desc.LinkKey[0] ^= 1 },
+		"layer":    func(n *node) { n.desc.Layer = 3 },
+		"expiry": func(n *node) {
+			n.desc.Published, n.desc.Expires = start.Add(-2*time.Hour), start.Add(-time.Hour)
+		},
+		"publish": func(n *node) {
+			n.desc.Published, n.desc.Expires = start.Add(time.Hour), start.Add(2*time.Hour)
+		},
+		"past key": func(n *node) { n.desc.MixKeys[0].Epoch = 9 },
+		"far key":  func(n *node) { n.desc.MixKeys[1].Epoch = 20 },
+		"cert expiry": func(n *node) {
+			n.certs = n.desc.Expires.Add(-time.Minute)
+		},
+		"expired cert": func(n *node) {
+			n.certs = start
+		},
+	} {
+		n := newNode(t, clk, 0)
+		change(n)
+		n.sign(t)
+
+		if err := sut.Upload(&n.desc, n.link.Public); err == nil {
+			t.Fatalf("descriptor with bad %s should be rejected", name)
+		}
+	}
+}
+
+func TestDocument(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(start)
+	sut := newAuthority(t, clk)
+
+	nodes := []*node{newNode(t, clk, 0), newNode(t, clk, 1), newNode(t, clk, 2)}
+	for _, n := range nodes[:2] {
+		if err := sut.Upload(&n.desc, n.link.Public); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clk.Set(voteAt(11))
+
+	if err := sut.vote(ctx, 11); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sut.Document(11); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("document should wait for the consensus. %v", err)
+	}
+
+	// too late for epoch 11.
+	if err := sut.Upload(&nodes[2].desc, nodes[2].link.Public); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Set(consensusAt(11))
+
+	if err := sut.agree(ctx, 11); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := sut.Document(11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := VerifyDocument(b, []ed25519.PublicKey{sut.Identity()}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.Epoch != 11 || len(doc.Nodes) != 2 ||
+		bytes.Compare(doc.Nodes[0].Identity, doc.Nodes[1].Identity) >= 0 {
+		t.Fatalf("document should list the two early nodes in order. %+v", doc)
+	}
+
+	// the nodes only have keys up to epoch 11.
+	clk.Set(voteAt(12))
+
+	if err := sut.vote(ctx, 12); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sut.agree(ctx, 12); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = sut.Document(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err = VerifyDocument(b, []ed25519.PublicKey{sut.Identity()}, 1)
+	if err != nil || len(doc.Nodes) != 0 {
+		t.Fatal("nodes without a key for the epoch should not be listed")
+	}
+
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := VerifyDocument(b, []ed25519.PublicKey{other.Public().(ed25519.PublicKey)}, 1); err == nil {
+		t.Fatal("document should not verify for another authority")
+	}
+
+	clk.Set(EpochStart(13))
+
+	if _, err := sut.Document(12); err != nil {
+		t.Fatal("past documents should still be served")
+	}
+
+	if err := sut.vote(ctx, 13); err == nil {
+		t.Fatal("voting after the vote window should fail")
+	}
+}
+
+func TestUploadAndFetch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clk := clock.NewFake(start)
+	storage := store.NewMemory(clk)
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	identity := []ed25519.PublicKey{priv.Public().(ed25519.PublicKey)}
+
+	sut, err := NewAuthority(AuthorityOptions{Identity: priv, Storage: storage, Clock: clk})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	go func() { _ = sut.Serve(ln) }()
+
+	addr := ln.Addr().String()
+
+	// enough nodes that the document takes several chunks.
+	var nodes []*node
+	for i := range 200 {
+		n := newNode(t, clk, uint8(i%3))
+
+		err := Upload(ctx, addr, n.link, &n.desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	// the session must be made with the descriptor's link key.
+	other := newNode(t, clk, 0)
+	if err := Upload(ctx, addr, nodes[0].link, &other.desc); err == nil {
+		t.Fatal("upload over another node's session should be rejected")
+	}
+
+	clk.Set(voteAt(11))
+
+	if err := sut.vote(ctx, 11); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted authority keeps the descriptors and its vote.
+	restarted, err := NewAuthority(AuthorityOptions{Identity: priv, Storage: storage, Clock: clk})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restarted.nodes) != len(nodes) {
+		t.Fatal("descriptors should be loaded after a restart")
+	}
+
+	if err := restarted.vote(ctx, 11); err != nil {
+		t.Fatal(err)
+	}
+
+	self := string(sut.Identity())
+	if !bytes.Equal(restarted.rounds[11].votes[self].Bytes(), sut.rounds[11].votes[self].Bytes()) {
+		t.Fatal("a restarted authority should vote the same")
+	}
+
+	clk.Set(consensusAt(11))
+
+	if err := sut.agree(ctx, 11); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := Fetch(ctx, addr, identity, 1, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Nodes) != len(nodes) || len(doc.Bytes()) < 2*chunkSize {
+		t.Fatalf("document should list every node. %d", len(doc.Nodes))
+	}
+
+	if _, err := Fetch(ctx, addr, identity, 1, 12); err == nil {
+		t.Fatal("fetching a document that isn't ready should fail")
+	}
+}
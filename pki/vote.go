@@ -0,0 +1,721 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LibSEA/mixnet/descriptor"
+	"github.com/LibSEA/mixnet/session"
+)
+
+/*
+Peer is another authority of the network. Link is its static Noise key, the
+one it makes sessions with, so what it sends can be told from what anyone
+else sends in its name.
+*/
+type Peer struct {
+	Addr     string
+	Identity ed25519.PublicKey
+	Link     []byte
+}
+
+// ParsePeer reads a peer written as host:port/hex-identity/hex-link-key.
+func ParsePeer(s string) (Peer, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return Peer{}, fmt.Errorf("authority %q should be host:port/identity/link-key", s)
+	}
+
+	id, err := hex.DecodeString(parts[1])
+	if err != nil || len(id) != ed25519.PublicKeySize {
+		return Peer{}, fmt.Errorf("authority identity should be %d hex bytes", ed25519.PublicKeySize)
+	}
+
+	link, err := hex.DecodeString(parts[2])
+	if err != nil || len(link) != 32 {
+		return Peer{}, fmt.Errorf("authority link key should be 32 hex bytes")
+	}
+
+	return Peer{Addr: parts[0], Identity: id, Link: link}, nil
+}
+
+/*
+Every epoch the authorities agree on its document in three steps.
+
+PublishAhead before the epoch starts every authority votes: it makes a
+document of the nodes it knows, signed only by itself, and sends it to the
+others. VoteWindow later it asks the others for the votes they hold, in case
+some didn't reach it, and tallies all of them into the consensus. That is
+every node more than half of the votes list, with the newest descriptor any
+of them has. Authorities that hold the same votes make the same consensus,
+so they sign it and send each other their signatures, and once threshold
+authorities signed it the consensus is the document of the epoch. Until
+then an authority asks the others for the signatures they hold every
+signatureRetry, in case some didn't reach it.
+
+A signature is only taken from a session made with the link key of the
+authority it is from. One that comes before the consensus is made can't be
+checked yet, so it is kept until then, one per authority.
+
+An authority that sent two different votes is left out of the tally. Ones
+that didn't vote, and signatures over a different consensus, are logged.
+*/
+type round struct {
+	// votes holds the vote of every authority, by identity.
+	votes map[string]*Document
+	// conflicts holds the second vote of authorities that sent two.
+	conflicts map[string]*Document
+	consensus *Document
+	// signed is what signatures over the consensus cover.
+	signed []byte
+	// pending holds the latest signature of every authority that came
+	// before the consensus was made, by identity.
+	pending map[string]Signature
+}
+
+// signatureRetry is how often missing signatures are asked for.
+const signatureRetry = 30 * time.Second
+
+func voteAt(e uint64) time.Time {
+	return EpochStart(e).Add(-PublishAhead)
+}
+
+func consensusAt(e uint64) time.Time {
+	return voteAt(e).Add(VoteWindow)
+}
+
+// round returns the round of e, making it if needed. a.mu must be held.
+func (a *Authority) round(e uint64) *round {
+	r, ok := a.rounds[e]
+	if !ok {
+		r = &round{
+			votes:     make(map[string]*Document),
+			conflicts: make(map[string]*Document),
+			pending:   make(map[string]Signature),
+		}
+		a.rounds[e] = r
+	}
+
+	return r
+}
+
+// step does what is due now and returns when it should run again.
+func (a *Authority) step(ctx context.Context) time.Time {
+	now := a.clock.Now()
+	epoch := Epoch(now)
+	next := epoch + 1
+
+	a.forget(epoch)
+
+	// after a late start the others may have voted on this epoch already.
+	a.settle(ctx, epoch)
+
+	wake := voteAt(next + 1)
+
+	switch {
+	case now.Before(voteAt(next)):
+		wake = voteAt(next)
+	case now.Before(consensusAt(next)):
+		err := a.vote(ctx, next)
+		if err != nil {
+			a.logger.Warn("couldn't vote", "epoch", next, "error", err)
+		}
+
+		wake = consensusAt(next)
+	default:
+		a.settle(ctx, next)
+	}
+
+	retry := a.clock.Now().Add(signatureRetry)
+	if (a.unsigned(epoch) || a.unsigned(next)) && retry.Before(wake) {
+		wake = retry
+	}
+
+	return wake
+}
+
+/*
+settle makes the consensus on epoch e, or asks for the signatures it misses
+once there is one.
+*/
+func (a *Authority) settle(ctx context.Context, e uint64) {
+	if a.agreed(e) {
+		a.gather(ctx, e)
+		return
+	}
+
+	err := a.agree(ctx, e)
+	if err != nil {
+		a.logger.Warn("no consensus", "epoch", e, "error", err)
+	}
+}
+
+// forget drops the rounds of epochs whose documents are no longer kept.
+func (a *Authority) forget(epoch uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for e := range a.rounds {
+		if e+keepEpochs < epoch {
+			delete(a.rounds, e)
+		}
+	}
+}
+
+func (a *Authority) agreed(e uint64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r, ok := a.rounds[e]
+	if ok && r.consensus != nil {
+		return true
+	}
+
+	_, err := a.Document(e)
+
+	return err == nil
+}
+
+// unsigned reports whether there is a consensus on e without a document yet.
+func (a *Authority) unsigned(e uint64) bool {
+	a.mu.Lock()
+	r, ok := a.rounds[e]
+	made := ok && r.consensus != nil
+	a.mu.Unlock()
+
+	if !made {
+		return false
+	}
+
+	_, err := a.Document(e)
+
+	return errors.Is(err, ErrNotReady)
+}
+
+/*
+gather asks the others for the signatures they hold on their consensus on
+e. They are checked against ours, so it doesn't matter who passes them on.
+*/
+func (a *Authority) gather(ctx context.Context, e uint64) {
+	if !a.unsigned(e) {
+		return
+	}
+
+	a.each(ctx, "couldn't get signatures", func(ctx context.Context, p Peer) error {
+		b, err := get(ctx, a.dial, p.Addr, a.kp, msgGetSignatures, e)
+		if err != nil {
+			return err
+		}
+
+		sigs, err := parseSignatures(b)
+		if err != nil {
+			return err
+		}
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		r := a.round(e)
+		for _, s := range sigs {
+			if a.isAuthority(s.Identity) {
+				_ = a.addSignature(e, r, s)
+			}
+		}
+
+		return a.publish(e, r)
+	})
+}
+
+/*
+vote makes the vote of this authority on epoch e and sends it to the others.
+A restarted authority sends the vote it made before, another one would
+conflict with it.
+*/
+func (a *Authority) vote(ctx context.Context, e uint64) error {
+	a.mu.Lock()
+
+	now := a.clock.Now()
+	r := a.round(e)
+
+	if _, ok := r.votes[string(a.Identity())]; ok {
+		a.mu.Unlock()
+		return nil
+	}
+
+	if !now.Before(consensusAt(e)) {
+		a.mu.Unlock()
+		return fmt.Errorf("too late to vote on epoch %d", e)
+	}
+
+	var doc *Document
+
+	b, err := a.storage.Get(voteKey(e))
+	if err == nil {
+		doc, err = ParseDocument(b)
+	} else {
+		a.prune()
+
+		doc = a.document(e)
+		doc.Sign(a.identity)
+
+		err = a.storage.Put(voteKey(e), doc.Bytes(), EpochStart(e+1+keepEpochs).Sub(now))
+	}
+
+	if err != nil {
+		a.mu.Unlock()
+		return fmt.Errorf("failed to save vote. %w", err)
+	}
+
+	r.votes[string(a.Identity())] = doc
+	a.mu.Unlock()
+
+	a.logger.Info("voted", "epoch", e, "nodes", len(doc.Nodes))
+
+	b = doc.Bytes()
+	a.each(ctx, "couldn't send vote", func(ctx context.Context, p Peer) error {
+		return call(ctx, a.dial, p.Addr, a.kp, msgVote, b)
+	})
+
+	return nil
+}
+
+/*
+agree collects the votes on epoch e, makes the consensus from them and sends
+its signature to the others.
+*/
+func (a *Authority) agree(ctx context.Context, e uint64) error {
+	a.collect(ctx, e)
+
+	a.mu.Lock()
+
+	r := a.round(e)
+	if r.consensus != nil {
+		a.mu.Unlock()
+		return nil
+	}
+
+	var votes []*Document
+
+	for _, id := range a.authorities() {
+		v, ok := r.votes[string(id)]
+		switch {
+		case !ok:
+			a.logger.Warn("authority did not vote", "authority", hex.EncodeToString(id), "epoch", e)
+		case r.conflicts[string(id)] == nil:
+			votes = append(votes, v)
+		}
+	}
+
+	if len(votes) < a.threshold {
+		a.mu.Unlock()
+		return fmt.Errorf("only %d of %d authorities voted", len(votes), len(a.peers)+1)
+	}
+
+	doc := tally(e, votes)
+	r.signed = append([]byte(documentContext), doc.body()...)
+	doc.Sign(a.identity)
+	r.consensus = doc
+
+	for _, s := range r.pending {
+		_ = a.addSignature(e, r, s)
+	}
+	clear(r.pending)
+
+	err := a.publish(e, r)
+	a.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info("made consensus", "epoch", e, "nodes", len(doc.Nodes), "votes", len(votes))
+
+	body := binary.BigEndian.AppendUint64(nil, e)
+	body = append(body, doc.Signatures[0].Identity...)
+	body = append(body, doc.Signatures[0].Signature...)
+
+	a.each(ctx, "couldn't send signature", func(ctx context.Context, p Peer) error {
+		return call(ctx, a.dial, p.Addr, a.kp, msgSignature, body)
+	})
+
+	return nil
+}
+
+/*
+tally makes the consensus of votes. It lists every node more than half of
+the votes list, with the newest descriptor any vote has, ties going to the
+larger encoding so every authority picks the same one.
+*/
+func tally(e uint64, votes []*Document) *Document {
+	count := make(map[string]int)
+	newest := make(map[string]*descriptor.Descriptor)
+
+	for _, v := range votes {
+		for _, d := range v.Nodes {
+			id := string(d.Identity)
+			count[id]++
+
+			n, ok := newest[id]
+			if !ok || d.Published.After(n.Published) ||
+				d.Published.Equal(n.Published) && bytes.Compare(d.Bytes(), n.Bytes()) > 0 {
+				newest[id] = d
+			}
+		}
+	}
+
+	doc := Document{Epoch: e}
+
+	for id, d := range newest {
+		if 2*count[id] > len(votes) {
+			doc.Nodes = append(doc.Nodes, d)
+		}
+	}
+
+	slices.SortFunc(doc.Nodes, func(x, y *descriptor.Descriptor) int {
+		return bytes.Compare(x.Identity, y.Identity)
+	})
+
+	return &doc
+}
+
+// collect asks the others for the votes they hold on epoch e.
+func (a *Authority) collect(ctx context.Context, e uint64) {
+	a.each(ctx, "couldn't get votes", func(ctx context.Context, p Peer) error {
+		b, err := get(ctx, a.dial, p.Addr, a.kp, msgGetVotes, e)
+		if err != nil {
+			return err
+		}
+
+		r := reader{b: b}
+
+		for range int(r.byte()) {
+			v := r.next(int(r.uint32()))
+			if r.err != nil {
+				break
+			}
+
+			doc, err := a.checkVote(v)
+			if err != nil || doc.Epoch != e {
+				a.logger.Warn("authority passed on a bad vote", "authority", p.Addr, "error", err)
+				continue
+			}
+
+			_ = a.addVote(doc, true)
+		}
+
+		return r.err
+	})
+}
+
+// each runs f for every peer at once, logging failures with msg.
+func (a *Authority) each(ctx context.Context, msg string, f func(context.Context, Peer) error) {
+	var wg sync.WaitGroup
+
+	for _, p := range a.peers {
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(ctx, ioTimeout)
+			defer cancel()
+
+			err := f(ctx, p)
+			if err != nil {
+				a.logger.Warn(msg, "authority", p.Addr, "error", err)
+			}
+		})
+	}
+
+	wg.Wait()
+}
+
+// authorities returns the identities of all authorities, this one first.
+func (a *Authority) authorities() []ed25519.PublicKey {
+	out := []ed25519.PublicKey{a.Identity()}
+	for _, p := range a.peers {
+		out = append(out, p.Identity)
+	}
+
+	return out
+}
+
+func (a *Authority) isAuthority(id ed25519.PublicKey) bool {
+	return slices.ContainsFunc(a.authorities(), func(k ed25519.PublicKey) bool {
+		return k.Equal(id)
+	})
+}
+
+/*
+checkVote parses a vote and checks that an authority signed it and that it
+only lists valid descriptors that belong in its epoch, in identity order.
+*/
+func (a *Authority) checkVote(b []byte) (*Document, error) {
+	doc, err := ParseDocument(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(doc.Signatures) != 1 {
+		return nil, fmt.Errorf("vote should have one signature")
+	}
+
+	id := doc.Signatures[0].Identity
+	if !a.isAuthority(id) || doc.valid([]ed25519.PublicKey{id}) != 1 {
+		return nil, fmt.Errorf("vote is not signed by an authority")
+	}
+
+	for i, d := range doc.Nodes {
+		if i > 0 && bytes.Compare(doc.Nodes[i-1].Identity, d.Identity) >= 0 {
+			return nil, fmt.Errorf("vote nodes are not in identity order")
+		}
+
+		if !listed(d, doc.Epoch) {
+			return nil, fmt.Errorf("vote lists a node that doesn't belong in epoch %d", doc.Epoch)
+		}
+
+		err := a.valid(d)
+		if err != nil {
+			return nil, fmt.Errorf("vote lists a bad descriptor. %w", err)
+		}
+	}
+
+	return doc, nil
+}
+
+/*
+addVote keeps a checked vote. Votes sent to us have to come before the
+consensus is made, fetched ones are what another authority had by then.
+*/
+func (a *Authority) addVote(v *Document, fetched bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.clock.Now()
+
+	if !fetched && !now.Before(consensusAt(v.Epoch)) {
+		return fmt.Errorf("vote on epoch %d came too late", v.Epoch)
+	}
+
+	if !fetched && v.Epoch > Epoch(now)+1 {
+		return fmt.Errorf("vote on epoch %d came too early", v.Epoch)
+	}
+
+	id := v.Signatures[0].Identity
+	r := a.round(v.Epoch)
+
+	old, ok := r.votes[string(id)]
+	if !ok {
+		r.votes[string(id)] = v
+		return nil
+	}
+
+	if bytes.Equal(old.Bytes(), v.Bytes()) {
+		return nil
+	}
+
+	if _, ok := r.conflicts[string(id)]; !ok {
+		r.conflicts[string(id)] = v
+		a.logger.Warn("authority sent conflicting votes", "authority", hex.EncodeToString(id), "epoch", v.Epoch)
+	}
+
+	return fmt.Errorf("conflicting vote on epoch %d", v.Epoch)
+}
+
+/*
+addSignature adds s to the consensus of r, or keeps it in place of any
+earlier one of the same authority until the consensus is made. a.mu must be
+held.
+*/
+func (a *Authority) addSignature(e uint64, r *round, s Signature) error {
+	if r.consensus == nil {
+		r.pending[string(s.Identity)] = s
+
+		return nil
+	}
+
+	for _, have := range r.consensus.Signatures {
+		if have.Identity.Equal(s.Identity) {
+			return nil
+		}
+	}
+
+	if !ed25519.Verify(s.Identity, r.signed, s.Signature) {
+		a.logger.Warn("authority signed a different consensus", "authority", hex.EncodeToString(s.Identity), "epoch", e)
+		return fmt.Errorf("signature is not over our consensus")
+	}
+
+	r.consensus.Signatures = append(r.consensus.Signatures, s)
+
+	return nil
+}
+
+// publish stores the consensus of r once enough authorities signed it.
+func (a *Authority) publish(e uint64, r *round) error {
+	n := len(r.consensus.Signatures)
+	if n < a.threshold {
+		return nil
+	}
+
+	now := a.clock.Now()
+
+	err := a.storage.Put(documentKey(e), r.consensus.Bytes(), EpochStart(e+1+keepEpochs).Sub(now))
+	if err != nil {
+		return fmt.Errorf("failed to save document. %w", err)
+	}
+
+	if n == a.threshold {
+		a.logger.Info("document signed", "epoch", e, "nodes", len(r.consensus.Nodes))
+	}
+
+	return nil
+}
+
+func (a *Authority) receiveVote(s *session.Session, buf, out, body []byte) error {
+	b, err := readBlob(s, buf, body)
+	if err != nil {
+		return err
+	}
+
+	v, err := a.checkVote(b)
+	if err == nil {
+		err = a.addVote(v, false)
+	}
+
+	if err != nil {
+		a.logger.Info("rejected vote", "error", err)
+	}
+
+	return reply(s, out, err)
+}
+
+// sendVotes sends the votes held on the epoch in body, conflicting ones too.
+func (a *Authority) sendVotes(s *session.Session, out, body []byte) error {
+	if len(body) != 8 {
+		return s.WriteMessage(out, encode(msgError, []byte("bad get votes request")))
+	}
+
+	a.mu.Lock()
+
+	var votes []*Document
+	if r, ok := a.rounds[binary.BigEndian.Uint64(body)]; ok {
+		for _, v := range r.votes {
+			votes = append(votes, v)
+		}
+		for _, v := range r.conflicts {
+			votes = append(votes, v)
+		}
+	}
+
+	a.mu.Unlock()
+
+	b := []byte{byte(len(votes))}
+	for _, v := range votes {
+		vb := v.Bytes()
+		b = binary.BigEndian.AppendUint32(b, uint32(len(vb)))
+		b = append(b, vb...)
+	}
+
+	return writeBlob(s, out, msgVotes, b)
+}
+
+func (a *Authority) receiveSignature(s *session.Session, out, body []byte) error {
+	r := reader{b: body}
+	e := r.uint64()
+	sig := Signature{
+		Identity:  ed25519.PublicKey(r.next(ed25519.PublicKeySize)),
+		Signature: r.next(ed25519.SignatureSize),
+	}
+
+	if r.err != nil || len(r.b) != 0 {
+		return s.WriteMessage(out, encode(msgError, []byte("bad signature request")))
+	}
+
+	return reply(s, out, a.addPeerSignature(e, sig, s.PeerStatic()))
+}
+
+// addPeerSignature adds sig, sent over a session made with link.
+func (a *Authority) addPeerSignature(e uint64, sig Signature, link []byte) error {
+	i := slices.IndexFunc(a.peers, func(p Peer) bool { return p.Identity.Equal(sig.Identity) })
+	if i < 0 || !bytes.Equal(a.peers[i].Link, link) {
+		return fmt.Errorf("signature is not from an authority")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	epoch := Epoch(a.clock.Now())
+	if e+1 < epoch || e > epoch+1 {
+		return fmt.Errorf("signature for epoch %d is out of range", e)
+	}
+
+	r := a.round(e)
+
+	err := a.addSignature(e, r, sig)
+	if err != nil || r.consensus == nil {
+		return err
+	}
+
+	return a.publish(e, r)
+}
+
+// sendSignatures sends the signatures on the consensus on the epoch in body.
+func (a *Authority) sendSignatures(s *session.Session, out, body []byte) error {
+	if len(body) != 8 {
+		return s.WriteMessage(out, encode(msgError, []byte("bad get signatures request")))
+	}
+
+	a.mu.Lock()
+
+	var b []byte
+	if r, ok := a.rounds[binary.BigEndian.Uint64(body)]; ok && r.consensus != nil {
+		for _, sig := range r.consensus.Signatures {
+			b = append(b, sig.Identity...)
+			b = append(b, sig.Signature...)
+		}
+	}
+
+	a.mu.Unlock()
+
+	return writeBlob(s, out, msgSignatures, b)
+}
+
+func parseSignatures(b []byte) ([]Signature, error) {
+	const size = ed25519.PublicKeySize + ed25519.SignatureSize
+
+	if len(b)%size != 0 {
+		return nil, fmt.Errorf("bad signatures")
+	}
+
+	var out []Signature
+	for c := range slices.Chunk(b, size) {
+		out = append(out, Signature{
+			Identity:  ed25519.PublicKey(c[:ed25519.PublicKeySize]),
+			Signature: c[ed25519.PublicKeySize:],
+		})
+	}
+
+	return out, nil
+}
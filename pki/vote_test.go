@@ -0,0 +1,521 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/descriptor"
+	"github.com/LibSEA/mixnet/store"
+	"github.com/flynn/noise"
+)
+
+// logs collects the logs of a cluster.
+type logs struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (l *logs) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.b.Write(p)
+}
+
+func (l *logs) has(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return strings.Contains(l.b.String(), s)
+}
+
+// cluster is a network of authorities on loopback.
+type cluster struct {
+	auths []*Authority
+	privs []ed25519.PrivateKey
+	links []noise.DHKey
+	addrs []string
+	logs  logs
+
+	mu sync.Mutex
+	// side maps authorities to the side of a partition they are on.
+	side map[int]int
+}
+
+func newCluster(t *testing.T, clk clock.Clock, n int) *cluster {
+	c := cluster{side: make(map[int]int)}
+
+	var lns []net.Listener
+
+	for range n {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = ln.Close() })
+
+		_, priv, _ := ed25519.GenerateKey(rand.Reader)
+
+		link, err := cipherSuite.GenerateKeypair(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lns = append(lns, ln)
+		c.addrs = append(c.addrs, ln.Addr().String())
+		c.privs = append(c.privs, priv)
+		c.links = append(c.links, link)
+	}
+
+	for i := range n {
+		var peers []Peer
+		for j := range n {
+			if j != i {
+				peers = append(peers, Peer{
+					Addr:     c.addrs[j],
+					Identity: c.privs[j].Public().(ed25519.PublicKey),
+					Link:     c.links[j].Public,
+				})
+			}
+		}
+
+		a, err := NewAuthority(AuthorityOptions{
+			Identity:   c.privs[i],
+			PrivateKey: c.links[i].Private,
+			PublicKey:  c.links[i].Public,
+			Storage:    store.NewMemory(clk),
+			Clock:      clk,
+			Logger:     slog.New(slog.NewTextHandler(&c.logs, nil)),
+			Peers:      peers,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c.mu.Lock()
+			cut := c.side[i] != c.side[slices.Index(c.addrs, addr)]
+			c.mu.Unlock()
+
+			if cut {
+				return nil, errors.New("partitioned")
+			}
+
+			return new(net.Dialer).DialContext(ctx, network, addr)
+		}
+
+		go func() { _ = a.Serve(lns[i]) }()
+
+		c.auths = append(c.auths, a)
+	}
+
+	return &c
+}
+
+func (c *cluster) identities() []ed25519.PublicKey {
+	var out []ed25519.PublicKey
+	for _, p := range c.privs {
+		out = append(out, p.Public().(ed25519.PublicKey))
+	}
+
+	return out
+}
+
+func (c *cluster) upload(t *testing.T, n *node, to ...int) {
+	for _, i := range to {
+		d, err := descriptor.Parse(n.desc.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.auths[i].Upload(d, n.link.Public); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// run makes authorities which vote on epoch e and then agree on it.
+func (c *cluster) run(ctx context.Context, clk *clock.Fake, e uint64, which ...int) []error {
+	clk.Set(voteAt(e))
+
+	for _, i := range which {
+		_ = c.auths[i].vote(ctx, e)
+	}
+
+	clk.Set(consensusAt(e))
+
+	var errs []error
+	for _, i := range which {
+		errs = append(errs, c.auths[i].agree(ctx, e))
+	}
+
+	return errs
+}
+
+// document checks that authority i has a valid document for e and returns it.
+func (c *cluster) document(t *testing.T, i int, e uint64) *Document {
+	b, err := c.auths[i].Document(e)
+	if err != nil {
+		t.Fatalf("authority %d has no document. %v", i, err)
+	}
+
+	doc, err := VerifyDocument(b, c.identities(), len(c.auths)/2+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return doc
+}
+
+func inDocument(doc *Document, n *node) bool {
+	return slices.ContainsFunc(doc.Nodes, func(d *descriptor.Descriptor) bool {
+		return d.Identity.Equal(n.priv.Public())
+	})
+}
+
+func TestConsensus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clk := clock.NewFake(start)
+	c := newCluster(t, clk, 5)
+
+	var nodes []*node
+	for i := range 4 {
+		n := newNode(t, clk, uint8(i%3))
+		c.upload(t, n, 0, 1, 2, 3, 4)
+		nodes = append(nodes, n)
+	}
+
+	few := newNode(t, clk, 0)
+	c.upload(t, few, 0, 1)
+
+	most := newNode(t, clk, 0)
+	c.upload(t, most, 0, 1, 2)
+
+	// only authority 0 has the newest descriptor of node 0.
+	clk.Advance(time.Second)
+	nodes[0].desc.Published = clk.Now()
+	nodes[0].sign(t)
+	c.upload(t, nodes[0], 0)
+
+	for i, err := range c.run(ctx, clk, 11, 0, 1, 2, 3, 4) {
+		if err != nil {
+			t.Fatalf("authority %d didn't agree. %v", i, err)
+		}
+	}
+
+	want := c.document(t, 0, 11)
+
+	for i := range c.auths {
+		doc := c.document(t, i, 11)
+		if !bytes.Equal(doc.body(), want.body()) {
+			t.Fatal("all authorities should have the same document")
+		}
+	}
+
+	if len(want.Nodes) != 5 || !inDocument(want, most) || inDocument(want, few) {
+		t.Fatal("document should list the nodes most authorities know")
+	}
+
+	if !bytes.Equal(want.Nodes[slices.IndexFunc(want.Nodes, func(d *descriptor.Descriptor) bool {
+		return d.Identity.Equal(nodes[0].priv.Public())
+	})].Bytes(), nodes[0].desc.Bytes()) {
+		t.Fatal("document should have the newest descriptor of a node")
+	}
+
+	if len(c.document(t, 4, 11).Signatures) != 5 {
+		t.Fatal("the last authority should have every signature")
+	}
+}
+
+func TestPartition(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clk := clock.NewFake(start)
+	c := newCluster(t, clk, 5)
+
+	for range 3 {
+		c.upload(t, newNode(t, clk, 0), 0, 1, 2, 3, 4)
+	}
+
+	c.side[3], c.side[4] = 1, 1
+
+	errs := c.run(ctx, clk, 11, 0, 1, 2, 3, 4)
+
+	for i := range 3 {
+		if errs[i] != nil {
+			t.Fatalf("majority authority %d should agree. %v", i, errs[i])
+		}
+
+		if doc := c.document(t, i, 11); len(doc.Nodes) != 3 || len(doc.Signatures) != 3 {
+			t.Fatal("majority should sign a document of all nodes")
+		}
+	}
+
+	for i := 3; i < 5; i++ {
+		if errs[i] == nil {
+			t.Fatalf("minority authority %d should not agree", i)
+		}
+
+		if _, err := c.auths[i].Document(11); !errors.Is(err, ErrNotReady) {
+			t.Fatal("minority should have no document")
+		}
+	}
+
+	if !c.logs.has("authority did not vote") {
+		t.Fatal("missing votes should be logged")
+	}
+}
+
+func TestSilentAuthority(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clk := clock.NewFake(start)
+	c := newCluster(t, clk, 5)
+
+	n := newNode(t, clk, 0)
+	c.upload(t, n, 0, 1, 2, 3, 4)
+
+	for i, err := range c.run(ctx, clk, 11, 0, 1, 2, 3) {
+		if err != nil {
+			t.Fatalf("authority %d didn't agree. %v", i, err)
+		}
+	}
+
+	for i := range 4 {
+		if doc := c.document(t, i, 11); !inDocument(doc, n) || len(doc.Signatures) != 4 {
+			t.Fatal("authorities that voted should sign the document")
+		}
+	}
+
+	silent := hex.EncodeToString(c.privs[4].Public().(ed25519.PublicKey))
+	if !c.logs.has(`authority did not vote" authority=` + silent) {
+		t.Fatal("the silent authority should be logged")
+	}
+
+	// coming back late it signs the document the others made.
+	clk.Set(EpochStart(11))
+
+	if err := c.auths[4].vote(ctx, 11); err == nil {
+		t.Fatal("a late authority should not vote")
+	}
+
+	if err := c.auths[4].agree(ctx, 11); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(c.document(t, 4, 11).body(), c.document(t, 0, 11).body()) ||
+		len(c.document(t, 0, 11).Signatures) != 5 {
+		t.Fatal("a late authority should agree with the others")
+	}
+}
+
+func TestMaliciousVote(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clk := clock.NewFake(start)
+	c := newCluster(t, clk, 5)
+	evil := c.auths[4]
+
+	var nodes []*node
+	for range 3 {
+		n := newNode(t, clk, 0)
+		c.upload(t, n, 0, 1, 2, 3, 4)
+		nodes = append(nodes, n)
+	}
+
+	fake := newNode(t, clk, 0)
+
+	clk.Set(voteAt(11))
+
+	// authority 4 tells 0 and 1 about a node nobody else knows, and 2 and 3
+	// about no nodes at all.
+	send := func(doc *Document, to ...int) {
+		for _, i := range to {
+			_ = call(ctx, evil.dial, c.addrs[i], evil.kp, msgVote, doc.Bytes())
+		}
+	}
+
+	one := Document{Epoch: 11, Nodes: []*descriptor.Descriptor{&fake.desc}}
+	one.Sign(c.privs[4])
+	send(&one, 0, 1)
+
+	other := Document{Epoch: 11}
+	other.Sign(c.privs[4])
+	send(&other, 2, 3)
+
+	// votes of anyone else are refused.
+	_, outsider, _ := ed25519.GenerateKey(rand.Reader)
+	stranger := Document{Epoch: 11, Nodes: []*descriptor.Descriptor{&fake.desc}}
+	stranger.Sign(outsider)
+
+	err := call(ctx, evil.dial, c.addrs[0], evil.kp, msgVote, stranger.Bytes())
+	if err == nil {
+		t.Fatal("a vote from outside should be refused")
+	}
+
+	for i, err := range c.run(ctx, clk, 11, 0, 1, 2, 3) {
+		if err != nil {
+			t.Fatalf("authority %d didn't agree. %v", i, err)
+		}
+	}
+
+	want := c.document(t, 0, 11)
+
+	for i := range 4 {
+		doc := c.document(t, i, 11)
+		if !bytes.Equal(doc.body(), want.body()) || len(doc.Nodes) != 3 || inDocument(doc, fake) {
+			t.Fatal("honest authorities should leave out the conflicting votes")
+		}
+	}
+
+	if !c.logs.has("authority sent conflicting votes") {
+		t.Fatal("conflicting votes should be logged")
+	}
+
+	// a signature over another document is refused.
+	body := binary.BigEndian.AppendUint64(nil, 11)
+	body = append(body, c.privs[4].Public().(ed25519.PublicKey)...)
+	body = append(body, ed25519.Sign(c.privs[4], []byte("something else"))...)
+
+	if err := call(ctx, evil.dial, c.addrs[0], evil.kp, msgSignature, body); err == nil {
+		t.Fatal("a bad signature should be refused")
+	}
+
+	if !c.logs.has("authority signed a different consensus") {
+		t.Fatal("disagreeing authorities should be logged")
+	}
+}
+
+func TestForgedSignatures(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clk := clock.NewFake(start)
+	c := newCluster(t, clk, 3)
+
+	n := newNode(t, clk, 0)
+	c.upload(t, n, 0, 1, 2)
+
+	clk.Set(voteAt(11))
+
+	for i := range 3 {
+		_ = c.auths[i].vote(ctx, 11)
+	}
+
+	// early signatures in the name of every authority, from anyone.
+	stranger, err := cipherSuite.GenerateKeypair(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range c.identities() {
+		for i := range 10 {
+			body := binary.BigEndian.AppendUint64(nil, 11)
+			body = append(body, id...)
+			body = append(body, bytes.Repeat([]byte{byte(i)}, ed25519.SignatureSize)...)
+
+			if err := call(ctx, c.auths[0].dial, c.addrs[0], stranger, msgSignature, body); err == nil {
+				t.Fatal("a signature from another link key should be refused")
+			}
+		}
+	}
+
+	// authority 0 makes its consensus, but the signatures of the others
+	// don't reach it.
+	clk.Set(consensusAt(11))
+	c.side[0] = 1
+
+	for i := range 3 {
+		if err := c.auths[i].agree(ctx, 11); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := c.auths[0].Document(11); !errors.Is(err, ErrNotReady) {
+		t.Fatal("authority 0 should miss signatures")
+	}
+
+	if next := c.auths[0].step(ctx); !next.Equal(clk.Now().Add(signatureRetry)) {
+		t.Fatalf("missing signatures should be asked for again soon. %v", next)
+	}
+
+	c.side[0] = 0
+
+	if next := c.auths[0].step(ctx); !next.Equal(voteAt(12)) {
+		t.Fatalf("gathering the signatures should finish the round. %v", next)
+	}
+
+	if doc := c.document(t, 0, 11); !inDocument(doc, n) || len(doc.Signatures) != 3 {
+		t.Fatal("authority 0 should get the signatures it missed")
+	}
+
+	if c.logs.has("authority signed a different consensus") {
+		t.Fatal("forged signatures should not be blamed on authorities")
+	}
+}
+
+func TestStep(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(start)
+	sut := newAuthority(t, clk)
+
+	n := newNode(t, clk, 0)
+	if err := sut.Upload(&n.desc, n.link.Public); err != nil {
+		t.Fatal(err)
+	}
+
+	if next := sut.step(ctx); !next.Equal(voteAt(11)) {
+		t.Fatalf("should wait for the vote. %v", next)
+	}
+
+	clk.Set(voteAt(11))
+
+	if next := sut.step(ctx); !next.Equal(consensusAt(11)) {
+		t.Fatalf("should vote and wait for the consensus. %v", next)
+	}
+
+	clk.Set(consensusAt(11))
+
+	if next := sut.step(ctx); !next.Equal(voteAt(12)) {
+		t.Fatalf("should agree and wait for the next vote. %v", next)
+	}
+
+	if _, err := sut.Document(11); err != nil {
+		t.Fatal(err)
+	}
+}
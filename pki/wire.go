@@ -0,0 +1,202 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package pki
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/LibSEA/mixnet/session"
+)
+
+/*
+Requests and responses are session messages of
+
+	version (1) | type (1) | body
+
+The body depends on the type:
+
+	upload     signed descriptor
+	fetch      epoch (8)
+	ok
+	document   length (4)
+	error      message
+	vote       length (4)
+	get votes  epoch (8)
+	votes      length (4)
+	signature  epoch (8) | identity (32) | signature (64)
+	get signatures  epoch (8)
+	signatures      length (4)
+
+Documents, votes and signatures don't have to fit in one message, so the
+message with their length is followed by the data itself, split into
+messages of up to chunkSize. The data of votes is
+
+	count (1) | [length (4) | vote]...
+
+and the one of signatures
+
+	[identity (32) | signature (64)]...
+
+Vote, get votes, signature and get signatures are sent between authorities.
+*/
+const wireVersion = 0x1
+
+type msgType byte
+
+const (
+	msgUpload msgType = iota + 1
+	msgFetch
+	msgOK
+	msgDocument
+	msgError
+	msgVote
+	msgGetVotes
+	msgVotes
+	msgSignature
+	msgGetSignatures
+	msgSignatures
+)
+
+const (
+	// chunkSize keeps messages well under what a session can carry.
+	chunkSize = 16384
+
+	// maxDocumentSize bounds what a client downloads.
+	maxDocumentSize = 16 << 20
+)
+
+var errShortMessage = errors.New("message too short")
+
+func encode(typ msgType, body []byte) []byte {
+	return append([]byte{wireVersion, byte(typ)}, body...)
+}
+
+func decode(b []byte) (msgType, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, errShortMessage
+	}
+
+	if b[0] != wireVersion {
+		return 0, nil, fmt.Errorf("unsupported pki version %d", b[0])
+	}
+
+	return msgType(b[1]), b[2:], nil
+}
+
+// writeBlob sends b as typ, split as described above.
+func writeBlob(s *session.Session, out []byte, typ msgType, b []byte) error {
+	err := s.WriteMessage(out, encode(typ, binary.BigEndian.AppendUint32(nil, uint32(len(b)))))
+	if err != nil {
+		return err
+	}
+
+	for chunk := range slices.Chunk(b, chunkSize) {
+		err = s.WriteMessage(out, chunk)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readBlob reads the data that follows a message with body.
+func readBlob(s *session.Session, buf []byte, body []byte) ([]byte, error) {
+	if len(body) != 4 {
+		return nil, errShortMessage
+	}
+
+	n := int(binary.BigEndian.Uint32(body))
+	if n > maxDocumentSize {
+		return nil, fmt.Errorf("data too large %d", n)
+	}
+
+	// n comes from the peer, so memory is only taken as the data arrives.
+	b := make([]byte, 0, min(n, chunkSize))
+	for len(b) < n {
+		chunk, err := s.ReadMessage(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read data. %w", err)
+		}
+
+		b = append(b, chunk...)
+	}
+
+	return b[:n], nil
+}
+
+// reader reads big endian fields, remembering the first error.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if len(r.b) < n {
+		r.err = errShortMessage
+		return nil
+	}
+
+	out := r.b[:n]
+	r.b = r.b[n:]
+
+	return out
+}
+
+func (r *reader) byte() byte {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+
+	return b[0]
+}
+
+func (r *reader) uint16() uint16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *reader) uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *reader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(b)
+}
@@ -0,0 +1,76 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package replay
+
+import (
+	"hash/maphash"
+	"math"
+)
+
+// bloom is a Bloom filter using double hashing with two random seeds.
+type bloom struct {
+	bits  []uint64
+	m     uint64
+	k     int
+	seeds [2]maphash.Seed
+}
+
+/*
+newBloom sizes a filter for n items with false positive rate p. n has to be
+positive, New checks it.
+*/
+func newBloom(n int, p float64) *bloom {
+	if n <= 0 {
+		panic("replay: bloom filter for no items")
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := max(int(math.Round(float64(m)/float64(n)*math.Ln2)), 1)
+
+	return &bloom{
+		bits:  make([]uint64, (m+63)/64),
+		m:     m,
+		k:     k,
+		seeds: [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+	}
+}
+
+func (b *bloom) add(v []byte) {
+	h1, h2 := b.hash(v)
+	for i := range b.k {
+		n := (h1 + uint64(i)*h2) % b.m
+		b.bits[n/64] |= 1 << (n % 64)
+	}
+}
+
+func (b *bloom) has(v []byte) bool {
+	h1, h2 := b.hash(v)
+	for i := range b.k {
+		n := (h1 + uint64(i)*h2) % b.m
+		if b.bits[n/64]&(1<<(n%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (b *bloom) hash(v []byte) (uint64, uint64) {
+	return maphash.Bytes(b.seeds[0], v), maphash.Bytes(b.seeds[1], v) | 1
+}
@@ -0,0 +1,164 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/store"
+)
+
+// Storage is the part of store.Storage the cache needs.
+type Storage interface {
+	Get(key []byte) ([]byte, error)
+	Put(key []byte, value []byte, ttl time.Duration) error
+	DropPrefix(prefix []byte) error
+}
+
+/*
+Cache remembers the replay tags of processed packets for ttl, which should be
+at least as long as the mix key the tags belong to is in use. After that the
+key is gone and old packets fail to unwrap anyway.
+
+Tags are written to the storage, but most tags are new, and a Bloom filter in
+front of it answers "never seen" without a read. The filter is split in two
+generations that rotate every ttl, so it only holds tags that could still be
+in the storage. A fresh cache doesn't know the tags stored before it started,
+so for the first ttl every tag is checked against the storage.
+*/
+type Cache struct {
+	mu      sync.Mutex
+	storage Storage
+	prefix  []byte
+	ttl     time.Duration
+	clock   clock.Clock
+	n       int
+
+	cur     *bloom
+	prev    *bloom
+	rotated time.Time
+	warm    time.Time
+	dropped bool
+}
+
+const falsePositiveRate = 0.001
+
+var ErrDropped = errors.New("replay cache was dropped")
+
+/*
+New makes a cache storing tags under prefix. n is about how many tags arrive
+in one ttl and has to be positive.
+*/
+func New(s Storage, c clock.Clock, prefix []byte, ttl time.Duration, n int) (*Cache, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("replay cache capacity should be positive, got %d", n)
+	}
+
+	now := c.Now()
+
+	return &Cache{
+		storage: s,
+		prefix:  prefix,
+		ttl:     ttl,
+		clock:   c,
+		n:       n,
+		cur:     newBloom(n, falsePositiveRate),
+		prev:    newBloom(n, falsePositiveRate),
+		rotated: now,
+		warm:    now.Add(ttl),
+	}, nil
+}
+
+// Check records tag and reports whether it was already recorded.
+func (c *Cache) Check(tag []byte) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dropped {
+		return false, ErrDropped
+	}
+
+	now := c.clock.Now()
+	c.rotate(now)
+
+	key := append(c.prefix[:len(c.prefix):len(c.prefix)], tag...)
+
+	if !now.Before(c.warm) && !c.cur.has(tag) && !c.prev.has(tag) {
+		return false, c.record(key, tag)
+	}
+
+	_, err := c.storage.Get(key)
+	if err == nil {
+		return true, nil
+	}
+
+	if !errors.Is(err, store.ErrKeyMissing) {
+		return false, fmt.Errorf("failed to look up replay tag. %w", err)
+	}
+
+	return false, c.record(key, tag)
+}
+
+func (c *Cache) record(key, tag []byte) error {
+	err := c.storage.Put(key, nil, c.ttl)
+	if err != nil {
+		return fmt.Errorf("failed to store replay tag. %w", err)
+	}
+
+	c.cur.add(tag)
+
+	return nil
+}
+
+func (c *Cache) rotate(now time.Time) {
+	if now.Sub(c.rotated) < c.ttl {
+		return
+	}
+
+	// after a long pause both generations are too old.
+	if now.Sub(c.rotated) >= 2*c.ttl {
+		c.prev = newBloom(c.n, falsePositiveRate)
+	} else {
+		c.prev = c.cur
+	}
+
+	c.cur = newBloom(c.n, falsePositiveRate)
+	c.rotated = now
+}
+
+/*
+Drop deletes every tag of the cache from the storage. It is for when the
+mix key the tags belong to is destroyed. Check fails with ErrDropped after.
+*/
+func (c *Cache) Drop() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.storage.DropPrefix(c.prefix)
+	if err != nil {
+		return fmt.Errorf("failed to drop replay tags. %w", err)
+	}
+
+	c.dropped = true
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+	"github.com/LibSEA/mixnet/store"
+)
+
+// counting counts reads so tests can see the bloom filter skip them.
+type counting struct {
+	*store.Memory
+	gets int
+}
+
+func (s *counting) Get(key []byte) ([]byte, error) {
+	s.gets++
+	return s.Memory.Get(key)
+}
+
+func TestCache(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := &counting{Memory: store.NewMemory(clk)}
+	sut, err := New(s, clk, []byte("replay/"), time.Minute, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen, err := sut.Check([]byte("a"))
+	if err != nil || seen {
+		t.Fatalf("new tag should not be seen. %t %v", seen, err)
+	}
+
+	seen, err = sut.Check([]byte("a"))
+	if err != nil || !seen {
+		t.Fatalf("second check should be a replay. %t %v", seen, err)
+	}
+
+	if _, err := s.Memory.Get([]byte("replay/a")); err != nil {
+		t.Fatal("tag should be stored under prefix")
+	}
+
+	// still warming up so every check reads the storage.
+	if s.gets != 2 {
+		t.Fatalf("expected 2 reads while warming up. got %d", s.gets)
+	}
+
+	clk.Advance(time.Minute)
+	s.gets = 0
+
+	for i := range 100 {
+		seen, err := sut.Check(fmt.Appendf(nil, "tag %d", i))
+		if err != nil || seen {
+			t.Fatalf("new tag should not be seen. %t %v", seen, err)
+		}
+	}
+
+	if s.gets > 1 {
+		t.Fatalf("bloom filter should skip reads for new tags. got %d", s.gets)
+	}
+
+	seen, _ = sut.Check([]byte("tag 5"))
+	if !seen {
+		t.Fatal("replay should be caught")
+	}
+
+	// a tag stays in the filter for at least one ttl.
+	clk.Advance(59 * time.Second)
+	seen, _ = sut.Check([]byte("tag 6"))
+	if !seen {
+		t.Fatal("replay within ttl should be caught")
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	for _, n := range []int{0, -1} {
+		if _, err := New(store.NewMemory(clk), clk, []byte("replay/"), time.Minute, n); err == nil {
+			t.Fatalf("capacity %d should be refused", n)
+		}
+	}
+}
+
+func TestDrop(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := store.NewMemory(clk)
+
+	old, _ := New(s, clk, []byte("replay/old/"), time.Minute, 1000)
+	cur, _ := New(s, clk, []byte("replay/cur/"), time.Minute, 1000)
+
+	_, _ = old.Check([]byte("a"))
+	_, _ = cur.Check([]byte("a"))
+
+	err := old.Drop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get([]byte("replay/old/a")); err == nil {
+		t.Fatal("dropped tags should be deleted")
+	}
+
+	seen, err := cur.Check([]byte("a"))
+	if err != nil || !seen {
+		t.Fatal("tags of other keys should be kept")
+	}
+
+	if _, err := old.Check([]byte("b")); !errors.Is(err, ErrDropped) {
+		t.Fatalf("a dropped cache should not be used. %v", err)
+	}
+}
+
+func TestBloom(t *testing.T) {
+	b := newBloom(1000, 0.01)
+
+	for i := range 1000 {
+		b.add(fmt.Appendf(nil, "in %d", i))
+	}
+
+	for i := range 1000 {
+		if !b.has(fmt.Appendf(nil, "in %d", i)) {
+			t.Fatal("bloom filter can't have false negatives")
+		}
+	}
+
+	fp := 0
+	for i := range 10000 {
+		if b.has(fmt.Appendf(nil, "out %d", i)) {
+			fp++
+		}
+	}
+
+	if fp > 300 {
+		t.Fatalf("false positive rate too high. %d of 10000", fp)
+	}
+}
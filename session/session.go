@@ -28,12 +28,17 @@ import (
 )
 
 type Session struct {
-	l  []byte
+	// separate length buffers so one goroutine can read while another
+	// writes.
+	rl [2]byte
+	wl [2]byte
 	c  io.ReadWriteCloser
 	cs noise.CipherSuite
 	kp noise.DHKey
 	rx *noise.CipherState
 	tx *noise.CipherState
+	// peer is the static key of the other side, known after the handshake.
+	peer []byte
 }
 
 /*
@@ -48,10 +53,6 @@ func New(conn io.ReadWriteCloser, cs noise.CipherSuite, kp noise.DHKey) *Session
 		c:  conn,
 	}
 
-	var b = [2]byte{0, 0}
-
-	ses.l = b[:]
-
 	return &ses
 }
 
@@ -61,6 +62,12 @@ func (s *Session) Reinit(conn io.ReadWriteCloser, cs noise.CipherSuite, kp noise
 	s.kp = kp
 	s.rx = nil
 	s.tx = nil
+	s.peer = nil
+}
+
+// PeerStatic returns the static public key the peer proved in the handshake.
+func (s *Session) PeerStatic() []byte {
+	return s.peer
 }
 
 func (s *Session) ReadMessage(out []byte) ([]byte, error) {
@@ -92,7 +99,7 @@ func (s *Session) WriteMessage(out []byte, in []byte) error {
 }
 
 func (s *Session) read(out []byte) ([]byte, error) {
-	var ls = s.l[:]
+	var ls = s.rl[:]
 
 	_, err := io.ReadFull(s.c, ls)
 	if err != nil {
@@ -100,6 +107,9 @@ func (s *Session) read(out []byte) ([]byte, error) {
 	}
 
 	l := binary.BigEndian.Uint16(ls)
+	if int(l) > len(out) {
+		return nil, fmt.Errorf("message of %d bytes is larger than the buffer", l)
+	}
 
 	var ret = out[:l]
 
@@ -116,7 +126,7 @@ func (s *Session) write(payload []byte) error {
 		return fmt.Errorf("message payload too large")
 	}
 
-	var ls = s.l[:]
+	var ls = s.wl[:]
 
 	binary.BigEndian.PutUint16(ls, uint16(len(payload)))
 
@@ -201,6 +211,8 @@ func (s *Session) ClientHandshake(out []byte) error {
 		return fmt.Errorf("ClientHandshake -> s, dhse. %w", err)
 	}
 
+	s.peer = hs.PeerStatic()
+
 	return nil
 }
 
@@ -248,6 +260,8 @@ func (s *Session) ServerHandshake(out []byte) error {
 		return fmt.Errorf("ServerHandshake -> s, dhse failed. %w", err)
 	}
 
+	s.peer = hs.PeerStatic()
+
 	return nil
 }
 
@@ -0,0 +1,54 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package session
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"testing"
+
+	"github.com/flynn/noise"
+)
+
+func TestOversizedFrame(t *testing.T) {
+	cs := noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2b)
+
+	kp, err := cs.GenerateKeypair(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	go func() {
+		// a version byte and a length larger than the buffer.
+		b := binary.BigEndian.AppendUint16([]byte{0x1}, math.MaxUint16)
+		_, _ = client.Write(b)
+		_, _ = client.Write(make([]byte, math.MaxUint16))
+	}()
+
+	s := New(server, cs, kp)
+	defer func() { _ = s.Close() }()
+
+	err = s.ServerHandshake(make([]byte, math.MaxInt16))
+	if err == nil {
+		t.Fatal("a frame larger than the buffer should fail the handshake")
+	}
+}
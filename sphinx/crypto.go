@@ -0,0 +1,101 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package sphinx
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+var zeroNonce [chacha20.NonceSize]byte
+
+// hopKeys are the keys one hop derives from its shared secret.
+type hopKeys struct {
+	rho [32]byte   // header stream key
+	mu  [32]byte   // header MAC key
+	pi  lionessKey // payload key
+	tau [TagSize]byte
+}
+
+func deriveKeys(secret []byte) (*hopKeys, error) {
+	var k hopKeys
+
+	r := hkdf.New(sha256.New, secret, nil, []byte("mixnet sphinx v1"))
+
+	for _, b := range [][]byte{k.rho[:], k.mu[:], k.pi[:], k.tau[:]} {
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive hop keys. %w", err)
+		}
+	}
+
+	return &k, nil
+}
+
+// blind returns the blinding factor applied to alpha before the next hop.
+func blind(alpha, secret []byte) []byte {
+	h := sha256.New()
+	_, _ = h.Write([]byte("mixnet sphinx blind"))
+	_, _ = h.Write(alpha)
+	_, _ = h.Write(secret)
+
+	return h.Sum(nil)
+}
+
+func mac(key, data []byte) [MACSize]byte {
+	var out [MACSize]byte
+
+	m := hmac.New(sha256.New, key)
+	_, _ = m.Write(data)
+	copy(out[:], m.Sum(nil))
+
+	return out
+}
+
+func stream(key []byte, n int) []byte {
+	c, err := chacha20.NewUnauthenticatedCipher(key, zeroNonce[:])
+	if err != nil {
+		panic(err)
+	}
+
+	out := make([]byte, n)
+	c.XORKeyStream(out, out)
+
+	return out
+}
+
+func x25519(scalar, point []byte) ([]byte, error) {
+	out, err := curve25519.X25519(scalar, point)
+	if err != nil {
+		return nil, fmt.Errorf("x25519 failed. %w", err)
+	}
+
+	return out, nil
+}
+
+func xor(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = a[i] ^ b[i]
+	}
+}
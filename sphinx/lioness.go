@@ -0,0 +1,83 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package sphinx
+
+import (
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+/*
+LIONESS wide-block cipher built from ChaCha20 as the stream cipher and keyed
+BLAKE2b-256 as the hash. The block is split into a 32 byte left half and a
+right half holding the rest. Every bit of the output depends on every bit of
+the input, so tampering with any part of the payload garbles all of it.
+*/
+const (
+	lionessHalf   = 32
+	lionessKeyLen = 4 * lionessHalf
+)
+
+type lionessKey [lionessKeyLen]byte
+
+func (k *lionessKey) part(i int) []byte {
+	return k[i*lionessHalf : (i+1)*lionessHalf]
+}
+
+func lionessEncrypt(k *lionessKey, block []byte) {
+	l, r := block[:lionessHalf], block[lionessHalf:]
+
+	lionessStream(l, k.part(0), r)
+	lionessHash(k.part(1), r, l)
+	lionessStream(l, k.part(2), r)
+	lionessHash(k.part(3), r, l)
+}
+
+func lionessDecrypt(k *lionessKey, block []byte) {
+	l, r := block[:lionessHalf], block[lionessHalf:]
+
+	lionessHash(k.part(3), r, l)
+	lionessStream(l, k.part(2), r)
+	lionessHash(k.part(1), r, l)
+	lionessStream(l, k.part(0), r)
+}
+
+// lionessStream xors r with the ChaCha20 keystream keyed by l ^ k.
+func lionessStream(l, k, r []byte) {
+	var key [lionessHalf]byte
+	xor(key[:], l, k)
+
+	c, err := chacha20.NewUnauthenticatedCipher(key[:], zeroNonce[:])
+	if err != nil {
+		// key and nonce sizes are constant so this cannot happen.
+		panic(err)
+	}
+
+	c.XORKeyStream(r, r)
+}
+
+// lionessHash xors l with the BLAKE2b-256 hash of r keyed by k.
+func lionessHash(k, r, l []byte) {
+	h, err := blake2b.New256(k)
+	if err != nil {
+		panic(err)
+	}
+
+	_, _ = h.Write(r)
+	xor(l, l, h.Sum(nil))
+}
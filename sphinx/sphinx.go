@@ -0,0 +1,444 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package sphinx
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+/*
+Sphinx packets are a fixed size no matter how many hops they travel, so a
+mix can't learn its position on the path. A packet is a header followed by
+the payload:
+
+	version (1) | alpha (32) | beta (BetaSize) | gamma (32) | payload
+
+alpha is the blinded group element the hop combines with its private key to
+get the shared secret, beta is the encrypted routing information for every
+hop and gamma is the MAC of beta. Each hop strips one layer of routing
+information and one layer of payload encryption.
+*/
+const (
+	Version = 0x1
+
+	KeySize = curve25519.PointSize
+	MACSize = 32
+	IDSize  = 16
+	TagSize = 32
+	MaxHops = 5
+
+	addrSize    = 16 + 2
+	routingSize = 1 + addrSize + 4 + IDSize
+	hopSize     = routingSize + MACSize
+
+	BetaSize    = MaxHops * hopSize
+	HeaderSize  = 1 + KeySize + BetaSize + MACSize
+	PayloadSize = 2048
+	PacketSize  = HeaderSize + PayloadSize
+
+	// the payload starts with zeroes the final hop checks to detect
+	// tampering, then two bytes of message length.
+	zeroPrefix     = 16
+	MaxMessageSize = PayloadSize - zeroPrefix - 2
+)
+
+type Command byte
+
+const (
+	// CommandRelay forwards the packet to Result.Next.
+	CommandRelay Command = iota + 1
+	// CommandDeliver means this hop is the last one on the path.
+	CommandDeliver
+)
+
+var (
+	ErrInvalidMAC     = errors.New("invalid header mac")
+	ErrInvalidPayload = errors.New("payload failed integrity check")
+)
+
+type KeyPair struct {
+	Public  [KeySize]byte
+	Private [KeySize]byte
+}
+
+func GenerateKey(rnd io.Reader) (*KeyPair, error) {
+	var kp KeyPair
+
+	_, err := io.ReadFull(rnd, kp.Private[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key. %w", err)
+	}
+
+	pub, err := x25519(kp.Private[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	copy(kp.Public[:], pub)
+
+	return &kp, nil
+}
+
+// Hop is one mix on the path of a packet.
+type Hop struct {
+	PublicKey [KeySize]byte
+	// Addr is where the previous hop sends the packet.
+	Addr netip.AddrPort
+	// Delay is how long this hop holds the packet before sending it on.
+	Delay time.Duration
+}
+
+type Header struct {
+	Version byte
+	Alpha   [KeySize]byte
+	Beta    [BetaSize]byte
+	Gamma   [MACSize]byte
+}
+
+type Packet struct {
+	Header  Header
+	Payload [PayloadSize]byte
+}
+
+// Result is what a hop learns from unwrapping a packet.
+type Result struct {
+	Command Command
+	Next    netip.AddrPort
+	Delay   time.Duration
+	ID      [IDSize]byte
+	// Tag is unique for every packet and hop. Seeing it twice is a replay.
+	Tag [TagSize]byte
+	// Packet is the packet to send to Next. For CommandDeliver it holds the
+	// decrypted payload, see DecodePayload.
+	Packet *Packet
+}
+
+func Parse(b []byte) (*Packet, error) {
+	if len(b) != PacketSize {
+		return nil, fmt.Errorf("wrong packet size %d", len(b))
+	}
+
+	if b[0] != Version {
+		return nil, fmt.Errorf("unsupported packet version %d", b[0])
+	}
+
+	var p Packet
+
+	p.Header.Version = b[0]
+	b = b[1:]
+	b = b[copy(p.Header.Alpha[:], b):]
+	b = b[copy(p.Header.Beta[:], b):]
+	b = b[copy(p.Header.Gamma[:], b):]
+	copy(p.Payload[:], b)
+
+	return &p, nil
+}
+
+func (p *Packet) Bytes() []byte {
+	out := make([]byte, 0, PacketSize)
+
+	out = append(out, p.Header.Version)
+	out = append(out, p.Header.Alpha[:]...)
+	out = append(out, p.Header.Beta[:]...)
+	out = append(out, p.Header.Gamma[:]...)
+	out = append(out, p.Payload[:]...)
+
+	return out
+}
+
+/*
+NewPacket wraps message for path. The final hop delivers it with the given
+id, which tells that hop who the message is for.
+*/
+func NewPacket(path []Hop, id [IDSize]byte, message []byte) (*Packet, error) {
+	var p Packet
+
+	hdr, keys, err := newHeader(rand.Reader, path, id)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Header = *hdr
+
+	err = encodePayload(p.Payload[:], message)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(keys) - 1; i >= 0; i-- {
+		lionessEncrypt(&keys[i].pi, p.Payload[:])
+	}
+
+	return &p, nil
+}
+
+func newHeader(
+	rnd io.Reader,
+	path []Hop,
+	id [IDSize]byte,
+) (*Header, []*hopKeys, error) {
+	n := len(path)
+	if n == 0 || n > MaxHops {
+		return nil, nil, fmt.Errorf("path must have 1 to %d hops. has %d", MaxHops, n)
+	}
+
+	var x [KeySize]byte
+
+	_, err := io.ReadFull(rnd, x[:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read ephemeral key. %w", err)
+	}
+
+	alpha0, err := x25519(x[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		alpha    = alpha0
+		blinds   = make([][]byte, 0, n)
+		keys     = make([]*hopKeys, n)
+		routings = make([][routingSize]byte, n)
+	)
+
+	for i, hop := range path {
+		s, err := x25519(x[:], hop.PublicKey[:])
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad public key for hop %d. %w", i, err)
+		}
+
+		for _, b := range blinds {
+			s, err = x25519(b, s)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		keys[i], err = deriveKeys(s)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		b := blind(alpha, s)
+		blinds = append(blinds, b)
+
+		alpha, err = x25519(b, alpha)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if i == n-1 {
+			err = encodeRouting(routings[i][:], CommandDeliver, netip.AddrPort{}, hop.Delay, id)
+		} else {
+			// only the last hop learns the id, or every mix could link the
+			// packet to its recipient and to the other hops.
+			err = encodeRouting(routings[i][:], CommandRelay, path[i+1].Addr, hop.Delay, [IDSize]byte{})
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad routing for hop %d. %w", i, err)
+		}
+	}
+
+	filler := make([]byte, 0, (n-1)*hopSize)
+	for i := 0; i < n-1; i++ {
+		filler = append(filler, make([]byte, hopSize)...)
+		st := stream(keys[i].rho[:], BetaSize+hopSize)
+		xor(filler, filler, st[BetaSize+hopSize-len(filler):])
+	}
+
+	var hdr = Header{Version: Version}
+
+	copy(hdr.Alpha[:], alpha0)
+
+	// pad with random bytes so the unused part of beta looks like the rest.
+	_, err = io.ReadFull(rnd, hdr.Beta[:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read header padding. %w", err)
+	}
+
+	var gamma [MACSize]byte
+
+	for i := n - 1; i >= 0; i-- {
+		beta := hdr.Beta[:]
+
+		copy(beta[hopSize:], beta[:BetaSize-hopSize])
+		copy(beta[:routingSize], routings[i][:])
+		copy(beta[routingSize:hopSize], gamma[:])
+		xor(beta, beta, stream(keys[i].rho[:], BetaSize))
+
+		if i == n-1 {
+			copy(beta[BetaSize-len(filler):], filler)
+		}
+
+		gamma = mac(keys[i].mu[:], beta)
+	}
+
+	hdr.Gamma = gamma
+
+	return &hdr, keys, nil
+}
+
+/*
+Unwrap removes one layer from p using the private key of this hop. p is not
+modified.
+*/
+func Unwrap(private *[KeySize]byte, p *Packet) (*Result, error) {
+	if p.Header.Version != Version {
+		return nil, fmt.Errorf("unsupported packet version %d", p.Header.Version)
+	}
+
+	s, err := x25519(private[:], p.Header.Alpha[:])
+	if err != nil {
+		return nil, fmt.Errorf("bad alpha. %w", err)
+	}
+
+	keys, err := deriveKeys(s)
+	if err != nil {
+		return nil, err
+	}
+
+	gamma := mac(keys.mu[:], p.Header.Beta[:])
+	if !hmac.Equal(gamma[:], p.Header.Gamma[:]) {
+		return nil, ErrInvalidMAC
+	}
+
+	var b [BetaSize + hopSize]byte
+
+	copy(b[:], p.Header.Beta[:])
+	xor(b[:], b[:], stream(keys.rho[:], len(b)))
+
+	var res = Result{
+		Tag:    keys.tau,
+		Packet: &Packet{},
+	}
+
+	err = decodeRouting(b[:routingSize], &res)
+	if err != nil {
+		return nil, err
+	}
+
+	next := res.Packet
+
+	next.Payload = p.Payload
+	lionessDecrypt(&keys.pi, next.Payload[:])
+
+	if res.Command == CommandDeliver {
+		return &res, nil
+	}
+
+	alpha, err := x25519(blind(p.Header.Alpha[:], s), p.Header.Alpha[:])
+	if err != nil {
+		return nil, err
+	}
+
+	next.Header.Version = Version
+	copy(next.Header.Alpha[:], alpha)
+	copy(next.Header.Gamma[:], b[routingSize:hopSize])
+	copy(next.Header.Beta[:], b[hopSize:])
+
+	return &res, nil
+}
+
+// DecodePayload returns the message in a payload decrypted by the final hop.
+func DecodePayload(payload []byte) ([]byte, error) {
+	if len(payload) != PayloadSize {
+		return nil, fmt.Errorf("wrong payload size %d", len(payload))
+	}
+
+	for _, b := range payload[:zeroPrefix] {
+		if b != 0 {
+			return nil, ErrInvalidPayload
+		}
+	}
+
+	l := int(binary.BigEndian.Uint16(payload[zeroPrefix:]))
+	if l > MaxMessageSize {
+		return nil, ErrInvalidPayload
+	}
+
+	return payload[zeroPrefix+2 : zeroPrefix+2+l], nil
+}
+
+func encodePayload(out []byte, message []byte) error {
+	if len(message) > MaxMessageSize {
+		return fmt.Errorf("message too large. %d > %d", len(message), MaxMessageSize)
+	}
+
+	clear(out)
+	binary.BigEndian.PutUint16(out[zeroPrefix:], uint16(len(message)))
+	copy(out[zeroPrefix+2:], message)
+
+	return nil
+}
+
+func encodeRouting(
+	out []byte,
+	cmd Command,
+	next netip.AddrPort,
+	delay time.Duration,
+	id [IDSize]byte,
+) error {
+	ms := delay.Milliseconds()
+	if ms < 0 || ms > math.MaxUint32 {
+		return fmt.Errorf("delay out of range %s", delay)
+	}
+
+	if cmd == CommandRelay && !next.IsValid() {
+		return fmt.Errorf("relay hop needs a next address")
+	}
+
+	out[0] = byte(cmd)
+	ip := next.Addr().As16()
+	copy(out[1:], ip[:])
+	binary.BigEndian.PutUint16(out[17:], next.Port())
+	binary.BigEndian.PutUint32(out[19:], uint32(ms))
+	copy(out[23:], id[:])
+
+	return nil
+}
+
+func decodeRouting(b []byte, res *Result) error {
+	res.Command = Command(b[0])
+
+	switch res.Command {
+	case CommandRelay:
+		ip := netip.AddrFrom16([16]byte(b[1:17])).Unmap()
+		res.Next = netip.AddrPortFrom(ip, binary.BigEndian.Uint16(b[17:]))
+	case CommandDeliver:
+	default:
+		return fmt.Errorf("unknown routing command %d", res.Command)
+	}
+
+	res.Delay = time.Duration(binary.BigEndian.Uint32(b[19:])) * time.Millisecond
+
+	if res.Command == CommandDeliver {
+		copy(res.ID[:], b[23:23+IDSize])
+	}
+
+	return nil
+}
@@ -0,0 +1,268 @@
+package sphinx
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+)
+
+func testPath(t *testing.T, n int) ([]Hop, []*KeyPair) {
+	t.Helper()
+
+	var path []Hop
+	var keys []*KeyPair
+
+	for i := 0; i < n; i++ {
+		kp, err := GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys = append(keys, kp)
+		path = append(path, Hop{
+			PublicKey: kp.Public,
+			Addr:      netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(9000+i)),
+			Delay:     time.Duration(i) * time.Second,
+		})
+	}
+
+	return path, keys
+}
+
+func TestRoundTrip(t *testing.T) {
+	for n := 1; n <= MaxHops; n++ {
+		path, keys := testPath(t, n)
+		id := [IDSize]byte{1, 2, 3}
+		msg := []byte("hello mixnet")
+
+		p, err := NewPacket(path, id, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < n; i++ {
+			p, err = Parse(p.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := Unwrap(&keys[i].Private, p)
+			if err != nil {
+				t.Fatalf("hop %d of %d failed unwrap. %s", i, n, err)
+			}
+
+			if res.Delay != path[i].Delay {
+				t.Fatalf("hop %d wrong delay %s", i, res.Delay)
+			}
+
+			if i < n-1 {
+				if res.Command != CommandRelay || res.Next != path[i+1].Addr {
+					t.Fatalf("hop %d should relay to %s. got %d %s",
+						i, path[i+1].Addr, res.Command, res.Next)
+				}
+				p = res.Packet
+				continue
+			}
+
+			if res.Command != CommandDeliver || res.ID != id {
+				t.Fatalf("last hop should deliver. got %d", res.Command)
+			}
+
+			out, err := DecodePayload(res.Packet.Payload[:])
+			if err != nil || !bytes.Equal(out, msg) {
+				t.Fatalf("wrong message %q. %v", out, err)
+			}
+		}
+	}
+}
+
+func TestRelayHidesID(t *testing.T) {
+	path, keys := testPath(t, MaxHops)
+	id := [IDSize]byte{1, 2, 3}
+
+	p, err := NewPacket(path, id, []byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range MaxHops {
+		res, err := Unwrap(&keys[i].Private, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i == MaxHops-1 {
+			if res.ID != id {
+				t.Fatal("the last hop should get the id")
+			}
+			break
+		}
+
+		if res.ID != [IDSize]byte{} {
+			t.Fatalf("relay hop %d should not learn the id", i)
+		}
+
+		p = res.Packet
+	}
+}
+
+func TestTamper(t *testing.T) {
+	path, keys := testPath(t, 3)
+
+	p, err := NewPacket(path, [IDSize]byte{}, []byte("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bad := *p
+	bad.Header.Beta[10] ^= 1
+
+	_, err = Unwrap(&keys[0].Private, &bad)
+	if err != ErrInvalidMAC {
+		t.Fatalf("tampered beta should fail mac. got %v", err)
+	}
+
+	_, err = Unwrap(&keys[1].Private, p)
+	if err != ErrInvalidMAC {
+		t.Fatalf("wrong key should fail mac. got %v", err)
+	}
+
+	bad = *p
+	bad.Payload[100] ^= 1
+
+	for i := range keys {
+		res, err := Unwrap(&keys[i].Private, &bad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bad = *res.Packet
+	}
+
+	_, err = DecodePayload(bad.Payload[:])
+	if err != ErrInvalidPayload {
+		t.Fatalf("tampered payload should fail. got %v", err)
+	}
+}
+
+func TestTagsDiffer(t *testing.T) {
+	path, keys := testPath(t, 2)
+
+	p1, _ := NewPacket(path, [IDSize]byte{}, nil)
+	p2, _ := NewPacket(path, [IDSize]byte{}, nil)
+
+	r1, _ := Unwrap(&keys[0].Private, p1)
+	r1b, _ := Unwrap(&keys[0].Private, p1)
+	r2, _ := Unwrap(&keys[0].Private, p2)
+
+	if r1.Tag != r1b.Tag {
+		t.Fatal("same packet should give same tag")
+	}
+
+	if r1.Tag == r2.Tag {
+		t.Fatal("different packets should give different tags")
+	}
+}
+
+func TestLioness(t *testing.T) {
+	var k lionessKey
+	_, _ = rand.Read(k[:])
+
+	block := make([]byte, 100)
+	_, _ = rand.Read(block)
+	orig := bytes.Clone(block)
+
+	lionessEncrypt(&k, block)
+	if bytes.Equal(block, orig) {
+		t.Fatal("encrypt did nothing")
+	}
+
+	lionessDecrypt(&k, block)
+	if !bytes.Equal(block, orig) {
+		t.Fatal("decrypt should invert encrypt")
+	}
+}
+
+func TestSURB(t *testing.T) {
+	// two mixes then the sender itself
+	path, keys := testPath(t, 3)
+	table := NewReplyTable(clock.Real{}, time.Minute)
+
+	surb, err := table.NewSURB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fwd, body, err := DecodeMessage(EncodeMessage(surb, []byte("ping")))
+	if err != nil || string(body) != "ping" {
+		t.Fatalf("message framing broken. %q %v", body, err)
+	}
+
+	if fwd.FirstHop != path[0].Addr {
+		t.Fatalf("surb should start at first hop. got %s", fwd.FirstHop)
+	}
+
+	p, err := NewReply(fwd, []byte("pong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res *Result
+	for i := range keys {
+		res, err = Unwrap(&keys[i].Private, p)
+		if err != nil {
+			t.Fatalf("hop %d failed. %s", i, err)
+		}
+		p = res.Packet
+	}
+
+	if res.Command != CommandDeliver {
+		t.Fatal("sender should be the last hop")
+	}
+
+	out, err := table.Open(res.ID, p.Payload[:])
+	if err != nil || string(out) != "pong" {
+		t.Fatalf("failed to open reply. %q %v", out, err)
+	}
+
+	_, err = table.Open(res.ID, p.Payload[:])
+	if err != ErrUnknownSURB {
+		t.Fatalf("surb should only open once. got %v", err)
+	}
+}
+
+func TestReplyTableExpiry(t *testing.T) {
+	path, _ := testPath(t, 2)
+	clk := clock.NewFake(time.Unix(1000, 0))
+	table := NewReplyTable(clk, time.Minute)
+
+	_, err := table.NewSURB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(time.Minute)
+
+	_, err = table.NewSURB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if table.Len() != 1 {
+		t.Fatalf("the expired surb should be pruned. got %d entries", table.Len())
+	}
+
+	clk.Advance(time.Minute)
+
+	_, err = table.Open([IDSize]byte{}, nil)
+	if err != ErrUnknownSURB {
+		t.Fatalf("unknown surb should fail. got %v", err)
+	}
+
+	if table.Len() != 0 {
+		t.Fatalf("open should prune expired surbs. got %d entries", table.Len())
+	}
+}
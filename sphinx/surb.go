@@ -0,0 +1,286 @@
+/*
+mixnet - tool to create and manage LibSEA mixnets
+Copyright (C) 2025  Liberatory Sofware Engineering Association
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as
+published by the Free Software Foundation, either version 3 of the
+License, or (at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+package sphinx
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+)
+
+/*
+A single use reply block lets a recipient answer a message without learning
+who sent it. The sender builds a header for the path back to itself and
+sends it, with a payload key, inside its message. The recipient encrypts its
+reply with that key and sends it to the first hop of the header. Every hop
+then decrypts a layer of the payload like for any other packet, and only the
+sender, who knows all the layer keys, can read the reply.
+
+The last hop of the reply path is the sender itself.
+*/
+const SURBSize = addrSize + HeaderSize + lionessKeyLen
+
+var ErrUnknownSURB = errors.New("unknown, expired or already used surb")
+
+type SURB struct {
+	FirstHop netip.AddrPort
+	Header   Header
+	Key      [lionessKeyLen]byte
+}
+
+// replyKeys is what the sender keeps to read the reply to a SURB.
+type replyKeys struct {
+	key     lionessKey
+	hops    []lionessKey
+	expires time.Time
+}
+
+func (s *SURB) Bytes() []byte {
+	out := make([]byte, addrSize, SURBSize)
+
+	ip := s.FirstHop.Addr().As16()
+	copy(out, ip[:])
+	binary.BigEndian.PutUint16(out[16:], s.FirstHop.Port())
+
+	out = append(out, s.Header.Version)
+	out = append(out, s.Header.Alpha[:]...)
+	out = append(out, s.Header.Beta[:]...)
+	out = append(out, s.Header.Gamma[:]...)
+	out = append(out, s.Key[:]...)
+
+	return out
+}
+
+func ParseSURB(b []byte) (*SURB, error) {
+	if len(b) != SURBSize {
+		return nil, fmt.Errorf("wrong surb size %d", len(b))
+	}
+
+	var s SURB
+
+	ip := netip.AddrFrom16([16]byte(b[:16])).Unmap()
+	s.FirstHop = netip.AddrPortFrom(ip, binary.BigEndian.Uint16(b[16:]))
+	b = b[addrSize:]
+
+	s.Header.Version = b[0]
+	if s.Header.Version != Version {
+		return nil, fmt.Errorf("unsupported surb version %d", s.Header.Version)
+	}
+
+	b = b[1:]
+	b = b[copy(s.Header.Alpha[:], b):]
+	b = b[copy(s.Header.Beta[:], b):]
+	b = b[copy(s.Header.Gamma[:], b):]
+	copy(s.Key[:], b)
+
+	return &s, nil
+}
+
+// NewReply wraps message so it travels back along the path of surb.
+func NewReply(surb *SURB, message []byte) (*Packet, error) {
+	var p = Packet{Header: surb.Header}
+
+	err := encodePayload(p.Payload[:], message)
+	if err != nil {
+		return nil, err
+	}
+
+	k := lionessKey(surb.Key)
+	lionessEncrypt(&k, p.Payload[:])
+
+	return &p, nil
+}
+
+/*
+ReplyTable makes SURBs and reads the replies sent with them. A SURB whose
+reply hasn't come within ttl is forgotten, so the keys of lost replies don't
+pile up.
+*/
+type ReplyTable struct {
+	mu      sync.Mutex
+	clock   clock.Clock
+	ttl     time.Duration
+	entries map[[IDSize]byte]*replyKeys
+}
+
+func NewReplyTable(c clock.Clock, ttl time.Duration) *ReplyTable {
+	return &ReplyTable{
+		clock:   c,
+		ttl:     ttl,
+		entries: make(map[[IDSize]byte]*replyKeys),
+	}
+}
+
+// prune drops the expired entries. t.mu must be held.
+func (t *ReplyTable) prune(now time.Time) {
+	for id, rk := range t.entries {
+		if !now.Before(rk.expires) {
+			delete(t.entries, id)
+		}
+	}
+}
+
+/*
+NewSURB makes a SURB for path. The last hop of path must be a key this
+sender holds, its unwrap gives the SURB ID to pass to Open.
+*/
+func (t *ReplyTable) NewSURB(path []Hop) (*SURB, error) {
+	var id [IDSize]byte
+
+	_, err := io.ReadFull(rand.Reader, id[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read surb id. %w", err)
+	}
+
+	hdr, keys, err := newHeader(rand.Reader, path, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var s = SURB{
+		FirstHop: path[0].Addr,
+		Header:   *hdr,
+	}
+
+	_, err = io.ReadFull(rand.Reader, s.Key[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read surb key. %w", err)
+	}
+
+	rk := replyKeys{key: s.Key}
+	for _, k := range keys {
+		rk.hops = append(rk.hops, k.pi)
+	}
+
+	t.mu.Lock()
+	now := t.clock.Now()
+	t.prune(now)
+	rk.expires = now.Add(t.ttl)
+	t.entries[id] = &rk
+	t.mu.Unlock()
+
+	return &s, nil
+}
+
+/*
+Open returns the reply message in payload, which is the payload of a packet
+delivered with id after the last hop unwrapped it. Each SURB opens once,
+and only before it expires.
+*/
+func (t *ReplyTable) Open(id [IDSize]byte, payload []byte) ([]byte, error) {
+	t.mu.Lock()
+	t.prune(t.clock.Now())
+	rk, ok := t.entries[id]
+	delete(t.entries, id)
+	t.mu.Unlock()
+
+	if !ok {
+		return nil, ErrUnknownSURB
+	}
+
+	if len(payload) != PayloadSize {
+		return nil, fmt.Errorf("wrong payload size %d", len(payload))
+	}
+
+	p := make([]byte, PayloadSize)
+	copy(p, payload)
+
+	for i := len(rk.hops) - 1; i >= 0; i-- {
+		lionessEncrypt(&rk.hops[i], p)
+	}
+
+	lionessDecrypt(&rk.key, p)
+
+	return DecodePayload(p)
+}
+
+func (t *ReplyTable) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.entries)
+}
+
+/*
+EncodeMessage frames body for the payload of a packet, with an optional SURB
+the recipient can reply with.
+*/
+func EncodeMessage(surb *SURB, body []byte) []byte {
+	if surb == nil {
+		return append([]byte{0}, body...)
+	}
+
+	return append(append([]byte{1}, surb.Bytes()...), body...)
+}
+
+func DecodeMessage(m []byte) (*SURB, []byte, error) {
+	if len(m) == 0 {
+		return nil, nil, fmt.Errorf("empty message")
+	}
+
+	switch m[0] {
+	case 0:
+		return nil, m[1:], nil
+	case 1:
+		if len(m) < 1+SURBSize {
+			return nil, nil, fmt.Errorf("message too short for surb")
+		}
+		s, err := ParseSURB(m[1 : 1+SURBSize])
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, m[1+SURBSize:], nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown message type %d", m[0])
+}
+
+// ParseHop parses a hop written as ip:port/hex-public-key.
+func ParseHop(s string) (Hop, error) {
+	var h Hop
+
+	addr, key, ok := strings.Cut(s, "/")
+	if !ok {
+		return h, fmt.Errorf("hop %q should be ip:port/key", s)
+	}
+
+	var err error
+
+	h.Addr, err = netip.ParseAddrPort(addr)
+	if err != nil {
+		return h, fmt.Errorf("bad hop address. %w", err)
+	}
+
+	k, err := hex.DecodeString(key)
+	if err != nil || len(k) != KeySize {
+		return h, fmt.Errorf("hop key should be %d hex bytes", KeySize)
+	}
+
+	copy(h.PublicKey[:], k)
+
+	return h, nil
+}
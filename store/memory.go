@@ -0,0 +1,113 @@
+package store
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+)
+
+/*
+Memory is a Storage that keeps everything in a map. Expiry is checked against
+its clock, so tests can use a clock.Fake to move time forward.
+*/
+type Memory struct {
+	mu      sync.Mutex
+	clock   clock.Clock
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	value []byte
+	// expires is zero for keys without a ttl.
+	expires time.Time
+}
+
+var _ Storage = (*Memory)(nil)
+
+func NewMemory(c clock.Clock) *Memory {
+	return &Memory{
+		clock:   c,
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+func (m *Memory) Get(key []byte) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(key)
+	if !ok {
+		return nil, ErrKeyMissing
+	}
+
+	return bytes.Clone(e.value), nil
+}
+
+// Put sets key to value. A ttl of 0 means the key never expires.
+func (m *Memory) Put(key []byte, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[string(key)] = memoryEntry{
+		value:   bytes.Clone(value),
+		expires: m.expiry(ttl),
+	}
+
+	return nil
+}
+
+// Update gives key a new ttl, counting from now.
+func (m *Memory) Update(key []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(key)
+	if !ok {
+		return ErrKeyMissing
+	}
+
+	e.expires = m.expiry(ttl)
+	m.entries[string(key)] = e
+
+	return nil
+}
+
+// DropPrefix deletes every key starting with prefix.
+func (m *Memory) DropPrefix(prefix []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k := range m.entries {
+		if strings.HasPrefix(k, string(prefix)) {
+			delete(m.entries, k)
+		}
+	}
+
+	return nil
+}
+
+// get returns the entry for key, dropping it if it has expired.
+func (m *Memory) get(key []byte) (memoryEntry, bool) {
+	e, ok := m.entries[string(key)]
+	if !ok {
+		return e, false
+	}
+
+	if !e.expires.IsZero() && !m.clock.Now().Before(e.expires) {
+		delete(m.entries, string(key))
+		return e, false
+	}
+
+	return e, true
+}
+
+func (m *Memory) expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+
+	return m.clock.Now().Add(ttl)
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+)
+
+func TestMemory(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	sut := NewMemory(clk)
+
+	_, err := sut.Get([]byte("a"))
+	if !errors.Is(err, ErrKeyMissing) {
+		t.Fatalf("missing key should give ErrKeyMissing. got %v", err)
+	}
+
+	if !errors.Is(sut.Update([]byte("a"), time.Hour), ErrKeyMissing) {
+		t.Fatal("updating missing key should give ErrKeyMissing")
+	}
+
+	v := []byte("1")
+	_ = sut.Put([]byte("forever"), v, 0)
+	_ = sut.Put([]byte("gone"), v, time.Minute)
+	_ = sut.Put([]byte("kept"), v, time.Minute)
+
+	v[0] = '2'
+	got, _ := sut.Get([]byte("forever"))
+	if string(got) != "1" {
+		t.Fatal("put should copy the value")
+	}
+
+	clk.Advance(59 * time.Second)
+
+	err = sut.Update([]byte("kept"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sut.Get([]byte("gone"))
+	if err != nil {
+		t.Fatalf("key should live until its ttl. %v", err)
+	}
+
+	clk.Advance(time.Second)
+
+	_, err = sut.Get([]byte("gone"))
+	if !errors.Is(err, ErrKeyMissing) {
+		t.Fatalf("expired key should give ErrKeyMissing. got %v", err)
+	}
+
+	if !errors.Is(sut.Update([]byte("gone"), time.Hour), ErrKeyMissing) {
+		t.Fatal("expired key can't be updated")
+	}
+
+	got, err = sut.Get([]byte("kept"))
+	if err != nil || string(got) != "1" {
+		t.Fatalf("update should refresh ttl. %q %v", got, err)
+	}
+
+	clk.Advance(time.Minute)
+
+	if _, err = sut.Get([]byte("kept")); !errors.Is(err, ErrKeyMissing) {
+		t.Fatal("refreshed key should expire a ttl after the update")
+	}
+
+	clk.Advance(24 * time.Hour)
+
+	if _, err = sut.Get([]byte("forever")); err != nil {
+		t.Fatal("key without ttl should never expire")
+	}
+}
@@ -18,17 +18,101 @@ type Storage interface {
 	Update(key []byte, ttl time.Duration) error
 }
 
+// ErrKeyMissing is returned for keys that were never set or have expired.
 var ErrKeyMissing = errors.New("key does not exist")
 
+var _ Storage = (*Store)(nil)
+
 func Open(path string) (*Store, error) {
-	db, err := badger.Open(badger.DefaultOptions(path))
+	db, err := badger.Open(
+		badger.DefaultOptions(path).WithLoggingLevel(badger.WARNING),
+	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database at path %s", path)
+		return nil, fmt.Errorf("failed to open database at path %s. %w", path, err)
 	}
 
 	return &Store{db}, nil
 }
 
+func (s *Store) Get(key []byte) ([]byte, error) {
+	var out []byte
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrKeyMissing
+		}
+		if err != nil {
+			return err
+		}
+
+		out, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key. %w", err)
+	}
+
+	return out, nil
+}
+
+// Put sets key to value. A ttl of 0 means the key never expires.
+func (s *Store) Put(key []byte, value []byte, ttl time.Duration) error {
+	err := s.db.Update(func(txn *badger.Txn) error {
+		e := badger.NewEntry(key, value)
+		if ttl > 0 {
+			e = e.WithTTL(ttl)
+		}
+
+		return txn.SetEntry(e)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put key. %w", err)
+	}
+
+	return nil
+}
+
+// Update gives key a new ttl, counting from now.
+func (s *Store) Update(key []byte, ttl time.Duration) error {
+	err := s.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrKeyMissing
+		}
+		if err != nil {
+			return err
+		}
+
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		e := badger.NewEntry(key, v)
+		if ttl > 0 {
+			e = e.WithTTL(ttl)
+		}
+
+		return txn.SetEntry(e)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update key. %w", err)
+	}
+
+	return nil
+}
+
+// DropPrefix deletes every key starting with prefix.
+func (s *Store) DropPrefix(prefix []byte) error {
+	err := s.db.DropPrefix(prefix)
+	if err != nil {
+		return fmt.Errorf("failed to drop prefix. %w", err)
+	}
+
+	return nil
+}
+
 func (s *Store) Close() error {
 	return s.db.Close()
 }
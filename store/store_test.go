@@ -0,0 +1,130 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/LibSEA/mixnet/clock"
+)
+
+func open(t *testing.T) *Store {
+	t.Helper()
+
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+func TestStore(t *testing.T) {
+	sut := open(t)
+
+	_, err := sut.Get([]byte("a"))
+	if !errors.Is(err, ErrKeyMissing) {
+		t.Fatalf("missing key should give ErrKeyMissing. got %v", err)
+	}
+
+	err = sut.Update([]byte("a"), time.Hour)
+	if !errors.Is(err, ErrKeyMissing) {
+		t.Fatalf("updating missing key should give ErrKeyMissing. got %v", err)
+	}
+
+	err = sut.Put([]byte("a"), []byte("1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := sut.Get([]byte("a"))
+	if err != nil || string(v) != "1" {
+		t.Fatalf("get should return put value. %q %v", v, err)
+	}
+
+	err = sut.Put([]byte("a"), []byte("2"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, _ = sut.Get([]byte("a"))
+	if string(v) != "2" {
+		t.Fatalf("put should overwrite. got %q", v)
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	if testing.Short() {
+		t.Skip("badger ttls have one second resolution")
+	}
+
+	sut := open(t)
+
+	_ = sut.Put([]byte("gone"), []byte("1"), time.Second)
+	_ = sut.Put([]byte("kept"), []byte("1"), time.Second)
+
+	err := sut.Update([]byte("kept"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	_, err = sut.Get([]byte("gone"))
+	if !errors.Is(err, ErrKeyMissing) {
+		t.Fatalf("expired key should give ErrKeyMissing. got %v", err)
+	}
+
+	v, err := sut.Get([]byte("kept"))
+	if err != nil || string(v) != "1" {
+		t.Fatalf("update should refresh ttl and keep value. %q %v", v, err)
+	}
+
+	err = sut.Update([]byte("gone"), time.Hour)
+	if !errors.Is(err, ErrKeyMissing) {
+		t.Fatalf("expired key can't be updated. got %v", err)
+	}
+}
+
+func TestDropPrefix(t *testing.T) {
+	for name, sut := range map[string]interface {
+		Storage
+		DropPrefix(prefix []byte) error
+	}{
+		"store":  open(t),
+		"memory": NewMemory(clock.Real{}),
+	} {
+		for _, k := range []string{"replay/a/1", "replay/a/2", "replay/b/1", "replay/"} {
+			_ = sut.Put([]byte(k), nil, 0)
+		}
+
+		err := sut.DropPrefix([]byte("replay/a/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for k, kept := range map[string]bool{
+			"replay/a/1": false,
+			"replay/a/2": false,
+			"replay/b/1": true,
+			"replay/":    true,
+		} {
+			_, err := sut.Get([]byte(k))
+			if (err == nil) != kept {
+				t.Fatalf("%s: %s should be kept %t. %v", name, k, kept, err)
+			}
+		}
+	}
+}
+
+func TestOpenError(t *testing.T) {
+	s := open(t)
+
+	// badger locks its directory so a second open fails.
+	_, err := Open(s.db.Opts().Dir)
+	if err == nil || errors.Unwrap(err) == nil {
+		t.Fatalf("open should wrap the badger error. got %v", err)
+	}
+}